@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hazcod/enpass-cli/pkg/audit"
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// audit-log subcommands
+	auditCmdVerify = "verify"
+	auditCmdShow   = "show"
+)
+
+// auditLog is the log -auditLog points to, or the one in the data directory.
+func auditLog(args *Args) *audit.Log {
+	path := *args.auditLog
+	if path == "" {
+		path = filepath.Join(dataDir(), "audit.log")
+	}
+	return audit.New(path)
+}
+
+// fieldName is how a field is named in the audit log: its label, or its type
+// when it has none.
+func fieldName(card *enpass.Card) string {
+	if card.Label != "" {
+		return card.Label
+	}
+	return card.Type
+}
+
+// auditEntry records that the command revealed or changed the field of an
+// entry when -audit is set. Secrets are only revealed once their record is on
+// disk, so failing to write it ends the command.
+func auditEntry(logger *logrus.Logger, vault entrySource, args *Args, card *enpass.Card, field string) {
+	if !*args.audit {
+		return
+	}
+	record := audit.Record{
+		Command: args.command,
+		Vault:   card.VaultName,
+		UUID:    card.UUID,
+		Title:   card.Title,
+		Field:   field,
+	}
+	if v, ok := vault.(*enpass.Vault); ok && record.Vault == "" {
+		record.Vault = v.Info().VaultName
+	}
+	if args.command == cmdGitCred && len(args.filters) > 0 {
+		record.Command += " " + args.filters[0]
+	}
+	log := auditLog(args)
+	if _, err := log.Append(record); err != nil {
+		logger.WithError(err).WithField("audit_log", log.Path()).Fatal("could not write audit log")
+	}
+}
+
+// changedFields names the fields an edit sets, for the audit log.
+func changedFields(updates *enpass.EntryData) string {
+	var fields []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"title", updates.Title != ""},
+		{"username", updates.Username != ""},
+		{"password", updates.Password != ""},
+		{"url", updates.URL != ""},
+		{"notes", updates.Notes != ""},
+		{"category", updates.Category != ""},
+		{"folders", len(updates.Folders) > 0},
+	} {
+		if f.set {
+			fields = append(fields, f.name)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// auditLogCommand handles 'audit-log verify' and 'audit-log show'. verify checks
+// the hash chain and exits with 7 when the log was tampered with; show prints
+// the records in the -format, e.g. JSON for a SIEM.
+func auditLogCommand(logger *logrus.Logger, args *Args) {
+	if len(args.filters) != 1 {
+		logger.Fatal("usage: audit-log verify|show")
+	}
+	log := auditLog(args)
+
+	switch args.filters[0] {
+	case auditCmdVerify:
+		last, err := log.Verify()
+		var tamperErr *audit.TamperError
+		if errors.As(err, &tamperErr) {
+			logger.WithError(err).WithField("audit_log", log.Path()).Error("audit log verification failed")
+			logger.Exit(exitProblems)
+		} else if err != nil {
+			logger.WithError(err).Fatal("could not verify audit log")
+		}
+
+		type verification struct {
+			Path    string `json:"path"`
+			Records int64  `json:"records"`
+			Last    string `json:"last_hash,omitempty"`
+		}
+		result := verification{Path: log.Path()}
+		if last != nil {
+			result.Records, result.Last = last.Seq, last.Hash
+		}
+		writeOutput(logger, args, &output{
+			name:  "audit",
+			items: []interface{}{result},
+			value: result,
+			text: func() {
+				// the last hash can be kept elsewhere to detect records removed from the end
+				logger.Printf("%s: %d records verified, last hash %s", result.Path, result.Records, result.Last)
+			},
+		})
+	case auditCmdShow:
+		records, err := log.Records()
+		if err != nil {
+			logger.WithError(err).Fatal("could not read audit log")
+		}
+		writeOutput(logger, args, &output{
+			name:  "audit",
+			items: asItems(records),
+			value: records,
+			text: func() {
+				for _, r := range records {
+					line := fmt.Sprintf("%d %s %s %s", r.Seq, r.Time.Format("2006-01-02T15:04:05Z07:00"), r.User, r.Command)
+					if r.Title != "" || r.UUID != "" {
+						line += fmt.Sprintf(" %q (%s)", r.Title, r.UUID)
+					}
+					if r.Field != "" {
+						line += " field=" + r.Field
+					}
+					if r.Request != nil {
+						line += fmt.Sprintf(" %s %s %d token=%s", r.Request.Method, r.Request.Path, r.Request.Status, r.Request.Token)
+					}
+					fmt.Println(line)
+				}
+			},
+		})
+	default:
+		logger.Fatal("usage: audit-log verify|show")
+	}
+}
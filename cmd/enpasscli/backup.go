@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// backup subcommands
+	backupCmdCreate  = "create"
+	backupCmdList    = "list"
+	backupCmdRestore = "restore"
+
+	// number of backups kept per vault unless -backupKeep says otherwise
+	defaultBackupKeep = 10
+)
+
+// backupDir returns the directory holding the backups of the given vault.
+// Backups are kept per vault UUID so vaults sharing a directory name don't mix.
+func backupDir(vault *enpass.Vault, args *Args) string {
+	if *args.backupDir != "" {
+		return *args.backupDir
+	}
+
+	name := vault.Info().VaultUUID
+	if name == "" {
+		vaultPath, _ := filepath.EvalSymlinks(*args.vaultPath)
+		name = filepath.Base(vaultPath)
+	}
+	return filepath.Join(dataDir(), "backups", name)
+}
+
+// dataDir returns the directory enpass-cli keeps its data in,
+// $XDG_DATA_HOME/enpass-cli.
+func dataDir() string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = os.TempDir()
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "enpass-cli")
+}
+
+// backupBeforeWrite snapshots the vault before a mutating command runs and
+// drops the oldest snapshots beyond -backupKeep.
+func backupBeforeWrite(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+	if *args.backupKeep <= 0 {
+		logger.Debug("backups disabled")
+		return
+	}
+
+	dir := backupDir(vault, args)
+	backup, err := vault.Backup(dir, args.command)
+	if err != nil {
+		logger.WithError(err).Fatal("could not back up vault, refusing to modify it")
+	}
+	logger.WithField("backup", backup.ID).WithField("dir", dir).Debug("backed up vault")
+
+	if err := enpass.PruneBackups(dir, *args.backupKeep); err != nil {
+		logger.WithError(err).Warn("could not remove old backups")
+	}
+}
+
+// reopenForWrite opens a vault that was opened read-only for writing, and
+// backs it up, for commands that only know whether they write after reading.
+func reopenForWrite(logger *logrus.Logger, vault *enpass.Vault, args *Args, credentials *enpass.VaultCredentials) {
+	if vault.IsReadOnly() {
+		vault.Close()
+		if err := vault.Open(credentials); err != nil {
+			logger.WithError(err).Fatal("could not open vault for writing")
+		}
+	}
+	backupBeforeWrite(logger, vault, args)
+}
+
+// backupNeedsVault reports whether the backup subcommand reads the database
+// and therefore needs the vault to be unlocked. restore snapshots the current
+// vault through the database first.
+func backupNeedsVault(args *Args) bool {
+	return backupSubcommand(args) != backupCmdList
+}
+
+func backupSubcommand(args *Args) string {
+	if len(args.filters) == 0 {
+		return backupCmdCreate
+	}
+	return args.filters[0]
+}
+
+// backupCommand handles 'backup [create|list|restore ID]'. The vault is
+// opened for create and restore; list works on the backup files directly.
+func backupCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+	dir := backupDir(vault, args)
+
+	switch backupSubcommand(args) {
+	case backupCmdCreate:
+		backup, err := vault.Backup(dir, cmdBackup)
+		if err != nil {
+			logger.WithError(err).Fatal("could not back up vault")
+		}
+		if err := enpass.PruneBackups(dir, max(*args.backupKeep, 1)); err != nil {
+			logger.WithError(err).Warn("could not remove old backups")
+		}
+		logger.Printf("Created backup: %s", backup.ID)
+
+	case backupCmdList:
+		backups, err := enpass.ListBackups(dir)
+		if err != nil {
+			logger.WithError(err).Fatal("could not list backups")
+		}
+		writeOutput(logger, args, &output{
+			name:  "backups",
+			items: asItems(backups),
+			text: func() {
+				for _, b := range backups {
+					logger.Printf("> id: %s  created: %s  reason: %s", b.ID, b.CreatedAt.Local().Format("2006-01-02 15:04:05"), b.Reason)
+				}
+			},
+		})
+
+	case backupCmdRestore:
+		if len(args.filters) != 2 {
+			logger.Fatal("usage: backup restore ID")
+		}
+		id := args.filters[1]
+		if !*args.force {
+			if !confirm(logger, args, fmt.Sprintf("Replace vault with backup '%s'?", id)) {
+				logger.Info("cancelled")
+				return
+			}
+		}
+		// -force also restores a backup of another vault
+		current, err := vault.RestoreBackup(dir, id, *args.force)
+		if err != nil {
+			logger.WithError(err).Fatal("could not restore backup")
+		}
+		logger.Printf("Restored backup %s (previous vault saved as %s)", id, current.ID)
+
+	default:
+		logger.Fatalf("unknown backup command %q: expected create, list or restore", args.filters[0])
+	}
+}
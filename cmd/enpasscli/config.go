@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hazcod/enpass-cli/pkg/api"
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// location of the config file below the user config directory
+	configDirName  = "enpass-cli"
+	configFileName = "config.toml"
+
+	// profile used when neither -profile, ENPASS_PROFILE nor default_profile pick one
+	discoveredDefaultProfile = "primary"
+
+	// clipboard backends
+	clipboardBackendClipboard = "clipboard"
+	clipboardBackendPrimary   = "primary"
+)
+
+// Config is the contents of config.toml.
+type Config struct {
+	// DefaultProfile is used when no profile is selected on the command line
+	DefaultProfile string `toml:"default_profile"`
+	// DataDirs are Enpass data directories searched for Vaults/<name>/vault.enpassdb.
+	// The platform defaults are used when empty.
+	DataDirs []string `toml:"data_dirs"`
+	// Profiles by name
+	Profiles map[string]Profile `toml:"profiles"`
+}
+
+// Profile holds the settings for one vault. Unset fields leave the flag
+// defaults alone, and flags given on the command line always win.
+type Profile struct {
+	Vault        string     `toml:"vault"`
+	Keyfile      string     `toml:"keyfile"`
+	Sort         *sortOrder `toml:"sort"`
+	And          *bool      `toml:"and"`
+	Detailed     *bool      `toml:"detailed"`
+	Clipboard    string     `toml:"clipboard"`
+	Pin          *bool      `toml:"pin"`
+	PinIterCount int        `toml:"pin_iter_count"`
+	BackupDir    string     `toml:"backup_dir"`
+	BackupKeep   *int       `toml:"backup_keep"`
+	RecordUsage  *bool      `toml:"record_usage"`
+	// MatchRules are domains the match command treats as one site
+	MatchRules []enpass.URLRule `toml:"match_rules"`
+	// AllowedExtensions are the browser extensions native-host answers
+	AllowedExtensions []string `toml:"allowed_extensions"`
+	// SSHCategory and SSHKeyLabels tell ssh-agent where private keys are stored
+	SSHCategory  string   `toml:"ssh_category"`
+	SSHKeyLabels []string `toml:"ssh_key_labels"`
+	// APITokens are the clients serve answers
+	APITokens []api.Token `toml:"api_tokens"`
+	// Audit records secrets revealed and entries changed in AuditLog
+	Audit    *bool  `toml:"audit"`
+	AuditLog string `toml:"audit_log"`
+}
+
+// profileSummary describes a selectable profile for the 'profiles' command.
+type profileSummary struct {
+	Name       string `json:"name"`
+	Vault      string `json:"vault"`
+	Discovered bool   `json:"discovered,omitempty"`
+	Default    bool   `json:"default,omitempty"`
+}
+
+func defaultConfigPath() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		var err error
+		if configHome, err = os.UserConfigDir(); err != nil {
+			return ""
+		}
+	}
+	return filepath.Join(configHome, configDirName, configFileName)
+}
+
+// loadConfig reads the config file. A missing file at the default location is
+// not an error, so the CLI keeps working without any configuration.
+func loadConfig(path string) (*Config, error) {
+	config := &Config{Profiles: map[string]Profile{}}
+
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	if path == "" {
+		return config, nil
+	}
+
+	if _, err := toml.DecodeFile(expandHome(path), config); err != nil {
+		if os.IsNotExist(errors.Cause(err)) && !explicit {
+			return config, nil
+		}
+		return nil, errors.Wrap(err, "could not read config "+path)
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]Profile{}
+	}
+	return config, nil
+}
+
+// discoveredVaults returns the vaults found in the configured or default
+// Enpass data directories, keyed by vault directory name.
+func (config *Config) discoveredVaults(logger *logrus.Logger) map[string]string {
+	dataDirs := config.DataDirs
+	if len(dataDirs) == 0 {
+		dataDirs = enpass.DefaultDataDirs()
+	}
+
+	found := map[string]string{}
+	for _, dataDir := range dataDirs {
+		vaults, err := enpass.DiscoverVaults(expandHome(dataDir))
+		if err != nil {
+			logger.WithError(err).WithField("data_dir", dataDir).Debug("could not discover vaults")
+			continue
+		}
+		for _, v := range vaults {
+			if _, exists := found[v.Name]; !exists {
+				found[v.Name] = v.Path
+			}
+		}
+	}
+	return found
+}
+
+// selectedProfile returns the profile name chosen by -profile, ENPASS_PROFILE
+// or default_profile, in that order.
+func (config *Config) selectedProfile(args *Args) string {
+	if *args.profile != "" {
+		return *args.profile
+	}
+	if env := os.Getenv("ENPASS_PROFILE"); env != "" {
+		return env
+	}
+	return config.DefaultProfile
+}
+
+// applyProfile fills in every flag that wasn't passed explicitly from the
+// selected profile. Without a selected profile and without -vault, the
+// discovered "primary" vault is used, mirroring the Enpass app's default.
+func applyProfile(logger *logrus.Logger, config *Config, args *Args) error {
+	name := config.selectedProfile(args)
+	if name == "" && *args.vaultPath == "" {
+		name = discoveredDefaultProfile
+	}
+	if name == "" {
+		return nil
+	}
+
+	profile, configured := config.Profiles[name]
+	if !configured {
+		vaultPath, discovered := config.discoveredVaults(logger)[name]
+		if !discovered {
+			if config.selectedProfile(args) == "" {
+				// only the implicit fallback was tried
+				return nil
+			}
+			return errors.New("unknown profile: " + name)
+		}
+		profile = Profile{Vault: vaultPath}
+	}
+	logger.WithField("profile", name).Debug("using profile")
+
+	if profile.Vault != "" && !isFlagPassed("vault") {
+		*args.vaultPath = expandHome(profile.Vault)
+	}
+	if profile.Keyfile != "" && !isFlagPassed("keyfile") {
+		*args.keyFilePath = expandHome(profile.Keyfile)
+	}
+	if profile.Sort != nil && !isFlagPassed("sort") {
+		args.sort = *profile.Sort
+	}
+	if profile.And != nil && !isFlagPassed("and") {
+		*args.and = *profile.And
+	}
+	if profile.Detailed != nil && !isFlagPassed("detailed") {
+		*args.detailed = *profile.Detailed
+	}
+	if profile.Pin != nil && !isFlagPassed("pin") {
+		*args.pinEnable = *profile.Pin
+	}
+	if profile.BackupDir != "" && !isFlagPassed("backupDir") {
+		*args.backupDir = expandHome(profile.BackupDir)
+	}
+	if profile.BackupKeep != nil && !isFlagPassed("backupKeep") {
+		*args.backupKeep = *profile.BackupKeep
+	}
+	if profile.RecordUsage != nil && !isFlagPassed("recordUsage") {
+		*args.recordUsage = *profile.RecordUsage
+	}
+	args.pinIterCount = profile.PinIterCount
+	args.urlRules = profile.MatchRules
+	if len(profile.AllowedExtensions) > 0 && !isFlagPassed("allowedExtensions") {
+		args.allowedExtensions = profile.AllowedExtensions
+	}
+	if profile.SSHCategory != "" && !isFlagPassed("sshCategory") {
+		*args.sshCategory = profile.SSHCategory
+	}
+	args.sshKeyLabels = profile.SSHKeyLabels
+	args.apiTokens = profile.APITokens
+	if profile.Audit != nil && !isFlagPassed("audit") {
+		*args.audit = *profile.Audit
+	}
+	if profile.AuditLog != "" && !isFlagPassed("auditLog") {
+		*args.auditLog = expandHome(profile.AuditLog)
+	}
+
+	switch profile.Clipboard {
+	case "", clipboardBackendClipboard:
+	case clipboardBackendPrimary:
+		if !isFlagPassed("clipboardPrimary") {
+			*args.clipboardPrimary = true
+		}
+	default:
+		return fmt.Errorf("profile %s: unknown clipboard backend %q: expected %s or %s",
+			name, profile.Clipboard, clipboardBackendClipboard, clipboardBackendPrimary)
+	}
+
+	return nil
+}
+
+// listProfiles prints the configured profiles followed by the discovered
+// vaults that aren't configured explicitly.
+func listProfiles(logger *logrus.Logger, config *Config, args *Args) {
+	summaries := make([]profileSummary, 0, len(config.Profiles))
+	for name, profile := range config.Profiles {
+		summaries = append(summaries, profileSummary{
+			Name:    name,
+			Vault:   expandHome(profile.Vault),
+			Default: name == config.DefaultProfile,
+		})
+	}
+	for name, vaultPath := range config.discoveredVaults(logger) {
+		if _, configured := config.Profiles[name]; configured {
+			continue
+		}
+		summaries = append(summaries, profileSummary{Name: name, Vault: vaultPath, Discovered: true})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Discovered != summaries[j].Discovered {
+			return !summaries[i].Discovered
+		}
+		return summaries[i].Name < summaries[j].Name
+	})
+
+	writeOutput(logger, args, &output{
+		name:  "profiles",
+		items: asItems(summaries),
+		text: func() {
+			for _, s := range summaries {
+				format := "> profile: %s  vault: %s"
+				if s.Default {
+					format += "  [default]"
+				}
+				if s.Discovered {
+					format += "  [discovered]"
+				}
+				logger.Printf(format, s.Name, s.Vault)
+			}
+		},
+	})
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
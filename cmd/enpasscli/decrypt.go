@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/hazcod/enpass-cli/pkg/audit"
+	"github.com/hazcod/enpass-cli/pkg/decrypt"
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+// decryptCommand handles 'decrypt REF|FILTER': it decrypts the age or OpenPGP
+// message on stdin with the key held by the entry and writes the plaintext to
+// stdout or -out. The key field is the one the reference or -field names, or
+// else the field or notes holding a private key.
+func decryptCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+	if len(args.filters) != 1 {
+		logger.Fatal("usage: decrypt REF|FILTER < MESSAGE")
+	}
+	filter := args.filters[0]
+
+	var card, field *enpass.Card
+	var err error
+	switch {
+	case enpass.IsRef(filter):
+		card, err = vault.Resolve(filter)
+		field = card
+	case *args.field != "":
+		_, card, err = resolveField(vault, "", filter, *args.field)
+		field = card
+	default:
+		card, err = vault.GetEntry("", args.filters, true)
+	}
+	if err != nil {
+		logger.WithError(err).Fatal("could not retrieve key entry")
+	}
+	log := logger.WithField("title", card.Title)
+
+	fields, err := vault.GetEntryFields(card.UUID)
+	if err != nil {
+		log.WithError(err).Fatal("could not retrieve key entry")
+	}
+	key, err := decrypt.EntryKey(fields, field)
+	if err != nil {
+		log.WithError(err).Fatal("could not read key")
+	}
+
+	// without a named field the key may come from any field or the notes
+	keyField := audit.FieldAll
+	if field != nil {
+		keyField = fieldName(field)
+	}
+	auditEntry(logger, vault, args, card, keyField)
+
+	var out io.Writer = os.Stdout
+	var plaintext bytes.Buffer
+	if *args.out != "" {
+		out = &plaintext
+	}
+	format, err := decrypt.Decrypt(os.Stdin, key, out)
+	if err != nil {
+		log.WithError(err).Fatal("could not decrypt message")
+	}
+	log.WithField("format", format).Debug("decrypted message")
+
+	if *args.out != "" {
+		if err := writeSecretFile(*args.out, plaintext.Bytes()); err != nil {
+			log.WithError(err).Fatal("could not write output")
+		}
+		log.WithField("path", *args.out).Debug("wrote plaintext")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// fewer PBKDF2 iterations than Enpass used before raising them to 320000
+	minKDFIterations = 100000
+)
+
+// doctorReport is what 'doctor' finds out about the vault.
+type doctorReport struct {
+	Path                string   `json:"path"`
+	VaultName           string   `json:"vault_name"`
+	VaultUUID           string   `json:"vault_uuid"`
+	VaultVersion        int      `json:"vault_version"`
+	EncryptionAlgo      string   `json:"encryption_algo"`
+	KDFAlgo             string   `json:"kdf_algo"`
+	KDFIterations       int      `json:"kdf_iterations"`
+	Keyfile             bool     `json:"keyfile"`
+	CipherVersion       string   `json:"cipher_version"`
+	CipherCompatibility int      `json:"cipher_compatibility"`
+	MissingColumns      []string `json:"missing_columns,omitempty"`
+	Integrity           string   `json:"integrity"`
+	// Problems keep the vault from being read, or show it is damaged
+	Problems []string `json:"problems,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// doctorCommand handles 'doctor': it reports the vault version, SQLCipher
+// compatibility, key derivation settings, schema and database integrity, and
+// exits with 7 when it finds problems. openErr is the error opening the vault,
+// which it reports when the schema is unsupported.
+func doctorCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args, openErr error) int {
+	var schemaErr *enpass.ErrUnsupportedSchema
+	if openErr != nil && !errors.As(openErr, &schemaErr) {
+		logger.WithError(openErr).WithField("exit_code", exitOpen).Error("could not open vault")
+		return exitOpen
+	}
+
+	info, schema := vault.Info(), vault.Schema()
+	report := doctorReport{
+		Path:                *args.vaultPath,
+		VaultName:           info.VaultName,
+		VaultUUID:           info.VaultUUID,
+		VaultVersion:        info.VaultVersion,
+		EncryptionAlgo:      info.EncryptionAlgo,
+		KDFAlgo:             info.KDFAlgo,
+		KDFIterations:       info.KDFIterations,
+		Keyfile:             info.HasKeyfile == 1,
+		CipherVersion:       schema.CipherVersion,
+		CipherCompatibility: schema.CipherCompatibility,
+		MissingColumns:      schema.MissingColumns,
+	}
+	if schemaErr != nil {
+		report.Problems = append(report.Problems, schemaErr.Error())
+	}
+	if info.VaultVersion != enpass.KnownVaultVersion {
+		report.Warnings = append(report.Warnings,
+			fmt.Sprintf("vault version %d is unknown, this tool was written for version %d", info.VaultVersion, enpass.KnownVaultVersion))
+	}
+	if info.KDFIterations < minKDFIterations {
+		report.Warnings = append(report.Warnings,
+			fmt.Sprintf("only %d PBKDF2 iterations, change the master password in Enpass to raise them", info.KDFIterations))
+	}
+	if len(schema.MissingColumns) > 0 {
+		report.Warnings = append(report.Warnings, "optional columns are missing and read as defaults: "+strings.Join(schema.MissingColumns, ", "))
+	}
+
+	problems, err := vault.IntegrityCheck()
+	switch {
+	case err != nil:
+		report.Integrity = "unknown"
+		report.Problems = append(report.Problems, "could not check integrity: "+err.Error())
+	case len(problems) > 0:
+		report.Integrity = "damaged"
+		report.Problems = append(report.Problems, problems...)
+	default:
+		report.Integrity = "ok"
+	}
+
+	writeOutput(logger, args, &output{
+		name:  "doctor",
+		items: []interface{}{report},
+		value: report,
+		text: func() {
+			fmt.Printf("vault:       %s (%s) at %s\n", report.VaultName, report.VaultUUID, report.Path)
+			fmt.Printf("version:     %d\n", report.VaultVersion)
+			fmt.Printf("encryption:  %s, SQLCipher %s with version %d settings\n", report.EncryptionAlgo, report.CipherVersion, report.CipherCompatibility)
+			fmt.Printf("kdf:         %s, %d iterations, keyfile %t\n", report.KDFAlgo, report.KDFIterations, report.Keyfile)
+			fmt.Printf("integrity:   %s\n", report.Integrity)
+			for _, warning := range report.Warnings {
+				fmt.Printf("warning:     %s\n", warning)
+			}
+			for _, problem := range report.Problems {
+				fmt.Printf("problem:     %s\n", problem)
+			}
+		},
+	})
+
+	if len(report.Problems) > 0 {
+		return exitProblems
+	}
+	return exitOK
+}
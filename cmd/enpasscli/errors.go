@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Exit codes of the CLI, documented in the README. run and inject exit with
+// the code of their command instead.
+const (
+	exitOK        = 0
+	exitFailure   = 1
+	exitOpen      = 2
+	exitNotFound  = 3
+	exitAmbiguous = 4
+	exitBusy      = 5
+	exitSchema    = 6
+	// doctor, fsck or audit-log verify ran and found problems
+	exitProblems = 7
+)
+
+// errorCode returns the code of an error for -json output and the exit code
+// it maps to.
+func errorCode(err error) (string, int) {
+	var ambiguous *enpass.ErrAmbiguous
+	var schemaErr *enpass.ErrUnsupportedSchema
+	switch {
+	case errors.As(err, &ambiguous):
+		return "ambiguous", exitAmbiguous
+	case errors.As(err, &schemaErr):
+		return "unsupported_schema", exitSchema
+	case errors.Is(err, enpass.ErrNotFound):
+		return "not_found", exitNotFound
+	case errors.Is(err, enpass.ErrDeleted):
+		return "deleted", exitNotFound
+	case errors.Is(err, enpass.ErrWrongPassword):
+		return "wrong_password", exitOpen
+	case errors.Is(err, enpass.ErrKeyfileRequired):
+		return "keyfile_required", exitOpen
+	case errors.Is(err, enpass.ErrKeyfileNotNeeded):
+		return "keyfile_not_needed", exitOpen
+	case errors.Is(err, enpass.ErrNoVault):
+		return "no_vault", exitOpen
+	case errors.Is(err, enpass.ErrBusy):
+		return "busy", exitBusy
+	case errors.Is(err, enpass.ErrConflict):
+		return "conflict", exitBusy
+	case errors.Is(err, enpass.ErrReadOnly):
+		return "read_only", exitFailure
+	}
+	return "error", exitFailure
+}
+
+// errorMatch is an entry of an ambiguous error in -json output.
+type errorMatch struct {
+	UUID  string `json:"uuid"`
+	Title string `json:"title"`
+	Vault string `json:"vault,omitempty"`
+}
+
+// errorHook classifies the errors logged with WithError. A fatal error sets
+// the exit code, and with -json the code and the matches of an ambiguous
+// error are added to the logged object. An exit_code field set by the caller
+// takes precedence, e.g. to exit with 2 for any error opening the vault.
+type errorHook struct {
+	json     bool
+	exitCode int
+}
+
+func (h *errorHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (h *errorHook) Fire(entry *logrus.Entry) error {
+	err, ok := entry.Data[logrus.ErrorKey].(error)
+	if !ok {
+		return nil
+	}
+
+	code, exitCode := errorCode(err)
+	if preset, ok := entry.Data["exit_code"].(int); ok {
+		exitCode = preset
+	}
+	if entry.Level == logrus.FatalLevel {
+		h.exitCode = exitCode
+	}
+	if !h.json {
+		delete(entry.Data, "exit_code")
+		return nil
+	}
+
+	entry.Data["code"] = code
+	entry.Data["exit_code"] = exitCode
+	var ambiguous *enpass.ErrAmbiguous
+	if errors.As(err, &ambiguous) {
+		matches := make([]errorMatch, 0, len(ambiguous.Matches))
+		for _, card := range ambiguous.Matches {
+			matches = append(matches, errorMatch{UUID: card.UUID, Title: card.Title, Vault: card.VaultName})
+		}
+		entry.Data["matches"] = matches
+	}
+	return nil
+}
+
+// setupErrors installs the error hook on the logger so that fatal errors exit
+// with their documented code. With -json everything is logged as JSON
+// objects, so errors can be parsed from stderr.
+func setupErrors(logger *logrus.Logger, jsonOutput bool) {
+	hook := &errorHook{json: jsonOutput}
+	logger.AddHook(hook)
+	if jsonOutput {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	logger.ExitFunc = func(code int) {
+		if code == exitFailure && hook.exitCode != exitOK {
+			code = hook.exitCode
+		}
+		os.Exit(code)
+	}
+}
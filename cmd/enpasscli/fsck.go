@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+// fsckResult is what 'fsck' prints: the problems found, and with -repair what
+// was fixed and the problems left.
+type fsckResult struct {
+	*enpass.CheckReport
+	Fixed []string `json:"fixed,omitempty"`
+}
+
+// printFieldProblems prints the fields of one kind of problem found by fsck.
+func printFieldProblems(kind string, problems []enpass.FieldProblem) {
+	for _, p := range problems {
+		name := p.Label
+		if name == "" {
+			name = p.Type
+		}
+		fmt.Printf("%s: %q (%s) field %s (uid %d): %s\n", kind, p.Title, p.ItemUUID, name, p.FieldUID, p.Problem)
+	}
+}
+
+// fsckCommand handles 'fsck': it checks the database integrity, orphaned and
+// undecryptable fields, duplicate UUIDs and the entry count of vault.json.
+// The vault is opened read-only. With -repair and problems found that can be
+// fixed without losing data, it is reopened for writing and backed up, also
+// when -backupKeep disables backups, before they are fixed. A damaged database
+// is left alone. It exits with 7 when problems are left.
+func fsckCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args, credentials *enpass.VaultCredentials) int {
+	report, err := vault.Check()
+	if err != nil {
+		logger.WithError(err).Fatal("could not check vault")
+	}
+
+	result := fsckResult{CheckReport: report}
+	if *args.repair && report.Fixable() {
+		reopenForWrite(logger, vault, args, credentials)
+		if *args.backupKeep <= 0 {
+			// repairing deletes rows, so never without a backup
+			backup, err := vault.Backup(backupDir(vault, args), cmdFsck)
+			if err != nil {
+				logger.WithError(err).Fatal("could not back up vault, refusing to repair it")
+			}
+			logger.WithField("backup", backup.ID).Info("backups are disabled, backed up the vault before repairing it anyway")
+		}
+		result.Fixed, err = vault.Repair(report)
+		if err != nil {
+			logger.WithError(err).Fatal("could not repair vault")
+		}
+		if result.CheckReport, err = vault.Check(); err != nil {
+			logger.WithError(err).Fatal("could not check vault after repairing it")
+		}
+	}
+	report = result.CheckReport
+
+	writeOutput(logger, args, &output{
+		name:  "fsck",
+		items: []interface{}{result},
+		value: result,
+		text: func() {
+			for _, fixed := range result.Fixed {
+				fmt.Printf("fixed: %s\n", fixed)
+			}
+			for _, problem := range report.Integrity {
+				fmt.Printf("integrity: %s\n", problem)
+			}
+			printFieldProblems("orphaned", report.OrphanFields)
+			printFieldProblems("undecryptable", report.UndecryptableFields)
+			for _, duplicate := range report.DuplicateUUIDs {
+				fmt.Printf("duplicate: entry UUID %s is used more than once\n", duplicate)
+			}
+			if report.CountMismatch() {
+				fmt.Printf("count: vault.json counts %d entries, the database holds %d\n", report.InfoItemCount, report.ItemCount)
+			}
+			if report.OK() {
+				fmt.Printf("%d entries, no problems found\n", report.ItemCount)
+				return
+			}
+			if !*args.repair && report.Fixable() {
+				fmt.Println("run with -repair to delete orphaned fields and correct the count")
+			}
+			if !report.Repairable() {
+				fmt.Println("damaged, undecryptable and duplicate entries can't be repaired, restore a backup with 'backup restore'")
+			}
+		},
+	})
+
+	if !report.OK() {
+		return exitProblems
+	}
+	return exitOK
+}
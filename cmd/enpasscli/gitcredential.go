@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// actions git passes to credential helpers
+	gitCredentialGet   = "get"
+	gitCredentialStore = "store"
+	gitCredentialErase = "erase"
+)
+
+// gitCredentialCommand handles 'git-credential get|store|erase', the git
+// credential helper protocol: the request is read as key=value lines from
+// stdin and get answers with the same lines on stdout. Actions git may add
+// later are ignored, as the protocol asks of helpers. The vault is opened
+// read-only: git stores the credential after every successful login, so store
+// and erase only reopen it for writing, and back it up, when an entry changes.
+func gitCredentialCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args, credentials *enpass.VaultCredentials) {
+	if len(args.filters) != 1 {
+		logger.Fatal("usage: git-credential get|store|erase")
+	}
+	action := args.filters[0]
+	switch action {
+	case gitCredentialGet, gitCredentialStore, gitCredentialErase:
+	default:
+		logger.WithField("action", action).Debug("ignoring unknown git credential action")
+		return
+	}
+
+	cred, err := enpass.ReadGitCredential(os.Stdin)
+	if err != nil {
+		logger.WithError(err).Fatal("could not read git credential")
+	}
+	log := logger.WithField("url", cred.URL())
+
+	switch action {
+	case gitCredentialGet:
+		found, card, err := vault.GetGitCredential(cred)
+		if err != nil {
+			log.WithError(err).Fatal("could not look up git credential")
+		}
+		if found == nil {
+			// git goes on with the next helper or prompts
+			log.Debug("no entry matches")
+			return
+		}
+		auditEntry(logger, vault, args, card, fieldName(card))
+		if err := found.Write(os.Stdout); err != nil {
+			log.WithError(err).Fatal("could not write git credential")
+		}
+	case gitCredentialStore:
+		changes, err := vault.WouldStoreGitCredential(cred)
+		if err != nil {
+			log.WithError(err).Fatal("could not store git credential")
+		}
+		if !changes {
+			log.Debug("git credential is stored already")
+			return
+		}
+		reopenForWrite(logger, vault, args, credentials)
+		entryUUID, err := vault.StoreGitCredential(cred)
+		if err != nil {
+			log.WithError(err).Fatal("could not store git credential")
+		}
+		auditGitCredential(logger, vault, args, entryUUID, cred)
+		log.WithField("uuid", entryUUID).Debug("stored git credential")
+	case gitCredentialErase:
+		changes, err := vault.WouldEraseGitCredential(cred)
+		if err != nil {
+			log.WithError(err).Fatal("could not erase git credential")
+		}
+		if !changes {
+			log.Debug("no entry matches the rejected git credential")
+			return
+		}
+		reopenForWrite(logger, vault, args, credentials)
+		entryUUID, err := vault.EraseGitCredential(cred)
+		if err != nil {
+			log.WithError(err).Fatal("could not erase git credential")
+		}
+		if entryUUID != "" {
+			auditGitCredential(logger, vault, args, entryUUID, cred)
+			log.WithField("uuid", entryUUID).Info("moved rejected git credential to the trash")
+		}
+	}
+}
+
+// auditGitCredential records the entry store or erase changed, by its title
+// or, when it can't be read back, the host of the credential.
+func auditGitCredential(logger *logrus.Logger, vault *enpass.Vault, args *Args, entryUUID string, cred *enpass.GitCredential) {
+	card := &enpass.Card{UUID: entryUUID, Title: cred.Host}
+	if entry, err := vault.GetEntryByUUID(entryUUID); err == nil {
+		card.Title = entry.Title
+	}
+	auditEntry(logger, vault, args, card, "")
+}
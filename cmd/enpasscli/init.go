@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+// initCommand handles 'init [NAME]': it creates an empty vault in -vault,
+// named after its directory unless NAME is given. The password is taken from
+// MASTERPW, or asked for twice.
+func initCommand(logger *logrus.Logger, args *Args) {
+	if *args.vaultPath == "" {
+		logger.Fatal("specify the directory of the new vault with -vault")
+	}
+	if len(args.filters) > 1 {
+		logger.Fatal("usage: init [NAME]")
+	}
+	path := expandHome(*args.vaultPath)
+	name := filepath.Base(path)
+	if len(args.filters) == 1 {
+		name = args.filters[0]
+	}
+
+	credentials := &enpass.VaultCredentials{
+		Password:    os.Getenv("MASTERPW"),
+		KeyfilePath: *args.keyFilePath,
+	}
+	if credentials.Password == "" {
+		credentials.Password = prompt(logger, args, "new vault password")
+		if credentials.Password == "" {
+			logger.Fatal("the new vault needs a password, set MASTERPW when prompts are disabled")
+		}
+		if prompt(logger, args, "new vault password again") != credentials.Password {
+			logger.Fatal("passwords don't match")
+		}
+	}
+
+	if err := enpass.CreateVault(path, credentials, name); err != nil {
+		logger.WithError(err).Fatal("could not create vault")
+	}
+	logger.WithField("path", path).Infof("Created vault %s", name)
+}
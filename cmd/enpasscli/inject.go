@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"text/template"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// references to a field of an entry by its UUID, optionally in a given vault,
+// e.g. enpass://<uuid>/Access%20Key or enpass://Work/<uuid>/11
+var entryRefPattern = regexp.MustCompile(
+	`enpass://(?:[^/\s"'<>{}]+/)?[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}/[^/\s"'<>{}]+`)
+
+// injector resolves the vault references of a template.
+type injector struct {
+	vault    *enpass.Vault
+	cardType string
+	// check collects every error instead of failing at the first one
+	check bool
+	errs  []error
+	refs  int
+	used  []*enpass.Card
+}
+
+// injectCommand handles 'inject TEMPLATE': it renders TEMPLATE with its vault
+// references resolved to -out, or stdout. With -check nothing is written and
+// every reference that doesn't resolve is reported. It returns the exit code.
+func injectCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args) int {
+	if len(args.filters) != 1 {
+		logger.Fatal("usage: inject TEMPLATE (- reads stdin)")
+	}
+	path := args.filters[0]
+
+	var text []byte
+	var err error
+	if path == "-" {
+		text, err = io.ReadAll(os.Stdin)
+	} else {
+		text, err = os.ReadFile(path)
+	}
+	if err != nil {
+		logger.WithError(err).Fatal("could not read template")
+	}
+
+	inj := &injector{vault: vault, cardType: *args.cardType, check: *args.check}
+	rendered, err := inj.render(filepath.Base(path), string(text))
+	if err != nil {
+		logger.WithError(err).Fatal("could not render template")
+	}
+
+	if inj.check {
+		for _, refErr := range inj.errs {
+			logger.WithError(refErr).Error("unresolved reference")
+		}
+		if len(inj.errs) > 0 {
+			return 1
+		}
+		logger.Printf("all %d references resolve", inj.refs)
+		return 0
+	}
+
+	for _, card := range inj.used {
+		auditEntry(logger, vault, args, card, fieldName(card))
+	}
+
+	if *args.out == "" {
+		if _, err := os.Stdout.Write(rendered); err != nil {
+			logger.WithError(err).Fatal("could not write output")
+		}
+		return 0
+	}
+	if err := writeSecretFile(*args.out, rendered); err != nil {
+		logger.WithError(err).Fatal("could not write output")
+	}
+	logger.WithField("path", *args.out).Debug("wrote rendered template")
+	return 0
+}
+
+// render executes the template. enpass:// references are turned into template
+// calls first, so resolved values are never parsed themselves.
+func (inj *injector) render(name string, text string) ([]byte, error) {
+	text = entryRefPattern.ReplaceAllStringFunc(text, func(ref string) string {
+		return fmt.Sprintf("{{ enpassRef %s }}", strconv.Quote(ref))
+	})
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"enpass":    inj.byFilter,
+		"enpassRef": inj.byRef,
+	}).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse template")
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, errors.Wrap(err, "could not execute template")
+	}
+	return buf.Bytes(), nil
+}
+
+// byFilter implements {{ enpass "filter" "field" }}.
+func (inj *injector) byFilter(filter string, field string) (string, error) {
+	value, card, err := resolveField(inj.vault, inj.cardType, filter, field)
+	return inj.result(fmt.Sprintf("enpass %q %q", filter, field), value, card, err)
+}
+
+// byRef implements enpass://[<vault>/]<uuid>/<field>.
+func (inj *injector) byRef(reference string) (string, error) {
+	// errors of references already name the reference
+	value, card, err := resolveField(inj.vault, inj.cardType, reference, "")
+	return inj.result("", value, card, err)
+}
+
+func (inj *injector) result(ref string, value string, card *enpass.Card, err error) (string, error) {
+	inj.refs++
+	if err != nil {
+		if ref != "" {
+			err = errors.Wrap(err, ref)
+		}
+		if inj.check {
+			inj.errs = append(inj.errs, err)
+			return "", nil
+		}
+		return "", err
+	}
+	inj.used = append(inj.used, card)
+	return value, nil
+}
+
+// writeSecretFile replaces path with data, readable by the owner only. The
+// data is written to a temporary file first so readers never see half of it.
+func writeSecretFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "could not restrict permissions")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "could not write temporary file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "could not write temporary file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "could not replace "+path)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -14,30 +13,57 @@ import (
 	"time"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/hazcod/enpass-cli/pkg/api"
+	"github.com/hazcod/enpass-cli/pkg/audit"
 	"github.com/hazcod/enpass-cli/pkg/clipboard"
 	"github.com/hazcod/enpass-cli/pkg/enpass"
 	"github.com/hazcod/enpass-cli/pkg/unlock"
 	"github.com/miquella/ask"
+	"github.com/pkg/errors"
 	"github.com/rivo/tview"
 	"github.com/sirupsen/logrus"
 )
 
 const (
 	// commands
-	cmdVersion = "version"
-	cmdHelp    = "help"
-	cmdDryRun  = "dryrun"
-	cmdList    = "list"
-	cmdShow    = "show"
-	cmdCopy    = "copy"
-	cmdPass    = "pass"
-	cmdUi      = "ui"
-	cmdCreate  = "create"
-	cmdEdit    = "edit"
-	cmdTrash   = "trash"
-	cmdRestore = "restore"
-	cmdDelete  = "delete"
-	cmdEnv     = "env"
+	cmdVersion  = "version"
+	cmdHelp     = "help"
+	cmdDryRun   = "dryrun"
+	cmdList     = "list"
+	cmdShow     = "show"
+	cmdCopy     = "copy"
+	cmdPass     = "pass"
+	cmdUi       = "ui"
+	cmdCreate   = "create"
+	cmdEdit     = "edit"
+	cmdTrash    = "trash"
+	cmdRestore  = "restore"
+	cmdDelete   = "delete"
+	cmdEnv      = "env"
+	cmdRun      = "run"
+	cmdInject   = "inject"
+	cmdRef      = "ref"
+	cmdGitCred  = "git-credential"
+	cmdMatch    = "match"
+	cmdNative   = "native-host"
+	cmdSSHAgent = "ssh-agent"
+	cmdDecrypt  = "decrypt"
+	cmdServe    = "serve"
+	cmdAuditLog = "audit-log"
+	cmdDiff     = "diff"
+	cmdMerge    = "merge"
+	cmdWatch    = "watch"
+	cmdInit     = "init"
+	cmdDoctor   = "doctor"
+	cmdFsck     = "fsck"
+	cmdBackup   = "backup"
+	cmdProfiles = "profiles"
+	cmdFolders  = "folders"
+	cmdArchive  = "archive"
+	cmdUnarch   = "unarchive"
+	cmdFavorite = "favorite"
+	cmdUnfav    = "unfavorite"
+	cmdSchema   = "schema"
 
 	// defaults
 	defaultLogLevel        = logrus.InfoLevel
@@ -53,6 +79,20 @@ var (
 		cmdVersion: {}, cmdHelp: {}, cmdDryRun: {}, cmdList: {},
 		cmdShow: {}, cmdCopy: {}, cmdPass: {}, cmdUi: {},
 		cmdCreate: {}, cmdEdit: {}, cmdTrash: {}, cmdRestore: {}, cmdDelete: {}, cmdEnv: {},
+		cmdBackup: {}, cmdProfiles: {}, cmdFolders: {},
+		cmdArchive: {}, cmdUnarch: {}, cmdFavorite: {}, cmdUnfav: {},
+		cmdRun: {}, cmdInject: {}, cmdRef: {}, cmdGitCred: {}, cmdMatch: {}, cmdNative: {}, cmdSSHAgent: {},
+		cmdDecrypt: {}, cmdServe: {}, cmdAuditLog: {}, cmdDiff: {}, cmdMerge: {},
+		cmdWatch: {}, cmdInit: {}, cmdDoctor: {}, cmdFsck: {}, cmdSchema: {},
+	}
+	// commands that only show entries filed in -folder
+	folderFilterCommands = map[string]struct{}{
+		cmdList: {}, cmdShow: {}, cmdUi: {},
+	}
+	// commands that modify the vault and need a read-write database connection
+	mutatingCommands = map[string]struct{}{
+		cmdCreate: {}, cmdEdit: {}, cmdTrash: {}, cmdRestore: {}, cmdDelete: {},
+		cmdArchive: {}, cmdUnarch: {}, cmdFavorite: {}, cmdUnfav: {}, cmdMerge: {},
 	}
 )
 
@@ -68,12 +108,38 @@ type Args struct {
 	jsonOutput       *bool
 	nonInteractive   *bool
 	pinEnable        *bool
-	sort             *bool
+	sort             sortOrder
 	trashed          *bool
+	archived         *bool
+	favorites        *bool
+	recordUsage      *bool
 	detailed         *bool
 	and              *bool
 	clipboardPrimary *bool
+	allVaults        *bool
 	field            *string
+	busyTimeout      *time.Duration
+	// run command flags
+	envFile *string
+	mask    *bool
+	// inject command flags
+	out   *string
+	check *bool
+	// ssh-agent command flags
+	sshCategory *string
+	sshConfirm  *bool
+	// serve command flags
+	listen *string
+	// diff and merge command flags
+	showSecrets *bool
+	resolve     *string
+	// watch command flags
+	hook *string
+	// fsck command flags
+	repair *bool
+	// audit flags
+	audit    *bool
+	auditLog *string
 	// write command flags
 	title    *string
 	login    *string
@@ -81,7 +147,25 @@ type Args struct {
 	url      *string
 	notes    *string
 	category *string
+	folder   *string
 	force    *bool
+	// output flags
+	view     *string
+	format   *string
+	template *string
+	// backup flags
+	backupDir  *string
+	backupKeep *int
+	// config flags
+	configPath *string
+	profile    *string
+	// settings only available through the config file
+	pinIterCount int
+	urlRules     []enpass.URLRule
+	sshKeyLabels []string
+	apiTokens    []api.Token
+	// extension IDs 'native-host' answers, from -allowedExtensions or the profile
+	allowedExtensions []string
 }
 
 func (args *Args) parse() {
@@ -89,15 +173,41 @@ func (args *Args) parse() {
 	args.cardType = flag.String("type", "password", "The type of your card. (password, ...)")
 	args.keyFilePath = flag.String("keyfile", "", "Path to your Enpass vault keyfile.")
 	args.logLevelStr = flag.String("log", defaultLogLevel.String(), "The log level: trace, debug, info, warn, error, fatal, panic.")
-	args.jsonOutput = flag.Bool("json", false, "Output data in JSON format.")
+	args.jsonOutput = flag.Bool("json", false, "Output data in JSON format. Short for -format=json.")
+	args.format = flag.String("format", formatText, "Output format: "+strings.Join(outputFormats, ", ")+".")
+	args.template = flag.String("template", "", "Go template executed for each entry with -format=template, e.g. '{{.Title}} {{field \"Access Key\"}}'.")
 	args.nonInteractive = flag.Bool("nonInteractive", false, "Disable prompts and fail instead.")
 	args.pinEnable = flag.Bool("pin", false, "Enable PIN.")
 	args.and = flag.Bool("and", false, "Combines filters with AND instead of default OR.")
-	args.sort = flag.Bool("sort", false, "Sort the output by title and username of the 'list' and 'show' command.")
+	flag.Var(&args.sort, "sort", "Sort the output of the 'list' and 'show' command by title, or most recently used first with -sort=recent.")
 	args.trashed = flag.Bool("trashed", false, "Show trashed items in the 'list' and 'show' command.")
+	args.archived = flag.Bool("archived", false, "Only show archived items in the 'list', 'show' and 'ui' command. They are hidden otherwise.")
+	args.favorites = flag.Bool("favorites", false, "Only show favorite items in the 'list', 'show' and 'ui' command.")
+	args.recordUsage = flag.Bool("recordUsage", false, "Record the use of entries by 'copy', 'pass' and 'env' in the vault, which opens it for writing.")
 	args.detailed = flag.Bool("detailed", false, "Show every field of each entry in 'list' and 'show'. Without this flag, only the original summary fields (title, login, category, label, type) are displayed.")
+	args.view = flag.String("view", viewAuto, "How 'list' and 'show' summarise entries: "+strings.Join(viewNames(), ", ")+". auto picks one by category.")
 	args.clipboardPrimary = flag.Bool("clipboardPrimary", false, "Use primary X selection instead of clipboard for the 'copy' command.")
-	args.field = flag.String("field", "", "Field label to extract (default: password). Used with 'env' command.")
+	args.busyTimeout = flag.Duration("busyTimeout", 5*time.Second, "How long to wait for a vault locked by another application, e.g. the Enpass app.")
+	args.allVaults = flag.Bool("allVaults", false, "Search every configured and discovered vault in the 'list', 'show' and 'pass' command.")
+	flag.BoolVar(args.allVaults, "all-vaults", false, "Alias of -allVaults.")
+	args.field = flag.String("field", "", "Field label to extract (default: password). Used with 'env', 'ref' and 'decrypt' command.")
+	args.envFile = flag.String("envFile", defaultRunEnvFile, "File with VARNAME=filter[:field] lines for 'run' when no mappings are given.")
+	args.mask = flag.Bool("mask", false, "Mask the resolved secrets in the output of the 'run' command.")
+	args.out = flag.String("out", "", "File the 'inject' and 'decrypt' command write to, readable by the owner only (default: stdout).")
+	args.check = flag.Bool("check", false, "Only check that every reference of the 'inject' template resolves.")
+	args.sshCategory = flag.String("sshCategory", "ssh", "Category of the entries holding the private keys of the 'ssh-agent' command in their notes or fields.")
+	args.listen = flag.String("listen", defaultServeListen, "Loopback address or unix:PATH the 'serve' command listens on.")
+	args.sshConfirm = flag.Bool("sshConfirm", false, "Ask before every signature of the 'ssh-agent' command, through SSH_ASKPASS when it is set.")
+	// diff and merge command flags
+	args.showSecrets = flag.Bool("showSecrets", false, "Show the values of passwords, other sensitive fields and notes that differ in 'diff' and 'merge'.")
+	args.resolve = flag.String("resolve", resolveNewer, "How 'merge' picks between changed entries: newer takes the version modified last, ask prompts for each.")
+	// watch command flags
+	args.hook = flag.String("hook", "", "Shell command the 'watch' command runs for every event, with the event as JSON on stdin and in ENPASS_EVENT, ENPASS_UUID and ENPASS_TITLE.")
+	// fsck command flags
+	args.repair = flag.Bool("repair", false, "Let 'fsck' delete orphaned fields and correct the entry count of vault.json, after backing up the vault.")
+	// audit flags
+	args.audit = flag.Bool("audit", false, "Record secrets revealed and entries changed in the hash-chained audit log.")
+	args.auditLog = flag.String("auditLog", "", "Audit log written by -audit and 'serve' and read by 'audit-log' (default: $XDG_DATA_HOME/enpass-cli/audit.log).")
 	// write command flags
 	args.title = flag.String("title", "", "Entry title (for create/edit).")
 	args.login = flag.String("login", "", "Username or email (for create/edit).")
@@ -105,8 +215,21 @@ func (args *Args) parse() {
 	args.url = flag.String("url", "", "URL (for create/edit).")
 	args.notes = flag.String("notes", "", "Notes (for create/edit).")
 	args.category = flag.String("category", "", "Category (for create/edit).")
+	args.folder = flag.String("folder", "", "Only show entries in this folder for list/show/ui, or the folder to file the entry in for create/edit.")
 	args.force = flag.Bool("force", false, "Skip confirmation prompts.")
+	// backup flags
+	args.backupDir = flag.String("backupDir", "", "Directory for vault backups (default: $XDG_DATA_HOME/enpass-cli/backups/<vault uuid>).")
+	args.backupKeep = flag.Int("backupKeep", defaultBackupKeep, "Number of backups to keep. Backups before commands that modify the vault are disabled when 0.")
+	// config flags
+	args.configPath = flag.String("config", "", "Path to the config file (default: $XDG_CONFIG_HOME/enpass-cli/config.toml).")
+	args.profile = flag.String("profile", "", "Name of the config profile or discovered vault to use (default: $ENPASS_PROFILE).")
+	allowedExtensions := flag.String("allowedExtensions", "", "Comma separated IDs of the browser extensions 'native-host' answers.")
 	flag.Parse()
+	for _, id := range strings.Split(*allowedExtensions, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			args.allowedExtensions = append(args.allowedExtensions, id)
+		}
+	}
 	args.command = strings.ToLower(flag.Arg(0))
 	if len(flag.Args()) > 1 {
 		args.filters = flag.Args()[1:]
@@ -135,12 +258,42 @@ func printHelp() {
 	fmt.Println("  copy <filter>     Copy password to clipboard")
 	fmt.Println("  pass <filter>     Print password to stdout")
 	fmt.Println("  env VARNAME=filter  Output entry field as KEY=VALUE for shell eval")
+	fmt.Println("  run VARNAME=filter[:field]... -- cmd  Run cmd with entry fields in its environment")
+	fmt.Println("  inject <template> Render a template with vault references ({{ enpass \"title\" \"field\" }}")
+	fmt.Println("                    or enpass://[<vault>/]<uuid>/<field>) to -out")
+	fmt.Println("  ref FILTER        Print the enpass:// reference of the password, or -field, of an entry")
+	fmt.Println("  match <url>       List entries with a URL field of the same site, best match first")
+	fmt.Println("  native-host       Answer a browser extension over native messaging, started by the browser")
+	fmt.Println("  ssh-agent [SOCKET]  Serve the private keys of -sshCategory entries as SSH agent")
+	fmt.Println("  decrypt REF|FILTER  Decrypt the age or OpenPGP message on stdin with the key of an entry")
+	fmt.Println("  serve             Serve the HTTP API on -listen for the api_tokens of the profile")
+	fmt.Println("  serve token [NAME]  Print a new API token and its profile setting")
+	fmt.Println("  audit-log verify|show  Check the hash chain of the audit log, or print its records")
+	fmt.Println("  diff VAULT|BACKUP_ID   List entries added, removed and changed in another copy of the vault")
+	fmt.Println("  merge VAULT|BACKUP_ID  Copy entries added and changed in another copy into the vault")
+	fmt.Println("  watch [filter]    Print a JSON line for every entry created, updated, trashed or deleted,")
+	fmt.Println("                    running -hook for each")
+	fmt.Println("  git-credential get|store|erase  Act as git credential helper for entries with matching URLs")
 	fmt.Println("  ui                Interactive terminal UI")
 	fmt.Println("  create            Create a new entry")
 	fmt.Println("  edit <filter>     Edit an existing entry")
 	fmt.Println("  trash <filter>    Move entry to trash")
 	fmt.Println("  restore <filter>  Restore entry from trash")
 	fmt.Println("  delete <filter>   Permanently delete entry")
+	fmt.Println("  archive <filter>  Archive entry")
+	fmt.Println("  unarchive <filter>  Move entry out of the archive")
+	fmt.Println("  favorite <filter>   Mark entry as favorite")
+	fmt.Println("  unfavorite <filter> Remove favorite mark of entry")
+	fmt.Println("  backup [create]   Back up the vault")
+	fmt.Println("  backup list       List vault backups")
+	fmt.Println("  backup restore <id>  Replace the vault with a backup")
+	fmt.Println("  init [NAME]       Create an empty vault in -vault")
+	fmt.Println("  doctor            Report vault version, encryption, KDF settings, schema and integrity")
+	fmt.Println("  fsck              Look for orphaned, undecryptable and duplicate entries; -repair fixes")
+	fmt.Println("                    the safe cases after a backup")
+	fmt.Println("  schema [KIND]     Print the JSON schema of -json rows of list and show")
+	fmt.Println("  folders           List folders")
+	fmt.Println("  profiles          List config profiles and discovered vaults")
 	fmt.Println("  dryrun            Test vault opening")
 	fmt.Println("  version           Print version")
 	fmt.Println("  help              Print this help")
@@ -150,16 +303,42 @@ func printHelp() {
 	fmt.Println("are treated as sensitive: their secret is hidden in list, and show prints")
 	fmt.Println("the current RFC 6238 code alongside the secret.")
 	fmt.Println()
+	fmt.Println("Cards, identities and Wi-Fi entries are summarised by their own fields,")
+	fmt.Println("with card numbers masked to the last 4 digits in list. Use -view to")
+	fmt.Println("pick the summary for every entry instead.")
+	fmt.Println()
 	fmt.Println("The env command outputs vault values as shell-safe KEY='value' lines.")
 	fmt.Println("Use -field to select a specific field label (default: password).")
 	fmt.Println("  eval $(enpass-cli -vault /path env MY_SECRET=\"entry title\")")
 	fmt.Println("  eval $(enpass-cli -vault /path env -field \"Access Key\" AWS_KEY=\"AWS\")")
 	fmt.Println()
+	fmt.Println("References like enpass://<vault>/<uuid>/<field> keep pointing at the same")
+	fmt.Println("field when entries are renamed; pass, copy, env, run and inject accept them:")
+	fmt.Println("  enpass-cli -vault /path pass \"$(enpass-cli -vault /path ref \"entry title\")\"")
+	fmt.Println()
+	fmt.Println("With -audit, secrets revealed and entries changed are recorded in a")
+	fmt.Println("hash-chained audit log, checked by 'audit-log verify'.")
+	fmt.Println()
+	fmt.Println("Use -format to print json, yaml, toml, csv, table or dotenv instead of")
+	fmt.Println("log lines, or -template to format each entry with a Go template:")
+	fmt.Println("  enpass-cli -template '{{.Title}} {{field \"Access Key\"}}' show AWS")
+	fmt.Println()
+	fmt.Println("Exit codes: 1 failure, 2 vault not opened (wrong password or keyfile),")
+	fmt.Println("3 entry not found, 4 several entries match, 5 vault busy or locked,")
+	fmt.Println("6 unsupported schema, 7 doctor, fsck or audit-log verify found problems.")
+	fmt.Println("With -json, errors are JSON objects on stderr.")
+	fmt.Println()
 	fmt.Println("Flags:")
 	flag.Usage()
 }
 
-func sortEntries(cards []enpass.Card) {
+func sortEntries(cards []enpass.Card, order sortOrder) {
+	if order == sortByRecent {
+		sort.SliceStable(cards, func(i, j int) bool {
+			return cards[i].LastUsed > cards[j].LastUsed
+		})
+		return
+	}
 	// Sort by username preserving original order
 	sort.SliceStable(cards, func(i, j int) bool {
 		return strings.ToLower(cards[i].Subtitle) < strings.ToLower(cards[j].Subtitle)
@@ -170,7 +349,7 @@ func sortEntries(cards []enpass.Card) {
 	})
 }
 
-func listEntries(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+func listEntries(logger *logrus.Logger, vault entrySource, args *Args) {
 	entries, err := collectEntries(vault, args, false)
 	if err != nil {
 		logger.WithError(err).Fatal(err.Error())
@@ -178,22 +357,35 @@ func listEntries(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 	outputEntriesOrLog(logger, entries, args)
 }
 
-func showEntries(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+func showEntries(logger *logrus.Logger, vault entrySource, args *Args) {
 	entries, err := collectEntries(vault, args, true)
 	if err != nil {
 		logger.WithError(err).Fatal(err.Error())
 	}
+	for _, e := range entries {
+		auditEntry(logger, vault, args, &enpass.Card{UUID: e.UUID, Title: e.Title, VaultName: e.Vault}, audit.FieldAll)
+	}
 	outputEntriesOrLog(logger, entries, args)
 }
 
 // entryView is one Enpass item with all of its fields grouped together.
 type entryView struct {
-	UUID     string       `json:"uuid"`
-	Title    string       `json:"title"`
-	Subtitle string       `json:"subtitle,omitempty"`
-	Category string       `json:"category,omitempty"`
-	Trashed  bool         `json:"trashed,omitempty"`
-	Fields   []fieldView  `json:"fields"`
+	Kind       string      `json:"kind"`
+	Vault      string      `json:"vault,omitempty"`
+	UUID       string      `json:"uuid"`
+	Title      string      `json:"title"`
+	Subtitle   string      `json:"subtitle,omitempty"`
+	Category   string      `json:"category,omitempty"`
+	Trashed    bool        `json:"trashed,omitempty"`
+	Favorite   bool        `json:"favorite,omitempty"`
+	Archived   bool        `json:"archived,omitempty"`
+	LastUsed   int64       `json:"last_used,omitempty"`
+	UsageCount int64       `json:"usage_count,omitempty"`
+	Folders    []string    `json:"folders,omitempty"`
+	Fields     []fieldView `json:"fields"`
+
+	// Enpass template the entry was created from, e.g. "login.default"
+	template string
 }
 
 // fieldView is a single field of an entry (username, email, password, ...).
@@ -201,22 +393,24 @@ type entryView struct {
 // decrypted output (list mode). For TOTP fields the stored Value is the
 // secret key, so it's treated as sensitive: hidden in list mode, included in
 // show mode. TOTPCode carries the current RFC 6238 code; TOTPError is set
-// when computing it failed.
+// when computing it failed. Card numbers are sensitive too; in list mode
+// Masked holds the number masked to its last 4 digits.
 type fieldView struct {
 	Type      string `json:"type"`
 	Label     string `json:"label,omitempty"`
 	Sensitive bool   `json:"sensitive,omitempty"`
 	Value     string `json:"value,omitempty"`
+	Masked    string `json:"masked,omitempty"`
 	TOTPCode  string `json:"totp_code,omitempty"`
 	TOTPError string `json:"totp_error,omitempty"`
 }
 
 // collectEntries fetches every field for matching entries and groups them by
-// item UUID. When includeSensitive is false, values of sensitive fields
+// item UUID (and vault, when searching several). When includeSensitive is false, values of sensitive fields
 // (passwords) are omitted while non-sensitive fields like username/email are
 // still populated — this is what powers the "list shows usernames and emails
 // but not passwords" behavior.
-func collectEntries(vault *enpass.Vault, args *Args, includeSensitive bool) ([]entryView, error) {
+func collectEntries(vault entrySource, args *Args, includeSensitive bool) ([]entryView, error) {
 	// The -type flag defaults to "password" for the copy/pass commands. For
 	// list/show we want every field type, so treat the default as "no filter".
 	// Any other explicit value still filters server-side.
@@ -239,6 +433,9 @@ func collectEntries(vault *enpass.Vault, args *Args, includeSensitive bool) ([]e
 		if c.IsTrashed() && !*args.trashed {
 			continue
 		}
+		if !showCard(c, args) {
+			continue
+		}
 		// Non-password field values are stored in cleartext; Decrypt() returns
 		// them as-is. For password fields, Decrypt() actually decrypts.
 		value, derr := c.Decrypt()
@@ -251,17 +448,27 @@ func collectEntries(vault *enpass.Vault, args *Args, includeSensitive bool) ([]e
 		if value == "" && c.Type != "section" {
 			continue
 		}
-		g, ok := groups[c.UUID]
+		key := c.VaultName + "/" + c.UUID
+		g, ok := groups[key]
 		if !ok {
 			g = &entryView{
-				UUID:     c.UUID,
-				Title:    c.Title,
-				Subtitle: c.Subtitle,
-				Category: c.Category,
-				Trashed:  c.IsTrashed(),
+				UUID:       c.UUID,
+				Title:      c.Title,
+				Subtitle:   c.Subtitle,
+				Category:   c.Category,
+				Trashed:    c.IsTrashed(),
+				Favorite:   c.IsFavorite(),
+				Archived:   c.IsArchived(),
+				LastUsed:   c.LastUsed,
+				UsageCount: c.UsageCount,
+				Folders:    c.Folders,
+				template:   c.Template,
 			}
-			groups[c.UUID] = g
-			order = append(order, c.UUID)
+			if *args.allVaults {
+				g.Vault = c.VaultName
+			}
+			groups[key] = g
+			order = append(order, key)
 		}
 		f := fieldView{
 			Type:      c.Type,
@@ -280,104 +487,154 @@ func collectEntries(vault *enpass.Vault, args *Args, includeSensitive bool) ([]e
 				f.TOTPError = terr.Error()
 			}
 		}
-		if isTOTP && hasValue {
+		if _, sensitive := sensitiveFieldTypes[c.Type]; sensitive && hasValue {
 			f.Sensitive = true
 		}
 		if includeSensitive || !f.Sensitive {
 			f.Value = value
+		} else if c.Type == fieldCardNumber {
+			f.Masked = maskCardNumber(value)
 		}
 		g.Fields = append(g.Fields, f)
 	}
 
 	entries := make([]entryView, 0, len(order))
-	for _, uuid := range order {
-		entries = append(entries, *groups[uuid])
+	for _, key := range order {
+		entries = append(entries, *groups[key])
 	}
-	if *args.sort {
-		sort.SliceStable(entries, func(i, j int) bool {
-			return strings.ToLower(entries[i].Title) < strings.ToLower(entries[j].Title)
-		})
+	for i := range entries {
+		entries[i].Kind = entryKind(&entries[i], *args.view)
 	}
+	sortEntryViews(entries, args.sort)
 	return entries, nil
 }
 
+// showCard reports whether -archived and -favorites let a card through.
+// Archived entries only show up with -archived, like in the Enpass apps.
+func showCard(c enpass.Card, args *Args) bool {
+	if c.IsArchived() != *args.archived {
+		return false
+	}
+	return c.IsFavorite() || !*args.favorites
+}
+
 func outputEntriesOrLog(logger *logrus.Logger, entries []entryView, args *Args) {
+	out := &output{
+		name:          "entries",
+		templateItems: asItems(entries),
+		env:           entriesEnv(entries),
+	}
 	if *args.detailed {
-		outputDetailed(logger, entries, args)
-		return
+		out.items = asItems(entries)
+		out.rows = entryFieldRows(entries)
+		out.text = func() { outputDetailed(logger, entries) }
+	} else {
+		rows := compactRows(entries)
+		out.items = asItems(rows)
+		out.text = func() { outputCompact(logger, rows) }
 	}
-	outputCompact(logger, entries, args)
+	writeOutput(logger, args, out)
 }
 
-// outputCompact reproduces the original list/show output: one row per entry
-// with the summary fields title, login, category, label, type — plus password
-// when present (show mode).
-func outputCompact(logger *logrus.Logger, entries []entryView, args *Args) {
-	type compactRow struct {
-		Title    string `json:"title"`
-		Login    string `json:"login"`
-		Category string `json:"category"`
-		Label    string `json:"label"`
-		Type     string `json:"type"`
-		Password string `json:"password,omitempty"`
+// compactRows summarises every entry with the renderer of its kind.
+// Logins reproduce the original list/show output: title, login, category,
+// label, type — plus password when present (show mode).
+func compactRows(entries []entryView) []compactRow {
+	rows := make([]compactRow, 0, len(entries))
+	for i := range entries {
+		rows = append(rows, renderers[entries[i].Kind].row(&entries[i]))
 	}
+	return rows
+}
 
-	rows := make([]compactRow, 0, len(entries))
+// outputCompact prints one row per entry.
+func outputCompact(logger *logrus.Logger, rows []compactRow) {
+	for _, r := range rows {
+		logger.Print(r.line())
+	}
+}
+
+// fieldRow is one field of an entry in csv and table output with -detailed.
+type fieldRow struct {
+	Vault    string `json:"vault,omitempty"`
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle"`
+	Category string `json:"category"`
+	Label    string `json:"label"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+}
+
+func entryFieldRows(entries []entryView) []interface{} {
+	rows := make([]interface{}, 0, len(entries))
 	for _, e := range entries {
-		anchor := anchorField(e.Fields)
-		row := compactRow{
-			Title:    e.Title,
-			Login:    e.Subtitle,
-			Category: e.Category,
-		}
-		if anchor != nil {
-			row.Label = anchor.Label
-			row.Type = anchor.Type
-			if anchor.Sensitive {
-				row.Password = anchor.Value
+		for i, f := range e.Fields {
+			if f.Type == "section" {
+				continue
 			}
+			value := e.Fields[i].displayValue()
+			if f.TOTPCode != "" {
+				value = f.TOTPCode
+			}
+			rows = append(rows, fieldRow{
+				Vault:    e.Vault,
+				Title:    e.Title,
+				Subtitle: e.Subtitle,
+				Category: e.Category,
+				Label:    f.Label,
+				Type:     f.Type,
+				Value:    value,
+			})
 		}
-		rows = append(rows, row)
 	}
+	return rows
+}
 
-	if *args.jsonOutput {
-		jsonData, err := json.Marshal(rows)
-		if err != nil {
-			logger.WithError(err).Fatal("could not marshal JSON data")
-		}
-		fmt.Println(string(jsonData))
-		return
-	}
-	for _, r := range rows {
-		format := "> title: %s  login: %s  cat.: %s  label: %s  type: %s"
-		vals := []any{r.Title, r.Login, r.Category, r.Label, r.Type}
-		if r.Password != "" {
-			format += "  password: %s"
-			vals = append(vals, r.Password)
+// entriesEnv names every shown field value TITLE_LABEL for dotenv output.
+func entriesEnv(entries []entryView) []envVar {
+	vars := make([]envVar, 0)
+	for _, e := range entries {
+		for i, f := range e.Fields {
+			value := e.Fields[i].displayValue()
+			if value == "" || f.Type == "section" {
+				continue
+			}
+			label := f.Label
+			if label == "" {
+				label = f.Type
+			}
+			vars = append(vars, envVar{Name: envName(e.Title, label), Value: value})
 		}
-		logger.Printf(format, vals...)
 	}
+	return vars
 }
 
 // outputDetailed emits the grouped per-field view: one header line per entry
 // followed by an indented line per field.
-func outputDetailed(logger *logrus.Logger, entries []entryView, args *Args) {
-	if *args.jsonOutput {
-		jsonData, err := json.Marshal(entries)
-		if err != nil {
-			logger.WithError(err).Fatal("could not marshal JSON data")
-		}
-		fmt.Println(string(jsonData))
-		return
-	}
+func outputDetailed(logger *logrus.Logger, entries []entryView) {
 	for _, e := range entries {
 		header := "> " + e.Title
+		if e.Vault != "" {
+			header = "> [" + e.Vault + "] " + e.Title
+		}
 		if e.Subtitle != "" {
 			header += "  (" + e.Subtitle + ")"
 		}
 		if e.Category != "" {
 			header += "  cat.: " + e.Category
 		}
+		if details := renderers[e.Kind].row(&e).details(); len(details) > 0 {
+			header += "  " + strings.Join(details, "  ")
+		}
+		if len(e.Folders) > 0 {
+			header += "  folders: " + strings.Join(e.Folders, ", ")
+		}
+		if e.Favorite {
+			header += "  [favorite]"
+		}
+		if e.Archived {
+			header += "  [archived]"
+		}
 		if e.Trashed {
 			header += "  [trashed]"
 		}
@@ -400,6 +657,8 @@ func outputDetailed(logger *logrus.Logger, entries []entryView, args *Args) {
 				continue
 			}
 			switch {
+			case f.Masked != "":
+				logger.Printf("%s%s (%s): %s", indent, name, f.Type, f.Masked)
 			case f.Sensitive && f.Value == "":
 				logger.Printf("%s%s (%s): ********", indent, name, f.Type)
 			case f.Value != "":
@@ -457,8 +716,52 @@ func anchorField(fields []fieldView) *fieldView {
 	return nil
 }
 
+func listFolders(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+	folders, err := vault.ListFolders()
+	if err != nil {
+		logger.WithError(err).Fatal("could not retrieve folders")
+	}
+
+	type folderRow struct {
+		UUID   string `json:"uuid"`
+		Title  string `json:"title"`
+		Parent string `json:"parent,omitempty"`
+	}
+	rows := make([]folderRow, 0, len(folders))
+	for _, f := range folders {
+		rows = append(rows, folderRow{UUID: f.UUID, Title: f.Title, Parent: f.ParentUUID})
+	}
+
+	writeOutput(logger, args, &output{
+		name:  "folders",
+		items: asItems(rows),
+		text: func() {
+			titles := make(map[string]string, len(folders))
+			for _, f := range folders {
+				titles[f.UUID] = f.Title
+			}
+			for _, f := range folders {
+				if parent, ok := titles[f.ParentUUID]; ok {
+					logger.Printf("> %s  (in %s)", f.Title, parent)
+				} else {
+					logger.Printf("> %s", f.Title)
+				}
+			}
+		},
+	})
+}
+
+// uniqueEntry returns the one card matching filters, or the field a single
+// enpass:// reference points to.
+func uniqueEntry(vault entrySource, cardType string, filters []string) (*enpass.Card, error) {
+	if len(filters) == 1 && enpass.IsRef(filters[0]) {
+		return vault.Resolve(filters[0])
+	}
+	return vault.GetEntry(cardType, filters, true)
+}
+
 func copyEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
-	card, err := vault.GetEntry(*args.cardType, args.filters, true)
+	card, err := uniqueEntry(vault, *args.cardType, args.filters)
 	if err != nil {
 		logger.WithError(err).Fatal("could not retrieve unique card")
 	}
@@ -467,6 +770,7 @@ func copyEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 	if err != nil {
 		logger.WithError(err).Fatal("could not decrypt card")
 	}
+	auditEntry(logger, vault, args, card, fieldName(card))
 
 	if *args.clipboardPrimary {
 		clipboard.Primary = true
@@ -476,129 +780,251 @@ func copyEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 	if err := clipboard.WriteAll(decrypted); err != nil {
 		logger.WithError(err).Fatal("could not copy password to clipboard")
 	}
+	recordUsage(logger, vault, card)
 }
 
-func entryPassword(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
-	card, err := vault.GetEntry(*args.cardType, args.filters, true)
+func entryPassword(logger *logrus.Logger, vault entrySource, args *Args) {
+	card, err := uniqueEntry(vault, *args.cardType, args.filters)
 	if err != nil {
 		logger.WithError(err).Fatal("could not retrieve unique card")
 	}
 
-	if decrypted, err := card.Decrypt(); err != nil {
+	decrypted, err := card.Decrypt()
+	if err != nil {
 		logger.WithError(err).Fatal("could not decrypt card")
-	} else {
-		fmt.Println(decrypted)
 	}
+	auditEntry(logger, vault, args, card, fieldName(card))
+
+	type passwordRow struct {
+		Vault    string `json:"vault,omitempty"`
+		Title    string `json:"title"`
+		Login    string `json:"login"`
+		Password string `json:"password"`
+	}
+	row := passwordRow{Title: card.Title, Login: card.Subtitle, Password: decrypted}
+	if *args.allVaults {
+		row.Vault = card.VaultName
+	}
+	writeOutput(logger, args, &output{
+		name:  "password",
+		items: []interface{}{row},
+		value: row,
+		env:   []envVar{{Name: envName(card.Title, card.Type), Value: decrypted}},
+		text:  func() { fmt.Println(decrypted) },
+	})
+	recordUsage(logger, vault, card)
 }
 
-func envEntries(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+// refEntry prints the enpass:// reference of the field of the one entry matching
+// the filters: the field of -type, or the field with the label or type of -field.
+func refEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 	if len(args.filters) == 0 {
-		logger.Fatal("env command requires at least one VARNAME=filter argument")
+		logger.Fatal("ref command requires a filter")
 	}
 
-	var jsonResult map[string]string
-	if *args.jsonOutput {
-		jsonResult = make(map[string]string, len(args.filters))
+	var card *enpass.Card
+	var err error
+	if *args.field == "" {
+		card, err = uniqueEntry(vault, *args.cardType, args.filters)
+	} else if len(args.filters) == 1 {
+		_, card, err = resolveField(vault, *args.cardType, args.filters[0], *args.field)
+	} else {
+		err = errors.New("-field takes a single filter")
+	}
+	if err != nil {
+		logger.WithError(err).Fatal("could not retrieve unique field")
 	}
 
-	for _, arg := range args.filters {
-		eqIdx := strings.Index(arg, "=")
-		if eqIdx < 1 {
-			logger.Fatalf("invalid argument %q: expected VARNAME=filter", arg)
+	ref, err := vault.Ref(card)
+	if err != nil {
+		logger.WithError(err).Fatal("could not reference field")
+	}
+
+	type refRow struct {
+		Title string `json:"title"`
+		Vault string `json:"vault"`
+		UUID  string `json:"uuid"`
+		Field string `json:"field"`
+		Ref   string `json:"ref"`
+	}
+	row := refRow{Title: card.Title, Vault: ref.Vault, UUID: ref.ItemUUID, Field: ref.Field, Ref: ref.String()}
+	writeOutput(logger, args, &output{
+		name:  "ref",
+		items: []interface{}{row},
+		value: row,
+		text:  func() { fmt.Println(ref.String()) },
+	})
+}
+
+// envMapping is one VARNAME=filter argument of the env and run commands.
+type envMapping struct {
+	name   string
+	filter string
+	// label of the field to use; the password when empty
+	field string
+}
+
+// parseEnvMapping parses VARNAME=filter and validates the variable name.
+func parseEnvMapping(arg string) (envMapping, error) {
+	eqIdx := strings.Index(arg, "=")
+	if eqIdx < 1 {
+		return envMapping{}, fmt.Errorf("invalid argument %q: expected VARNAME=filter", arg)
+	}
+
+	varName := arg[:eqIdx]
+	for _, r := range varName {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_') {
+			return envMapping{}, fmt.Errorf("invalid variable name %q: must contain only letters, digits, and underscores", varName)
 		}
+	}
+	if varName[0] >= '0' && varName[0] <= '9' {
+		return envMapping{}, fmt.Errorf("invalid variable name %q: must not start with a digit", varName)
+	}
 
-		varName := arg[:eqIdx]
-		filter := arg[eqIdx+1:]
+	return envMapping{name: varName, filter: arg[eqIdx+1:]}, nil
+}
 
-		for _, r := range varName {
-			if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_') {
-				logger.Fatalf("invalid variable name %q: must contain only letters, digits, and underscores", varName)
-			}
+// resolveField returns the value of a field of the one entry matching filter:
+// the field of type cardType when field is empty, otherwise the field with
+// that label or type. A filter that is an enpass:// reference names its field
+// itself. It returns the card of the field to record its usage.
+func resolveField(vault *enpass.Vault, cardType string, filter string, field string) (string, *enpass.Card, error) {
+	if enpass.IsRef(filter) {
+		card, err := vault.Resolve(filter)
+		if err != nil {
+			return "", nil, err
 		}
-		if varName[0] >= '0' && varName[0] <= '9' {
-			logger.Fatalf("invalid variable name %q: must not start with a digit", varName)
+		decrypted, err := card.Decrypt()
+		if err != nil {
+			return "", nil, fmt.Errorf("could not decrypt entry: %w", err)
 		}
+		return decrypted, card, nil
+	}
 
-		var value string
+	if field == "" {
+		card, err := vault.GetEntry(cardType, []string{filter}, true)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not retrieve entry: %w", err)
+		}
 
-		if *args.field == "" {
-			card, err := vault.GetEntry(*args.cardType, []string{filter}, true)
-			if err != nil {
-				logger.WithError(err).Fatalf("could not retrieve entry for %s", varName)
-			}
+		decrypted, err := card.Decrypt()
+		if err != nil {
+			return "", nil, fmt.Errorf("could not decrypt entry: %w", err)
+		}
+		return decrypted, card, nil
+	}
 
-			decrypted, err := card.Decrypt()
-			if err != nil {
-				logger.WithError(err).Fatalf("could not decrypt entry for %s", varName)
-			}
+	typeFilter := cardType
+	if typeFilter == "password" {
+		typeFilter = ""
+	}
 
-			value = decrypted
-		} else {
-			typeFilter := *args.cardType
-			if typeFilter == "password" {
-				typeFilter = ""
-			}
+	cards, err := vault.GetAllFields(typeFilter, []string{filter})
+	if err != nil {
+		return "", nil, fmt.Errorf("could not retrieve fields: %w", err)
+	}
 
-			cards, err := vault.GetAllFields(typeFilter, []string{filter})
-			if err != nil {
-				logger.WithError(err).Fatalf("could not retrieve fields for %s", varName)
-			}
+	// Group by entry UUID and enforce uniqueness.
+	entries := make(map[string][]enpass.Card)
+	var order []string
+	for _, c := range cards {
+		if c.IsDeleted() || c.IsTrashed() {
+			continue
+		}
+		if _, seen := entries[c.UUID]; !seen {
+			order = append(order, c.UUID)
+		}
+		entries[c.UUID] = append(entries[c.UUID], c)
+	}
 
-			// Group by entry UUID and enforce uniqueness.
-			entries := make(map[string][]enpass.Card)
-			var order []string
-			for _, c := range cards {
-				if c.IsDeleted() || c.IsTrashed() {
-					continue
-				}
-				if _, seen := entries[c.UUID]; !seen {
-					order = append(order, c.UUID)
-				}
-				entries[c.UUID] = append(entries[c.UUID], c)
-			}
+	if len(entries) == 0 {
+		return "", nil, enpass.ErrNotFound
+	}
+	if len(entries) > 1 {
+		ambiguous := &enpass.ErrAmbiguous{}
+		for _, entryUUID := range order {
+			ambiguous.Matches = append(ambiguous.Matches, entries[entryUUID][0])
+		}
+		return "", nil, ambiguous
+	}
 
-			if len(entries) == 0 {
-				logger.Fatalf("no entry found matching filter for %s", varName)
-			}
-			if len(entries) > 1 {
-				logger.Fatalf("multiple entries match filter for %s, refine your filter", varName)
-			}
+	return decryptField(entries[order[0]], field)
+}
 
-			// Find the field matching -field label.
-			fields := entries[order[0]]
-			var match *enpass.Card
-			for i, c := range fields {
-				if strings.EqualFold(c.Label, *args.field) {
-					match = &fields[i]
-					break
-				}
+// decryptField returns the value of the field of an entry with the given
+// label, or of the given type when no label matches, e.g. "password".
+func decryptField(fields []enpass.Card, field string) (string, *enpass.Card, error) {
+	var match *enpass.Card
+	for i, c := range fields {
+		if strings.EqualFold(c.Label, field) {
+			match = &fields[i]
+			break
+		}
+	}
+	if match == nil {
+		for i, c := range fields {
+			if strings.EqualFold(c.Type, field) {
+				match = &fields[i]
+				break
 			}
+		}
+	}
 
-			if match == nil {
-				logger.Fatalf("no field %q found in entry for %s", *args.field, varName)
-			}
+	if match == nil {
+		return "", nil, errors.Wrapf(enpass.ErrNotFound, "no field %q found in entry", field)
+	}
 
-			decrypted, err := match.Decrypt()
-			if err != nil {
-				logger.WithError(err).Fatalf("could not decrypt field %q for %s", *args.field, varName)
-			}
+	decrypted, err := match.Decrypt()
+	if err != nil {
+		return "", nil, fmt.Errorf("could not decrypt field %q: %w", field, err)
+	}
+	return decrypted, match, nil
+}
 
-			value = decrypted
-		}
+func envEntries(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+	if len(args.filters) == 0 {
+		logger.Fatal("env command requires at least one VARNAME=filter argument")
+	}
 
-		if jsonResult != nil {
-			jsonResult[varName] = value
-		} else {
-			fmt.Printf("%s='%s'\n", varName, shellQuote(value))
+	vars := make([]envVar, 0, len(args.filters))
+	used := make([]*enpass.Card, 0, len(args.filters))
+
+	for _, arg := range args.filters {
+		mapping, err := parseEnvMapping(arg)
+		if err != nil {
+			logger.Fatal(err.Error())
 		}
-	}
 
-	if jsonResult != nil {
-		jsonData, err := json.Marshal(jsonResult)
+		value, card, err := resolveField(vault, *args.cardType, mapping.filter, *args.field)
 		if err != nil {
-			logger.WithError(err).Fatal("could not marshal JSON output")
+			logger.WithError(err).Fatalf("could not resolve %s", mapping.name)
 		}
-		fmt.Println(string(jsonData))
+
+		vars = append(vars, envVar{Name: mapping.name, Value: value})
+		used = append(used, card)
+	}
+	for _, card := range used {
+		auditEntry(logger, vault, args, card, fieldName(card))
+	}
+
+	values := make(map[string]string, len(vars))
+	for _, v := range vars {
+		values[v.Name] = v.Value
+	}
+	writeOutput(logger, args, &output{
+		name:  "env",
+		items: asItems(vars),
+		value: values,
+		env:   vars,
+		text: func() {
+			for _, v := range vars {
+				fmt.Printf("%s='%s'\n", v.Name, shellQuote(v.Value))
+			}
+		},
+	})
+
+	for _, card := range used {
+		recordUsage(logger, vault, card)
 	}
 }
 
@@ -611,8 +1037,8 @@ func ui(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 	if err != nil {
 		logger.WithError(err).Fatal("could not retrieve cards")
 	}
-	if *args.sort {
-		sortEntries(cards)
+	if args.sort != "" {
+		sortEntries(cards, args.sort)
 	}
 
 	app := tview.NewApplication()
@@ -635,6 +1061,9 @@ func ui(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 			if card.IsTrashed() && !*args.trashed {
 				continue
 			}
+			if !showCard(card, args) {
+				continue
+			}
 			if !strings.Contains(strings.ToLower(card.Title+" "+card.Subtitle), filter) {
 				continue
 			}
@@ -680,6 +1109,7 @@ func ui(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 		if decrypted, err := card.Decrypt(); err != nil {
 			logger.WithError(err).Fatal("could not decrypt card")
 		} else {
+			auditEntry(logger, vault, args, &card, fieldName(&card))
 			if err := clipboard.WriteAll(decrypted); err != nil {
 				logger.WithError(err).Fatal("could not copy password to clipboard")
 			} else {
@@ -715,11 +1145,7 @@ func assembleVaultCredentials(logger *logrus.Logger, args *Args, store *unlock.S
 }
 
 func initializeStore(logger *logrus.Logger, args *Args) *unlock.SecureStore {
-	vaultPath, _ := filepath.EvalSymlinks(*args.vaultPath)
-	store, err := unlock.NewSecureStore(filepath.Base(vaultPath), logger.Level)
-	if err != nil {
-		logger.WithError(err).Fatal("could not create store")
-	}
+	store := openStore(logger, args)
 
 	pin := os.Getenv("ENP_PIN")
 	if pin == "" {
@@ -729,18 +1155,32 @@ func initializeStore(logger *logrus.Logger, args *Args) *unlock.SecureStore {
 		logger.Fatal("PIN too short")
 	}
 
-	pepper := os.Getenv("ENP_PIN_PEPPER")
+	if err := store.GeneratePassphrase(pin, os.Getenv("ENP_PIN_PEPPER"), pinKdfIterCount(args)); err != nil {
+		logger.WithError(err).Fatal("could not initialize store")
+	}
+
+	return store
+}
 
-	pinKdfIterCount, err := strconv.ParseInt(os.Getenv("ENP_PIN_ITER_COUNT"), 10, 32)
+// openStore opens the PIN store of the vault without a passphrase.
+func openStore(logger *logrus.Logger, args *Args) *unlock.SecureStore {
+	vaultPath, _ := filepath.EvalSymlinks(*args.vaultPath)
+	store, err := unlock.NewSecureStore(filepath.Base(vaultPath), logger.Level)
 	if err != nil {
-		pinKdfIterCount = pinDefaultKdfIterCount
+		logger.WithError(err).Fatal("could not create store")
 	}
+	return store
+}
 
-	if err := store.GeneratePassphrase(pin, pepper, int(pinKdfIterCount)); err != nil {
-		logger.WithError(err).Fatal("could not initialize store")
+// pinKdfIterCount returns ENP_PIN_ITER_COUNT, or else the iteration count of the profile.
+func pinKdfIterCount(args *Args) int {
+	iterCount, err := strconv.ParseInt(os.Getenv("ENP_PIN_ITER_COUNT"), 10, 32)
+	if err != nil && args.pinIterCount > 0 {
+		iterCount = int64(args.pinIterCount)
+	} else if err != nil {
+		iterCount = pinDefaultKdfIterCount
 	}
-
-	return store
+	return int(iterCount)
 }
 
 func createEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
@@ -752,6 +1192,9 @@ func createEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 		Notes:    *args.notes,
 		Category: *args.category,
 	}
+	if *args.folder != "" {
+		entry.Folders = []string{*args.folder}
+	}
 
 	// Prompt for required fields if not provided
 	if entry.Title == "" {
@@ -770,6 +1213,7 @@ func createEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 	if err != nil {
 		logger.WithError(err).Fatal("could not create entry")
 	}
+	auditEntry(logger, vault, args, &enpass.Card{UUID: uuid, Title: entry.Title}, "")
 
 	logger.Printf("Created entry: %s (UUID: %s)", entry.Title, uuid)
 }
@@ -787,6 +1231,9 @@ func editEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 		Notes:    *args.notes,
 		Category: *args.category,
 	}
+	if *args.folder != "" {
+		updates.Folders = []string{*args.folder}
+	}
 
 	// Handle password - prompt if flag was passed but empty
 	if isFlagPassed("password") && *args.password == "" {
@@ -806,6 +1253,7 @@ func editEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 	if err := vault.UpdateEntry(card.UUID, updates); err != nil {
 		logger.WithError(err).Fatal("could not update entry")
 	}
+	auditEntry(logger, vault, args, card, changedFields(updates))
 
 	logger.Printf("Updated entry: %s", card.Title)
 }
@@ -826,6 +1274,7 @@ func trashEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 	if err := vault.TrashEntry(card.UUID); err != nil {
 		logger.WithError(err).Fatal("could not trash entry")
 	}
+	auditEntry(logger, vault, args, card, "")
 
 	logger.Printf("Moved to trash: %s", card.Title)
 }
@@ -838,19 +1287,9 @@ func restoreEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 		logger.WithError(err).Fatal("could not retrieve entries")
 	}
 
-	// Find trashed entry matching filter
-	var card *enpass.Card
-	for _, c := range cards {
-		if c.IsTrashed() && !c.IsDeleted() {
-			if card != nil {
-				logger.Fatal("multiple trashed entries match that filter")
-			}
-			card = &c
-		}
-	}
-
-	if card == nil {
-		logger.Fatal("no trashed entry found matching filter")
+	card, err := trashedEntry(cards)
+	if err != nil {
+		logger.WithError(err).Fatal("could not find trashed entry matching filter")
 	}
 
 	if !*args.force {
@@ -863,6 +1302,7 @@ func restoreEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 	if err := vault.RestoreEntry(card.UUID); err != nil {
 		logger.WithError(err).Fatal("could not restore entry")
 	}
+	auditEntry(logger, vault, args, card, "")
 
 	logger.Printf("Restored: %s", card.Title)
 }
@@ -875,20 +1315,10 @@ func deleteEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 		logger.WithError(err).Fatal("could not retrieve entries")
 	}
 
-	// Find trashed entry matching filter
-	var card *enpass.Card
-	for _, c := range cards {
-		if c.IsTrashed() && !c.IsDeleted() {
-			if card != nil {
-				logger.Fatal("multiple trashed entries match that filter")
-			}
-			card = &c
-		}
-	}
-
-	if card == nil {
+	card, err := trashedEntry(cards)
+	if enpass.IsNotFound(err) {
 		if !*args.force {
-			logger.Fatal("no trashed entry found - use 'trash' first or --force to delete directly")
+			logger.WithError(err).Fatal("no trashed entry found - use 'trash' first or --force to delete directly")
 		}
 		// With --force, allow deleting non-trashed entries
 		entry, err := vault.GetEntry(*args.cardType, args.filters, true)
@@ -896,6 +1326,8 @@ func deleteEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 			logger.WithError(err).Fatal("could not find entry to delete")
 		}
 		card = entry
+	} else if err != nil {
+		logger.WithError(err).Fatal("could not find trashed entry matching filter")
 	}
 
 	if !*args.force {
@@ -908,10 +1340,29 @@ func deleteEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
 	if err := vault.DeleteEntry(card.UUID); err != nil {
 		logger.WithError(err).Fatal("could not delete entry")
 	}
+	auditEntry(logger, vault, args, card, "")
 
 	logger.Printf("Permanently deleted: %s", card.Title)
 }
 
+// trashedEntry returns the one trashed entry of cards, enpass.ErrNotFound when
+// none is trashed and an *enpass.ErrAmbiguous when several are.
+func trashedEntry(cards []enpass.Card) (*enpass.Card, error) {
+	var matches []enpass.Card
+	for _, c := range cards {
+		if c.IsTrashed() && !c.IsDeleted() {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, enpass.ErrNotFound
+	case 1:
+		return &matches[0], nil
+	}
+	return nil, &enpass.ErrAmbiguous{Matches: matches}
+}
+
 func promptText(logger *logrus.Logger, args *Args, msg string) string {
 	if *args.nonInteractive {
 		return ""
@@ -957,7 +1408,7 @@ func main() {
 		printHelp()
 		logger.Exit(1)
 	}
-
+	// before the config is loaded, so a broken config doesn't keep them from working
 	switch args.command {
 	case cmdHelp:
 		printHelp()
@@ -970,11 +1421,83 @@ func main() {
 		return
 	}
 
+	format, err := outputFormat(args)
+	if err != nil {
+		logger.WithError(err).Fatal("invalid output format")
+	}
+	setupErrors(logger, format == formatJSON)
+	if !validView(*args.view) {
+		logger.Fatalf("unknown view %q: expected one of %s", *args.view, strings.Join(viewNames(), ", "))
+	}
+
+	config, err := loadConfig(*args.configPath)
+	if err != nil {
+		logger.WithError(err).Fatal("could not load config")
+	}
+	if err := applyProfile(logger, config, args); err != nil {
+		logger.WithError(err).Fatal("could not apply profile")
+	}
+
+	switch args.command {
+	case cmdProfiles:
+		listProfiles(logger, config, args)
+		return
+	case cmdAuditLog:
+		auditLogCommand(logger, args)
+		return
+	case cmdInit:
+		initCommand(logger, args)
+		return
+	case cmdSchema:
+		schemaCommand(logger, args)
+		return
+	}
+
+	if *args.allVaults {
+		if _, supported := allVaultsCommands[args.command]; !supported {
+			logger.Fatalf("-allVaults is not supported by the %s command", args.command)
+		}
+		// every vault is opened with its own profile, a single -vault or -pin can't apply
+		for _, name := range []string{"vault", "pin"} {
+			if isFlagPassed(name) {
+				logger.Fatalf("-allVaults can't be combined with -%s", name)
+			}
+		}
+		multi := openAllVaults(logger, config, args)
+		defer multi.Close()
+		if *args.folder != "" {
+			multi.SetFilterFolders([]string{*args.folder})
+		}
+		switch args.command {
+		case cmdList:
+			listEntries(logger, multi, args)
+		case cmdShow:
+			showEntries(logger, multi, args)
+		case cmdPass:
+			entryPassword(logger, multi, args)
+		}
+		return
+	}
+
 	vault, err := enpass.NewVault(*args.vaultPath, logger.Level)
 	if err != nil {
 		logger.WithError(err).Fatal("could not create vault")
 	}
 	vault.FilterAnd = *args.and
+	vault.BusyTimeout = *args.busyTimeout
+
+	if args.command == cmdBackup && !backupNeedsVault(args) {
+		backupCommand(logger, vault, args)
+		return
+	}
+	if args.command == cmdNative {
+		nativeHostCommand(logger, vault, args)
+		return
+	}
+	if args.command == cmdServe && !serveNeedsVault(args) {
+		serveToken(logger, args)
+		return
+	}
 
 	var store *unlock.SecureStore
 	if !*args.pinEnable {
@@ -990,12 +1513,38 @@ func main() {
 	defer func() {
 		vault.Close()
 	}()
-	if err := vault.Open(credentials); err != nil {
-		logger.WithError(err).Error("could not open vault")
-		logger.Exit(2)
+	openVault := vault.OpenReadOnly
+	_, mutating := mutatingCommands[args.command]
+	if args.command == cmdServe {
+		mutating = serveMutates(args)
+	}
+	if _, used := usageCommands[args.command]; mutating {
+		openVault = vault.Open
+	} else if used && *args.recordUsage {
+		openVault = func(credentials *enpass.VaultCredentials) error {
+			return openForUsage(logger, vault, credentials)
+		}
+	}
+	// doctor reports vaults it can't read too
+	openErr := openVault(credentials)
+	if openErr != nil && args.command != cmdDoctor {
+		entry := logger.WithError(openErr)
+		if _, exitCode := errorCode(openErr); exitCode == exitFailure {
+			entry = entry.WithField("exit_code", exitOpen)
+		}
+		entry.Fatal("could not open vault")
 	}
-	logger.Debug("opened vault")
+	logger.WithField("read_only", vault.IsReadOnly()).Debug("opened vault")
 
+	if _, filtered := folderFilterCommands[args.command]; filtered && *args.folder != "" {
+		vault.FilterFolders = []string{*args.folder}
+	}
+
+	if mutating {
+		backupBeforeWrite(logger, vault, args)
+	}
+
+	exitCode := 0
 	switch args.command {
 	case cmdDryRun:
 		logger.Debug("dry run complete") // just init vault and store without doing anything
@@ -1019,15 +1568,54 @@ func main() {
 		restoreEntry(logger, vault, args)
 	case cmdEnv:
 		envEntries(logger, vault, args)
+	case cmdRun:
+		exitCode = runCommand(logger, vault, args)
+	case cmdRef:
+		refEntry(logger, vault, args)
+	case cmdGitCred:
+		gitCredentialCommand(logger, vault, args, credentials)
+	case cmdMatch:
+		matchEntries(logger, vault, args)
+	case cmdSSHAgent:
+		sshAgentCommand(logger, vault, args)
+	case cmdDecrypt:
+		decryptCommand(logger, vault, args)
+	case cmdServe:
+		serveCommand(logger, vault, args)
+	case cmdDiff:
+		diffCommand(logger, vault, args, credentials)
+	case cmdMerge:
+		mergeCommand(logger, vault, args, credentials)
+	case cmdWatch:
+		watchCommand(logger, vault, args)
+	case cmdDoctor:
+		exitCode = doctorCommand(logger, vault, args, openErr)
+	case cmdFsck:
+		exitCode = fsckCommand(logger, vault, args, credentials)
+	case cmdInject:
+		exitCode = injectCommand(logger, vault, args)
 	case cmdDelete:
 		deleteEntry(logger, vault, args)
+	case cmdBackup:
+		backupCommand(logger, vault, args)
+	case cmdFolders:
+		listFolders(logger, vault, args)
+	case cmdArchive, cmdUnarch:
+		archiveEntry(logger, vault, args, args.command == cmdArchive)
+	case cmdFavorite, cmdUnfav:
+		favoriteEntry(logger, vault, args, args.command == cmdFavorite)
 	default:
 		logger.WithField("command", args.command).Fatal("unknown command")
 	}
 
-	if store != nil {
+	if store != nil && openErr == nil {
 		if err := store.Write(credentials.DBKey); err != nil {
 			logger.WithError(err).Fatal("failed to write credentials to store")
 		}
 	}
+
+	if exitCode != 0 {
+		vault.Close()
+		logger.Exit(exitCode)
+	}
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+// matchRow is one entry found by the match command.
+type matchRow struct {
+	Title string `json:"title"`
+	Login string `json:"login"`
+	URL   string `json:"url"`
+	Match string `json:"match"`
+	UUID  string `json:"uuid"`
+}
+
+// matchEntries handles 'match URL': it lists the entries with a URL field of
+// the same site, best match first.
+func matchEntries(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+	if len(args.filters) != 1 {
+		logger.Fatal("usage: match URL")
+	}
+
+	vault.URLRules = args.urlRules
+	matches, err := vault.MatchURL(args.filters[0])
+	if err != nil {
+		logger.WithError(err).Fatal("could not match URL")
+	}
+
+	rows := make([]matchRow, 0, len(matches))
+	for _, m := range matches {
+		if !showCard(m.Card, args) {
+			continue
+		}
+		rows = append(rows, matchRow{Title: m.Title, Login: m.Subtitle, URL: m.RawValue, Match: m.Kind.String(), UUID: m.UUID})
+	}
+
+	writeOutput(logger, args, &output{
+		name:  "entries",
+		items: asItems(rows),
+		text: func() {
+			for _, r := range rows {
+				logger.Printf("> title: %s  login: %s  url: %s  match: %s", r.Title, r.Login, r.URL, r.Match)
+			}
+		},
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hazcod/enpass-cli/pkg/audit"
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// values of -resolve
+	resolveNewer = "newer"
+	resolveAsk   = "ask"
+)
+
+// openOtherVault opens the vault the diff and merge commands compare with
+// read-only: a vault directory, or the ID of a backup of the vault. It is
+// unlocked with the credentials of the vault, or a password asked for when
+// they don't fit.
+func openOtherVault(logger *logrus.Logger, vault *enpass.Vault, args *Args, credentials *enpass.VaultCredentials) *enpass.Vault {
+	if len(args.filters) != 1 {
+		logger.Fatalf("usage: %s VAULT|BACKUP_ID", args.command)
+	}
+	path := expandHome(args.filters[0])
+	if _, err := os.Stat(filepath.Join(path, "vault.json")); err != nil {
+		backup := filepath.Join(backupDir(vault, args), args.filters[0])
+		if _, backupErr := os.Stat(filepath.Join(backup, "vault.json")); backupErr != nil {
+			logger.WithError(err).Fatalf("%s is neither a vault nor a backup", args.filters[0])
+		}
+		path = backup
+	}
+
+	other, err := enpass.NewVault(path, logger.Level)
+	if err != nil {
+		logger.WithError(err).Fatal("could not load other vault")
+	}
+	other.BusyTimeout = *args.busyTimeout
+
+	// derive the key again when the password is known, a sync copy may have another salt
+	otherCredentials := &enpass.VaultCredentials{Password: credentials.Password, KeyfilePath: credentials.KeyfilePath}
+	if otherCredentials.Password == "" {
+		otherCredentials.DBKey = credentials.DBKey
+	}
+	err = other.OpenReadOnly(otherCredentials)
+	if err != nil && !*args.nonInteractive {
+		logger.WithError(err).Debug("credentials of the vault did not unlock the other vault")
+		password := prompt(logger, args, "vault password for "+path)
+		err = other.OpenReadOnly(&enpass.VaultCredentials{Password: password, KeyfilePath: *args.keyFilePath})
+	}
+	if err != nil {
+		other.Close()
+		logger.WithError(err).Fatal("could not open other vault")
+	}
+	return other
+}
+
+// formatModified prints a modification time of an entry diff.
+func formatModified(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Unix(unix, 0).Local().Format("2006-01-02 15:04:05")
+}
+
+// printEntryDiff prints one entry diff: + for entries only the other vault
+// has, - for entries only this vault has and ~ for changed entries, followed
+// by the values that differ.
+func printEntryDiff(d *enpass.EntryDiff) {
+	switch d.Change {
+	case enpass.ChangeAdded:
+		fmt.Printf("+ %s (%s)  modified %s\n", d.Title, d.UUID, formatModified(d.OtherModified))
+	case enpass.ChangeRemoved:
+		fmt.Printf("- %s (%s)  modified %s\n", d.Title, d.UUID, formatModified(d.Modified))
+	default:
+		newer := "ours"
+		if d.OtherIsNewer() {
+			newer = "theirs"
+		}
+		fmt.Printf("~ %s (%s)  ours %s, theirs %s, %s newer\n", d.Title, d.UUID,
+			formatModified(d.Modified), formatModified(d.OtherModified), newer)
+	}
+	for _, value := range d.Values {
+		switch {
+		case value.Redacted:
+			fmt.Printf("    %s: %s (redacted)\n", value.Name, value.Change)
+		case value.Change == enpass.ChangeAdded:
+			fmt.Printf("    %s: added %q\n", value.Name, value.OtherValue)
+		case value.Change == enpass.ChangeRemoved:
+			fmt.Printf("    %s: removed %q\n", value.Name, value.Value)
+		default:
+			fmt.Printf("    %s: %q -> %q\n", value.Name, value.Value, value.OtherValue)
+		}
+	}
+}
+
+// vaultDiff compares the vault with the other one, recording in the audit log
+// which entries had their secrets shown.
+func vaultDiff(logger *logrus.Logger, vault *enpass.Vault, other *enpass.Vault, args *Args) []enpass.EntryDiff {
+	diffs, err := vault.Diff(other, &enpass.DiffOptions{Secrets: *args.showSecrets})
+	if err != nil {
+		logger.WithError(err).Fatal("could not compare vaults")
+	}
+	if *args.showSecrets {
+		for _, d := range diffs {
+			if d.Change == enpass.ChangeChanged {
+				auditEntry(logger, vault, args, &enpass.Card{UUID: d.UUID, Title: d.Title}, audit.FieldAll)
+			}
+		}
+	}
+	return diffs
+}
+
+// diffCommand handles 'diff VAULT|BACKUP_ID': it lists the entries added,
+// removed and changed in the other vault compared to this one.
+func diffCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args, credentials *enpass.VaultCredentials) {
+	other := openOtherVault(logger, vault, args, credentials)
+	defer other.Close()
+
+	diffs := vaultDiff(logger, vault, other, args)
+	writeOutput(logger, args, &output{
+		name:  "diff",
+		items: asItems(diffs),
+		value: diffs,
+		text: func() {
+			for i := range diffs {
+				printEntryDiff(&diffs[i])
+			}
+		},
+	})
+}
+
+// mergeCommand handles 'merge VAULT|BACKUP_ID': it copies the entries only the
+// other vault has, and the changed entries it modified last with
+// -resolve=newer, or those the user picks with -resolve=ask. Entries only this
+// vault has are kept.
+func mergeCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args, credentials *enpass.VaultCredentials) {
+	if *args.resolve != resolveNewer && *args.resolve != resolveAsk {
+		logger.Fatalf("unknown -resolve %q: expected %s or %s", *args.resolve, resolveNewer, resolveAsk)
+	}
+	if *args.resolve == resolveAsk && *args.nonInteractive {
+		logger.Fatal("-resolve=ask needs prompts, which -nonInteractive disables")
+	}
+	other := openOtherVault(logger, vault, args, credentials)
+	defer other.Close()
+
+	merged, skipped, missing := 0, 0, 0
+	for _, d := range vaultDiff(logger, vault, other, args) {
+		take := false
+		switch {
+		case d.Change == enpass.ChangeRemoved:
+			missing++
+			continue
+		case *args.resolve == resolveAsk:
+			printEntryDiff(&d)
+			if d.Change == enpass.ChangeAdded {
+				take = confirm(logger, args, fmt.Sprintf("Add '%s'?", d.Title))
+			} else {
+				take = confirm(logger, args, fmt.Sprintf("Take their version of '%s'?", d.Title))
+			}
+		default:
+			take = d.Change == enpass.ChangeAdded || d.OtherIsNewer()
+		}
+		if !take {
+			skipped++
+			continue
+		}
+
+		if err := vault.CopyEntryFrom(other, d.UUID); err != nil {
+			logger.WithError(err).WithField("title", d.Title).Fatal("could not merge entry")
+		}
+		auditEntry(logger, vault, args, &enpass.Card{UUID: d.UUID, Title: d.Title}, "")
+		logger.WithField("uuid", d.UUID).Debugf("merged %s entry %s", d.Change, d.Title)
+		merged++
+	}
+
+	logger.Printf("Merged %d entries, skipped %d, %d entries are only in this vault", merged, skipped, missing)
+}
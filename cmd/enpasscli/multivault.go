@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+// commands that can search every configured vault at once with -allVaults
+var allVaultsCommands = map[string]struct{}{
+	cmdList: {}, cmdShow: {}, cmdPass: {},
+}
+
+// entrySource is what the read commands need from either a single vault or
+// an enpass.MultiVault.
+type entrySource interface {
+	GetAllFields(cardType string, filters []string) ([]enpass.Card, error)
+	GetEntry(cardType string, filters []string, unique bool) (*enpass.Card, error)
+	Resolve(reference string) (*enpass.Card, error)
+}
+
+// vaultTarget is one vault to open for -allVaults.
+type vaultTarget struct {
+	name    string
+	path    string
+	keyfile string
+}
+
+// allVaultTargets returns the vaults of every configured profile plus every
+// discovered vault, skipping duplicates that point at the same directory.
+func allVaultTargets(logger *logrus.Logger, config *Config) []vaultTarget {
+	targets := make([]vaultTarget, 0, len(config.Profiles))
+	seen := map[string]struct{}{}
+	add := func(target vaultTarget) {
+		resolved, err := filepath.EvalSymlinks(target.path)
+		if err != nil {
+			resolved = target.path
+		}
+		if _, exists := seen[resolved]; exists {
+			return
+		}
+		seen[resolved] = struct{}{}
+		targets = append(targets, target)
+	}
+
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		profile := config.Profiles[name]
+		if profile.Vault == "" {
+			continue
+		}
+		add(vaultTarget{name: name, path: expandHome(profile.Vault), keyfile: expandHome(profile.Keyfile)})
+	}
+
+	discovered := config.discoveredVaults(logger)
+	names = names[:0]
+	for name := range discovered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(vaultTarget{name: name, path: discovered[name]})
+	}
+
+	return targets
+}
+
+// openAllVaults opens every vault read-only. All vaults are tried with the
+// shared master password first; a vault it doesn't unlock gets its own
+// prompt. Vaults that still can't be opened are skipped with a warning.
+func openAllVaults(logger *logrus.Logger, config *Config, args *Args) *enpass.MultiVault {
+	targets := allVaultTargets(logger, config)
+	if len(targets) == 0 {
+		logger.Fatal("no vaults configured or discovered")
+	}
+
+	password := os.Getenv("MASTERPW")
+	if password == "" {
+		password = prompt(logger, args, "vault password")
+	}
+
+	vaults := make([]*enpass.Vault, 0, len(targets))
+	for _, target := range targets {
+		vault, err := enpass.NewVault(target.path, logger.Level)
+		if err != nil {
+			logger.WithError(err).WithField("vault", target.name).Warn("skipping vault")
+			continue
+		}
+		vault.BusyTimeout = *args.busyTimeout
+
+		err = vault.OpenReadOnly(&enpass.VaultCredentials{Password: password, KeyfilePath: target.keyfile})
+		if err != nil && !*args.nonInteractive {
+			logger.WithError(err).WithField("vault", target.name).Debug("shared password did not unlock vault")
+			vaultPassword := prompt(logger, args, "vault password for "+target.name)
+			err = vault.OpenReadOnly(&enpass.VaultCredentials{Password: vaultPassword, KeyfilePath: target.keyfile})
+		}
+		if err != nil {
+			vault.Close()
+			logger.WithError(err).WithField("vault", target.name).Warn("skipping vault")
+			continue
+		}
+		vaults = append(vaults, vault)
+	}
+
+	if len(vaults) == 0 {
+		logger.Fatal("could not open any vault")
+	}
+
+	multi := enpass.NewMultiVault(vaults...)
+	multi.SetFilterAnd(*args.and)
+	return multi
+}
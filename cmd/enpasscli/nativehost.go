@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"slices"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/hazcod/enpass-cli/pkg/nativehost"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// nativeHostCommand handles 'native-host', which the browser starts with the
+// arguments identifying the extension. stdin and stdout carry the native
+// messaging protocol, so nothing is ever prompted for: the vault is opened
+// with MASTERPW, or with the key in the PIN store once the extension sends
+// the PIN. Every request revealing a secret has to carry the PIN.
+func nativeHostCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+	id := nativehost.ExtensionID(args.filters)
+	if id == "" || !slices.Contains(args.allowedExtensions, id) {
+		logger.Fatalf("extension %q is not allowed, add it to -allowedExtensions", id)
+	}
+	log := logger.WithField("extension", id)
+
+	var opened *enpass.Vault
+	if password := os.Getenv("MASTERPW"); password != "" {
+		credentials := &enpass.VaultCredentials{Password: password, KeyfilePath: *args.keyFilePath}
+		if err := vault.OpenReadOnly(credentials); err != nil {
+			log.WithError(err).Fatal("could not open vault")
+		}
+		opened = vault
+	}
+	defer vault.Close()
+
+	store := openStore(logger, args)
+	unlockVault := func(pin string) (*enpass.Vault, error) {
+		if len(pin) < pinMinLength {
+			return nil, errors.New("PIN too short")
+		}
+		if err := store.GeneratePassphrase(pin, os.Getenv("ENP_PIN_PEPPER"), pinKdfIterCount(args)); err != nil {
+			return nil, err
+		}
+		dbKey, err := store.Read()
+		if err != nil {
+			return nil, errors.New("wrong PIN")
+		} else if dbKey == nil {
+			return nil, errors.New("no vault key is stored under a PIN, unlock the vault once with enpass-cli -pin")
+		}
+
+		if opened == nil {
+			credentials := &enpass.VaultCredentials{DBKey: dbKey, KeyfilePath: *args.keyFilePath}
+			if err := vault.OpenReadOnly(credentials); err != nil {
+				return nil, errors.Wrap(err, "could not open vault")
+			}
+			opened = vault
+			log.Debug("opened vault")
+		}
+		return opened, nil
+	}
+
+	log.Debug("serving native messaging requests")
+	if err := nativehost.NewHost(logger, opened, unlockVault).Serve(os.Stdin, os.Stdout); err != nil {
+		log.WithError(err).Fatal("native messaging failed")
+	}
+}
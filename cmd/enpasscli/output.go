@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// output formats for -format
+	formatText     = "text"
+	formatJSON     = "json"
+	formatYAML     = "yaml"
+	formatTOML     = "toml"
+	formatCSV      = "csv"
+	formatTable    = "table"
+	formatDotenv   = "dotenv"
+	formatTemplate = "template"
+)
+
+var outputFormats = []string{
+	formatText, formatJSON, formatYAML, formatTOML, formatCSV, formatTable, formatDotenv, formatTemplate,
+}
+
+// output is what a command prints, in a form every -format can render.
+type output struct {
+	// key holding the items in TOML, which can't encode a top-level array
+	name string
+	// items are marshalled by json, yaml and toml, flattened into columns by
+	// csv and table and passed one at a time to -template
+	items []interface{}
+	// value is marshalled by json, yaml and toml instead of items when set
+	value interface{}
+	// rows replace items for csv and table, for items whose nested values
+	// don't fit in a column
+	rows []interface{}
+	// templateItems replace items for -template
+	templateItems []interface{}
+	// variables written by dotenv; nil when the command has nothing to export
+	env []envVar
+	// text prints the items the way the command always has
+	text func()
+}
+
+// envVar is one variable of the env command, or of the dotenv format.
+type envVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// outputFormat resolves -format, -json and -template into one format.
+func outputFormat(args *Args) (string, error) {
+	format := strings.ToLower(*args.format)
+	if !isFlagPassed("format") {
+		switch {
+		case *args.jsonOutput:
+			format = formatJSON
+		case *args.template != "":
+			format = formatTemplate
+		}
+	}
+
+	for _, known := range outputFormats {
+		if format == known {
+			if format == formatTemplate && *args.template == "" {
+				return "", errors.New("-format=template needs -template")
+			}
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q: expected one of %s", format, strings.Join(outputFormats, ", "))
+}
+
+// asItems converts a slice of any type for output.items.
+func asItems[T any](values []T) []interface{} {
+	items := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		items = append(items, value)
+	}
+	return items
+}
+
+// writeOutput prints out in the format selected by -format.
+func writeOutput(logger *logrus.Logger, args *Args, out *output) {
+	format, err := outputFormat(args)
+	if err != nil {
+		logger.WithError(err).Fatal("invalid output format")
+	}
+
+	data := out.value
+	if data == nil {
+		data = out.items
+		if out.items == nil {
+			data = []interface{}{}
+		}
+	}
+	rows := out.rows
+	if rows == nil {
+		rows = out.items
+	}
+	templateItems := out.templateItems
+	if templateItems == nil {
+		templateItems = out.items
+	}
+
+	switch format {
+	case formatText:
+		out.text()
+		return
+	case formatJSON:
+		err = writeJSON(os.Stdout, data)
+	case formatYAML:
+		err = writeYAML(os.Stdout, data)
+	case formatTOML:
+		err = writeTOML(os.Stdout, out.name, data)
+	case formatCSV:
+		err = writeCSV(os.Stdout, rows)
+	case formatTable:
+		err = writeTable(os.Stdout, rows)
+	case formatDotenv:
+		if out.env == nil {
+			logger.Fatalf("the %s command has no dotenv output", args.command)
+		}
+		writeDotenv(os.Stdout, out.env)
+	case formatTemplate:
+		err = writeTemplate(os.Stdout, *args.template, templateItems)
+	}
+	if err != nil {
+		logger.WithError(err).Fatalf("could not write %s output", format)
+	}
+}
+
+func writeJSON(w io.Writer, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal JSON data")
+	}
+	_, err = fmt.Fprintln(w, string(jsonData))
+	return err
+}
+
+// writeYAML writes data as block-style YAML. Going through JSON reuses the
+// json tags of the output types and keeps their field order.
+func writeYAML(w io.Writer, data interface{}) error {
+	node, err := yamlNode(data)
+	if err != nil {
+		return err
+	}
+	yamlData, err := yaml.Marshal(node)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal YAML data")
+	}
+	_, err = w.Write(yamlData)
+	return err
+}
+
+// yamlNode converts data to a YAML node through its JSON form. JSON is valid
+// YAML, so the node keeps the key order; only the flow style is reset.
+func yamlNode(data interface{}) (*yaml.Node, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal data")
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(jsonData, &node); err != nil {
+		return nil, errors.Wrap(err, "could not convert data")
+	}
+	var blockStyle func(n *yaml.Node)
+	blockStyle = func(n *yaml.Node) {
+		// the encoder still quotes strings that would read back as another type
+		n.Style = 0
+		for _, child := range n.Content {
+			blockStyle(child)
+		}
+	}
+	blockStyle(&node)
+	return &node, nil
+}
+
+// writeTOML writes data as TOML. Lists are stored below name since TOML
+// documents are tables.
+func writeTOML(w io.Writer, name string, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal data")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return errors.Wrap(err, "could not convert data")
+	}
+
+	table, ok := dropNulls(generic).(map[string]interface{})
+	if !ok {
+		table = map[string]interface{}{name: dropNulls(generic)}
+	}
+	return toml.NewEncoder(w).Encode(table)
+}
+
+// dropNulls removes JSON nulls, which TOML can't represent.
+func dropNulls(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if child == nil {
+				delete(v, key)
+			} else {
+				v[key] = dropNulls(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = dropNulls(child)
+		}
+	}
+	return value
+}
+
+// columns flattens rows into a header and one record per row. The header is
+// the union of the keys of all rows in order of appearance.
+func columns(rows []interface{}) ([]string, [][]string, error) {
+	header := make([]string, 0)
+	index := map[string]int{}
+	cells := make([]map[string]string, 0, len(rows))
+
+	for _, row := range rows {
+		node, err := yamlNode(row)
+		if err != nil {
+			return nil, nil, err
+		}
+		if node.Kind == yaml.DocumentNode && len(node.Content) == 1 {
+			node = node.Content[0]
+		}
+		if node.Kind != yaml.MappingNode {
+			return nil, nil, errors.New("output has no columns")
+		}
+
+		rowCells := map[string]string{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if _, exists := index[key]; !exists {
+				index[key] = len(header)
+				header = append(header, key)
+			}
+			rowCells[key] = cellValue(node.Content[i+1])
+		}
+		cells = append(cells, rowCells)
+	}
+
+	records := make([][]string, 0, len(cells))
+	for _, rowCells := range cells {
+		record := make([]string, len(header))
+		for key, i := range index {
+			record[i] = rowCells[key]
+		}
+		records = append(records, record)
+	}
+	return header, records, nil
+}
+
+// cellValue renders a value in a single column: lists of scalars are joined,
+// anything nested is kept as JSON.
+func cellValue(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return ""
+		}
+		return node.Value
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, child := range node.Content {
+			if child.Kind != yaml.ScalarNode {
+				return nestedValue(node)
+			}
+			values = append(values, child.Value)
+		}
+		return strings.Join(values, ", ")
+	default:
+		return nestedValue(node)
+	}
+}
+
+func nestedValue(node *yaml.Node) string {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return ""
+	}
+	jsonData, _ := json.Marshal(value)
+	return string(jsonData)
+}
+
+func writeCSV(w io.Writer, rows []interface{}) error {
+	header, records, err := columns(rows)
+	if err != nil {
+		return err
+	}
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+	if err := csvWriter.WriteAll(records); err != nil {
+		return errors.Wrap(err, "could not write CSV")
+	}
+	return nil
+}
+
+// writeTable writes rows as columns aligned with spaces, with an upper case header.
+func writeTable(w io.Writer, rows []interface{}) error {
+	header, records, err := columns(rows)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	upper := make([]string, len(header))
+	for i, column := range header {
+		upper[i] = strings.ToUpper(column)
+	}
+	fmt.Fprintln(tw, strings.Join(upper, "\t"))
+	for _, record := range records {
+		for i := range record {
+			// tabs and newlines would break the alignment
+			record[i] = strings.Join(strings.Fields(record[i]), " ")
+		}
+		fmt.Fprintln(tw, strings.Join(record, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// padding of empty trailing columns
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDotenv writes NAME="value" lines as read by dotenv libraries and docker compose.
+func writeDotenv(w io.Writer, vars []envVar) {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`)
+	for _, v := range vars {
+		fmt.Fprintf(w, "%s=\"%s\"\n", v.Name, replacer.Replace(v.Value))
+	}
+}
+
+// writeTemplate executes a Go template for every item. On entries,
+// {{field "label"}} returns the value of the field with that label or type.
+func writeTemplate(w io.Writer, text string, items []interface{}) error {
+	tmpl, err := template.New("output").Funcs(template.FuncMap{"field": templateField(nil)}).Parse(text)
+	if err != nil {
+		return errors.Wrap(err, "could not parse template")
+	}
+
+	for _, item := range items {
+		var buf bytes.Buffer
+		var entry *entryView
+		if e, ok := item.(entryView); ok {
+			entry = &e
+		}
+		if err := tmpl.Funcs(template.FuncMap{"field": templateField(entry)}).Execute(&buf, item); err != nil {
+			return errors.Wrap(err, "could not execute template")
+		}
+		if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func templateField(entry *entryView) func(string) (string, error) {
+	return func(name string) (string, error) {
+		if entry == nil {
+			return "", errors.New("field is only available on entries")
+		}
+		f := entry.fieldByLabel(name)
+		if f == nil {
+			f = entry.fieldByType(name)
+		}
+		if f == nil {
+			return "", fmt.Errorf("entry %s has no field %q", entry.Title, name)
+		}
+		return f.displayValue(), nil
+	}
+}
+
+// envName turns text into an environment variable name: upper case letters,
+// digits and underscores, not starting with a digit.
+func envName(parts ...string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToUpper(strings.Join(parts, "_")) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	name := strings.TrimRight(b.String(), "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
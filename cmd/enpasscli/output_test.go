@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// outputRow is an item with the kinds of values the output formats flatten
+type outputRow struct {
+	Title   string         `json:"title"`
+	Login   string         `json:"login,omitempty"`
+	Folders []string       `json:"folders,omitempty"`
+	Extra   map[string]int `json:"extra,omitempty"`
+}
+
+var outputRows = []interface{}{
+	outputRow{Title: "GitHub", Login: "me", Folders: []string{"Work", "Dev"}},
+	outputRow{Title: `Say "hi", ok`, Extra: map[string]int{"n": 1}},
+}
+
+func TestWriteFormats(t *testing.T) {
+	tests := []struct {
+		format   string
+		write    func(*bytes.Buffer) error
+		expected string
+	}{
+		{formatJSON, func(b *bytes.Buffer) error { return writeJSON(b, outputRows) },
+			`[{"title":"GitHub","login":"me","folders":["Work","Dev"]},{"title":"Say \"hi\", ok","extra":{"n":1}}]` + "\n"},
+		{formatYAML, func(b *bytes.Buffer) error { return writeYAML(b, outputRows) },
+			"- title: GitHub\n  login: me\n  folders:\n    - Work\n    - Dev\n- title: Say \"hi\", ok\n  extra:\n    n: 1\n"},
+		{formatTOML, func(b *bytes.Buffer) error { return writeTOML(b, "entries", outputRows) },
+			"[[entries]]\n  folders = [\"Work\", \"Dev\"]\n  login = \"me\"\n  title = \"GitHub\"\n\n" +
+				"[[entries]]\n  title = \"Say \\\"hi\\\", ok\"\n  [entries.extra]\n    n = 1\n"},
+		{formatCSV, func(b *bytes.Buffer) error { return writeCSV(b, outputRows) },
+			"title,login,folders,extra\nGitHub,me,\"Work, Dev\",\n\"Say \"\"hi\"\", ok\",,,\"{\"\"n\"\":1}\"\n"},
+		{formatTable, func(b *bytes.Buffer) error { return writeTable(b, outputRows) },
+			"TITLE         LOGIN  FOLDERS    EXTRA\nGitHub        me     Work, Dev\nSay \"hi\", ok                    {\"n\":1}\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := test.write(&out); err != nil {
+			t.Errorf("%s: unexpected error %v", test.format, err)
+			continue
+		}
+		if out.String() != test.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.format, test.expected, out.String())
+		}
+	}
+}
+
+func TestWriteTOML_Value(t *testing.T) {
+	// a single object is the document itself, without nulls TOML can't hold
+	var out bytes.Buffer
+	if err := writeTOML(&out, "entries", map[string]interface{}{"title": "GitHub", "login": nil}); err != nil {
+		t.Fatalf("writeTOML failed: %v", err)
+	}
+	if out.String() != "title = \"GitHub\"\n" {
+		t.Errorf("unexpected TOML %q", out.String())
+	}
+}
+
+func TestWriteCSV_Quoting(t *testing.T) {
+	tests := map[string]string{
+		"plain":         "plain",
+		"a,b":           `"a,b"`,
+		`say "hi"`:      `"say ""hi"""`,
+		"two\nlines":    "\"two\nlines\"",
+		" leading":      `" leading"`,
+		"":              "",
+		"semi;colon":    "semi;colon",
+		"tab\tin value": "tab\tin value",
+	}
+	for value, expected := range tests {
+		var out bytes.Buffer
+		if err := writeCSV(&out, []interface{}{outputRow{Title: value}}); err != nil {
+			t.Fatalf("writeCSV failed: %v", err)
+		}
+		if record := strings.TrimPrefix(out.String(), "title\n"); record != expected+"\n" {
+			t.Errorf("%q: expected %q, got %q", value, expected+"\n", record)
+		}
+	}
+}
+
+func TestWriteTable_Whitespace(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeTable(&out, []interface{}{outputRow{Title: "two\nlines\tand tab", Login: "me"}}); err != nil {
+		t.Fatalf("writeTable failed: %v", err)
+	}
+	if expected := "TITLE              LOGIN\ntwo lines and tab  me\n"; out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestColumns_NoMapping(t *testing.T) {
+	if _, _, err := columns([]interface{}{"just a string"}); err == nil {
+		t.Error("expected rows without columns to fail")
+	}
+}
+
+func TestWriteDotenv(t *testing.T) {
+	tests := map[string]string{
+		"plain":        `plain`,
+		`back\slash`:   `back\\slash`,
+		`"quoted"`:     `\"quoted\"`,
+		"two\nlines\r": `two\nlines\r`,
+		"$HOME ${X}":   `\$HOME \${X}`,
+		"'single'":     `'single'`,
+		"":             ``,
+	}
+	for value, expected := range tests {
+		var out bytes.Buffer
+		writeDotenv(&out, []envVar{{Name: "VAR", Value: value}})
+		if line := `VAR="` + expected + `"` + "\n"; out.String() != line {
+			t.Errorf("%q: expected %q, got %q", value, line, out.String())
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		parts    []string
+		expected string
+	}{
+		{[]string{"GitHub", "password"}, "GITHUB_PASSWORD"},
+		{[]string{"my-site.com", "User Name"}, "MY_SITE_COM_USER_NAME"},
+		{[]string{"AWS", "Access  Key "}, "AWS_ACCESS_KEY"},
+		{[]string{"2FA", "code"}, "_2FA_CODE"},
+		{[]string{"--a--"}, "A"},
+		{[]string{"Ünïcode", "pw"}, "N_CODE_PW"},
+		{[]string{"$(rm -rf)", "x"}, "RM_RF_X"},
+		{[]string{"", ""}, ""},
+	}
+	for _, test := range tests {
+		if name := envName(test.parts...); name != test.expected {
+			t.Errorf("envName(%q): expected %q, got %q", test.parts, test.expected, name)
+		}
+	}
+}
+
+func TestWriteTemplate(t *testing.T) {
+	entries := []interface{}{
+		entryView{Title: "AWS", Fields: []fieldView{
+			{Type: "username", Label: "Access Key", Value: "AKIA"},
+			{Type: "password", Label: "Secret", Masked: "********"},
+		}},
+		entryView{Title: "Other", Fields: []fieldView{{Type: "username", Label: "Access Key", Value: "AKIB"}}},
+	}
+	tests := []struct {
+		name     string
+		template string
+		items    []interface{}
+		expected string
+		err      string
+	}{
+		{"by label", `{{.Title}} {{field "Access Key"}}`, entries, "AWS AKIA\nOther AKIB\n", ""},
+		{"by type", `{{field "username"}}`, entries[:1], "AKIA\n", ""},
+		{"masked", `{{field "password"}}`, entries[:1], "********\n", ""},
+		{"own newline", "{{.Title}}\n", entries, "AWS\nOther\n", ""},
+		{"no items", `{{.Title}}`, nil, "", ""},
+		{"other items", `{{.Name}}={{.Value}}`, []interface{}{envVar{Name: "A", Value: "b"}}, "A=b\n", ""},
+		{"missing field", `{{field "Secret"}}`, entries, "", `entry Other has no field "Secret"`},
+		{"field of another item", `{{field "x"}}`, []interface{}{envVar{Name: "A"}}, "", "field is only available on entries"},
+		{"parse error", `{{.Title`, entries, "", "could not parse template"},
+		{"unknown property", `{{.Nope}}`, entries, "", "could not execute template"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := writeTemplate(&out, test.template, test.items)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		} else if out.String() != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, out.String())
+		}
+	}
+}
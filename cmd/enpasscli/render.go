@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// renderers for -view; auto picks one per entry by its category
+	viewAuto     = "auto"
+	viewLogin    = "login"
+	viewCard     = "card"
+	viewIdentity = "identity"
+	viewWifi     = "wifi"
+
+	// Enpass field types of credit cards
+	fieldCardholder = "ccName"
+	fieldCardType   = "ccType"
+	fieldCardNumber = "ccNumber"
+	fieldCardCVC    = "ccCvc"
+	fieldCardExpiry = "ccExpiry"
+	fieldCardPIN    = "ccPin"
+	fieldCardTxnPW  = "ccTxnpassword"
+)
+
+// field types that are sensitive whatever the vault says: list hides their
+// value and show reveals it
+var sensitiveFieldTypes = map[string]struct{}{
+	"totp": {}, fieldCardNumber: {}, fieldCardCVC: {}, fieldCardPIN: {}, fieldCardTxnPW: {},
+}
+
+// entryRenderer summarises one kind of entry in a single row.
+type entryRenderer struct {
+	// matches reports whether -view=auto renders the entry with this renderer
+	matches func(e *entryView) bool
+	// row builds the compact row; it is also marshalled as the JSON of the row
+	row func(e *entryView) compactRow
+}
+
+// compactRow is the summary of one entry.
+type compactRow interface {
+	// line is the text output of the row in compact mode
+	line() string
+	// details are the summary parts added to the entry header in detailed mode
+	details() []string
+}
+
+// renderers by view name. Entries matching no other renderer are logins.
+var renderers = map[string]entryRenderer{
+	viewCard: {
+		matches: func(e *entryView) bool {
+			return e.Category == "creditcard" || e.fieldByType(fieldCardNumber) != nil
+		},
+		row: newCardRow,
+	},
+	viewIdentity: {
+		matches: func(e *entryView) bool {
+			return e.Category == "identity"
+		},
+		row: newIdentityRow,
+	},
+	viewWifi: {
+		matches: func(e *entryView) bool {
+			return e.Category == "wifi" || strings.Contains(strings.ToLower(e.template), "wifi") ||
+				e.fieldByLabel(wifiSSIDLabels...) != nil
+		},
+		row: newWifiRow,
+	},
+	viewLogin: {
+		matches: func(e *entryView) bool {
+			return true
+		},
+		row: newLoginRow,
+	},
+}
+
+// order in which -view=auto tries the renderers
+var autoViewOrder = []string{viewCard, viewIdentity, viewWifi, viewLogin}
+
+// validView reports whether view is auto or the name of a renderer.
+func validView(view string) bool {
+	if view == viewAuto {
+		return true
+	}
+	_, ok := renderers[view]
+	return ok
+}
+
+// viewNames lists the accepted values of -view.
+func viewNames() []string {
+	names := []string{viewAuto}
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// entryKind picks the renderer of an entry: the one forced by -view, or
+// the first one matching its category.
+func entryKind(e *entryView, view string) string {
+	if view != viewAuto {
+		return view
+	}
+	for _, kind := range autoViewOrder {
+		if renderers[kind].matches(e) {
+			return kind
+		}
+	}
+	return viewLogin
+}
+
+// maskCardNumber hides every digit of a card number except the last 4.
+func maskCardNumber(number string) string {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, number)
+	if len(digits) <= 4 {
+		return "****"
+	}
+	return "**** " + digits[len(digits)-4:]
+}
+
+func (e *entryView) fieldByType(types ...string) *fieldView {
+	for _, t := range types {
+		for i := range e.Fields {
+			if e.Fields[i].Type == t {
+				return &e.Fields[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (e *entryView) fieldByLabel(labels ...string) *fieldView {
+	for _, label := range labels {
+		for i := range e.Fields {
+			if strings.EqualFold(strings.TrimSpace(e.Fields[i].Label), label) {
+				return &e.Fields[i]
+			}
+		}
+	}
+	return nil
+}
+
+// displayValue is what the summary shows of a field: its value, or its masked
+// form when the value is withheld.
+func (f *fieldView) displayValue() string {
+	if f == nil {
+		return ""
+	}
+	if f.Value != "" {
+		return f.Value
+	}
+	return f.Masked
+}
+
+// summaryParts formats non-empty name/value pairs as "name: value".
+func summaryParts(pairs ...string) []string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			parts = append(parts, pairs[i]+": "+pairs[i+1])
+		}
+	}
+	return parts
+}
+
+// summaryLine is the compact line of every renderer but the login one.
+func summaryLine(vault, title, category string, details []string) string {
+	parts := summaryParts("vault", vault)
+	parts = append(parts, "title: "+title, "cat.: "+category)
+	return "> " + strings.Join(append(parts, details...), "  ")
+}
+
+// loginRow is the original compact row: title, login, category, label, type
+// and, in show mode, the password.
+type loginRow struct {
+	Kind     string `json:"kind"`
+	Vault    string `json:"vault,omitempty"`
+	Title    string `json:"title"`
+	Login    string `json:"login"`
+	Category string `json:"category"`
+	Label    string `json:"label"`
+	Type     string `json:"type"`
+	Password string `json:"password,omitempty"`
+}
+
+func newLoginRow(e *entryView) compactRow {
+	row := &loginRow{
+		Kind:     viewLogin,
+		Vault:    e.Vault,
+		Title:    e.Title,
+		Login:    e.Subtitle,
+		Category: e.Category,
+	}
+	if anchor := anchorField(e.Fields); anchor != nil {
+		row.Label = anchor.Label
+		row.Type = anchor.Type
+		if anchor.Sensitive {
+			row.Password = anchor.Value
+		}
+	}
+	return row
+}
+
+func (r *loginRow) line() string {
+	line := fmt.Sprintf("> title: %s  login: %s  cat.: %s  label: %s  type: %s", r.Title, r.Login, r.Category, r.Label, r.Type)
+	if r.Vault != "" {
+		line = "> vault: " + r.Vault + "  " + line[2:]
+	}
+	if r.Password != "" {
+		line += "  password: " + r.Password
+	}
+	return line
+}
+
+func (r *loginRow) details() []string {
+	return nil
+}
+
+// cardRow summarises a credit card. The number is masked to its last 4 digits
+// and the CVV and PIN are left out unless sensitive values are shown.
+type cardRow struct {
+	Kind       string `json:"kind"`
+	Vault      string `json:"vault,omitempty"`
+	Title      string `json:"title"`
+	Category   string `json:"category"`
+	Cardholder string `json:"cardholder,omitempty"`
+	Brand      string `json:"brand,omitempty"`
+	Number     string `json:"number,omitempty"`
+	Expiry     string `json:"expiry,omitempty"`
+	CVV        string `json:"cvv,omitempty"`
+	PIN        string `json:"pin,omitempty"`
+}
+
+func newCardRow(e *entryView) compactRow {
+	return &cardRow{
+		Kind:       viewCard,
+		Vault:      e.Vault,
+		Title:      e.Title,
+		Category:   e.Category,
+		Cardholder: e.fieldByType(fieldCardholder).displayValue(),
+		Brand:      e.fieldByType(fieldCardType).displayValue(),
+		Number:     e.fieldByType(fieldCardNumber).displayValue(),
+		Expiry:     e.fieldByType(fieldCardExpiry).displayValue(),
+		CVV:        e.fieldByType(fieldCardCVC).displayValue(),
+		PIN:        e.fieldByType(fieldCardPIN).displayValue(),
+	}
+}
+
+func (r *cardRow) line() string {
+	return summaryLine(r.Vault, r.Title, r.Category, r.details())
+}
+
+func (r *cardRow) details() []string {
+	return summaryParts("cardholder", r.Cardholder, "brand", r.Brand, "number", r.Number,
+		"expiry", r.Expiry, "cvv", r.CVV, "pin", r.PIN)
+}
+
+// labels of the identity template fields, which are all plain text fields
+var (
+	identityNameLabels    = []string{"name", "full name"}
+	identityNamePartLabel = []string{"first name", "middle name", "last name"}
+	identityAddressLabels = []string{
+		"address", "address 1", "address 2", "street", "city", "state", "province",
+		"zip", "zip code", "postal code", "country",
+	}
+)
+
+// identityRow summarises an identity.
+type identityRow struct {
+	Kind     string `json:"kind"`
+	Vault    string `json:"vault,omitempty"`
+	Title    string `json:"title"`
+	Category string `json:"category"`
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Phone    string `json:"phone,omitempty"`
+	Address  string `json:"address,omitempty"`
+}
+
+func newIdentityRow(e *entryView) compactRow {
+	name := e.fieldByLabel(identityNameLabels...).displayValue()
+	if name == "" {
+		name = joinFieldValues(e, identityNamePartLabel, " ")
+	}
+	return &identityRow{
+		Kind:     viewIdentity,
+		Vault:    e.Vault,
+		Title:    e.Title,
+		Category: e.Category,
+		Name:     name,
+		Email:    e.fieldByType("email").displayValue(),
+		Phone:    e.fieldByType("phone").displayValue(),
+		Address:  joinFieldValues(e, identityAddressLabels, ", "),
+	}
+}
+
+// joinFieldValues joins the values of the fields with the given labels, in
+// the order of the labels.
+func joinFieldValues(e *entryView, labels []string, sep string) string {
+	values := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if value := e.fieldByLabel(label).displayValue(); value != "" {
+			values = append(values, strings.Join(strings.Fields(value), " "))
+		}
+	}
+	return strings.Join(values, sep)
+}
+
+func (r *identityRow) line() string {
+	return summaryLine(r.Vault, r.Title, r.Category, r.details())
+}
+
+func (r *identityRow) details() []string {
+	return summaryParts("name", r.Name, "email", r.Email, "phone", r.Phone, "address", r.Address)
+}
+
+// labels of the Wi-Fi template fields
+var (
+	wifiSSIDLabels     = []string{"ssid", "network name"}
+	wifiSecurityLabels = []string{"security", "security type", "encryption"}
+)
+
+// wifiRow summarises a Wi-Fi network.
+type wifiRow struct {
+	Kind     string `json:"kind"`
+	Vault    string `json:"vault,omitempty"`
+	Title    string `json:"title"`
+	Category string `json:"category"`
+	SSID     string `json:"ssid,omitempty"`
+	Security string `json:"security,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func newWifiRow(e *entryView) compactRow {
+	return &wifiRow{
+		Kind:     viewWifi,
+		Vault:    e.Vault,
+		Title:    e.Title,
+		Category: e.Category,
+		SSID:     e.fieldByLabel(wifiSSIDLabels...).displayValue(),
+		Security: e.fieldByLabel(wifiSecurityLabels...).displayValue(),
+		Password: e.fieldByType("password").displayValue(),
+	}
+}
+
+func (r *wifiRow) line() string {
+	return summaryLine(r.Vault, r.Title, r.Category, r.details())
+}
+
+func (r *wifiRow) details() []string {
+	return summaryParts("ssid", r.SSID, "security", r.Security, "password", r.Password)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMaskCardNumber(t *testing.T) {
+	tests := map[string]string{
+		"4111111111111111":    "**** 1111",
+		"4111 1111 1111 1234": "**** 1234",
+		"4111-1111-1111-9876": "**** 9876",
+		"12345":               "**** 2345",
+		"1234":                "****",
+		"":                    "****",
+		"no digits":           "****",
+	}
+	for number, expected := range tests {
+		if masked := maskCardNumber(number); masked != expected {
+			t.Errorf("maskCardNumber(%q): expected %q, got %q", number, expected, masked)
+		}
+	}
+}
+
+func TestEntryKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    entryView
+		view     string
+		expected string
+	}{
+		{"login", entryView{Category: "login", Fields: []fieldView{{Type: "password"}}}, viewAuto, viewLogin},
+		{"unknown category", entryView{Category: "note"}, viewAuto, viewLogin},
+		{"card category", entryView{Category: "creditcard"}, viewAuto, viewCard},
+		{"card number field", entryView{Category: "finance", Fields: []fieldView{{Type: fieldCardNumber}}}, viewAuto, viewCard},
+		{"identity", entryView{Category: "identity"}, viewAuto, viewIdentity},
+		{"wifi category", entryView{Category: "wifi"}, viewAuto, viewWifi},
+		{"wifi template", entryView{Category: "computer", template: "computer.wifi"}, viewAuto, viewWifi},
+		{"ssid field", entryView{Category: "misc", Fields: []fieldView{{Type: "text", Label: " SSID "}}}, viewAuto, viewWifi},
+		{"card before wifi", entryView{Category: "creditcard", Fields: []fieldView{{Type: "text", Label: "SSID"}}}, viewAuto, viewCard},
+		{"forced view", entryView{Category: "creditcard"}, viewLogin, viewLogin},
+	}
+	for _, test := range tests {
+		if kind := entryKind(&test.entry, test.view); kind != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, kind)
+		}
+	}
+}
+
+// the schemas must describe the JSON the rows are marshalled to
+func TestRowSchemas(t *testing.T) {
+	for kind, renderer := range renderers {
+		raw, err := rowSchema(kind)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		var schema struct {
+			Properties map[string]struct {
+				Const string `json:"const"`
+			} `json:"properties"`
+			Required []string `json:"required"`
+		}
+		if err := json.Unmarshal(raw, &schema); err != nil {
+			t.Fatalf("%s: invalid schema: %v", kind, err)
+		}
+		if schema.Properties["kind"].Const != kind {
+			t.Errorf("%s: expected kind to be %q, got %q", kind, kind, schema.Properties["kind"].Const)
+		}
+
+		row := reflect.TypeOf(renderer.row(&entryView{})).Elem()
+		var properties, required []string
+		for i := 0; i < row.NumField(); i++ {
+			name, options, _ := strings.Cut(row.Field(i).Tag.Get("json"), ",")
+			properties = append(properties, name)
+			if options != "omitempty" {
+				required = append(required, name)
+			}
+		}
+		var schemaProperties []string
+		for name := range schema.Properties {
+			schemaProperties = append(schemaProperties, name)
+		}
+		sort.Strings(properties)
+		sort.Strings(schemaProperties)
+		sort.Strings(required)
+		sort.Strings(schema.Required)
+		if !reflect.DeepEqual(properties, schemaProperties) {
+			t.Errorf("%s: row has %v, schema describes %v", kind, properties, schemaProperties)
+		}
+		if !reflect.DeepEqual(required, schema.Required) {
+			t.Errorf("%s: row always has %v, schema requires %v", kind, required, schema.Required)
+		}
+	}
+}
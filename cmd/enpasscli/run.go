@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// mappings read by 'run' when none are given on the command line
+	defaultRunEnvFile = ".enpass-env"
+	// what 'run -mask' prints instead of a secret
+	maskedValue = "********"
+	// shorter values aren't masked, they would garble unrelated output
+	minMaskedLength = 4
+)
+
+// runCommand handles 'run [VAR=filter[:field]...] -- command [args...]'. The
+// variables are only passed to the child's environment and never printed.
+// It returns the exit code of the child.
+func runCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args) int {
+	mappingArgs, command, err := splitRunArgs(args.filters)
+	if err != nil {
+		logger.WithError(err).Fatal("usage: run [VAR=filter[:field]...] -- command [args...]")
+	}
+	if len(mappingArgs) == 0 {
+		if mappingArgs, err = readRunEnvFile(*args.envFile); err != nil {
+			logger.WithError(err).Fatal("could not read mappings")
+		}
+	}
+	if len(mappingArgs) == 0 {
+		logger.Fatal("run command requires at least one VAR=filter mapping, as argument or in " + *args.envFile)
+	}
+
+	env := os.Environ()
+	secrets := make([]string, 0, len(mappingArgs))
+	for _, arg := range mappingArgs {
+		mapping, err := parseRunMapping(arg)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		if mapping.field == "" {
+			mapping.field = *args.field
+		}
+
+		value, card, err := resolveField(vault, *args.cardType, mapping.filter, mapping.field)
+		if err != nil {
+			logger.WithError(err).Fatalf("could not resolve %s", mapping.name)
+		}
+		auditEntry(logger, vault, args, card, fieldName(card))
+
+		env = append(env, mapping.name+"="+value)
+		secrets = append(secrets, value)
+	}
+
+	// the child may run for long, don't keep the vault open meanwhile
+	vault.Close()
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	var masks []*maskingWriter
+	if *args.mask {
+		stdout, stderr := newMaskingWriter(os.Stdout, secrets), newMaskingWriter(os.Stderr, secrets)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		masks = append(masks, stdout, stderr)
+	}
+
+	if err := cmd.Start(); err != nil {
+		logger.WithError(err).Fatal("could not start " + command[0])
+	}
+
+	// Ctrl-C reaches the child from the terminal already, so interrupts are caught and
+	// dropped to keep us alive until it exits; a SIGTERM sent to us is passed on to it
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	signal.Stop(signals)
+	close(signals)
+	for _, m := range masks {
+		m.Flush()
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		logger.WithError(err).Fatal("could not run " + command[0])
+	}
+	return 0
+}
+
+// splitRunArgs splits the arguments of run at "--" into mappings and the command.
+func splitRunArgs(runArgs []string) ([]string, []string, error) {
+	for i, arg := range runArgs {
+		if arg == "--" {
+			if i == len(runArgs)-1 {
+				return nil, nil, errors.New("no command given after --")
+			}
+			return runArgs[:i], runArgs[i+1:], nil
+		}
+	}
+	return nil, nil, errors.New("no -- before the command")
+}
+
+// parseRunMapping parses VAR=filter[:field]. The field follows the last colon
+// that is neither escaped as \: nor followed by //, which belongs to a URL in
+// the filter; escaped colons are part of the filter or field.
+func parseRunMapping(arg string) (envMapping, error) {
+	mapping, err := parseEnvMapping(arg)
+	if err != nil {
+		return mapping, err
+	}
+	for i := len(mapping.filter) - 1; i >= 0; i-- {
+		escaped := i > 0 && mapping.filter[i-1] == '\\'
+		if mapping.filter[i] != ':' || escaped || strings.HasPrefix(mapping.filter[i+1:], "//") {
+			continue
+		}
+		mapping.filter, mapping.field = mapping.filter[:i], mapping.filter[i+1:]
+		break
+	}
+	mapping.filter = strings.ReplaceAll(mapping.filter, `\:`, ":")
+	mapping.field = strings.ReplaceAll(mapping.field, `\:`, ":")
+	return mapping, nil
+}
+
+// readRunEnvFile reads VAR=filter[:field] lines, skipping blank lines and
+// # comments. A missing file yields no mappings.
+func readRunEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not open "+path)
+	}
+	defer f.Close()
+
+	mappings := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		mappings = append(mappings, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read "+path)
+	}
+	return mappings, nil
+}
+
+// maskingWriter replaces secrets in everything written through it. The end of
+// a write that could be the start of a secret is held back until the next
+// write or Flush, so secrets split across writes are masked too.
+type maskingWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	secrets [][]byte
+	pending []byte
+}
+
+func newMaskingWriter(w io.Writer, secrets []string) *maskingWriter {
+	m := &maskingWriter{w: w}
+	for _, secret := range secrets {
+		if len(secret) >= minMaskedLength {
+			m.secrets = append(m.secrets, []byte(secret))
+		}
+	}
+	// mask the longest secret first when one contains another
+	sort.Slice(m.secrets, func(i, j int) bool {
+		return len(m.secrets[i]) > len(m.secrets[j])
+	})
+	return m
+}
+
+func (m *maskingWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	masked, pending := m.mask(append(m.pending, p...), false)
+	m.pending = append([]byte(nil), pending...)
+	if _, err := m.w.Write(masked); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// mask replaces the secrets in buf from left to right, the longest one where
+// several start at the same byte. Unless final, it stops at the first byte from
+// which the rest of buf is the beginning of a secret, and returns that rest to
+// be held back.
+func (m *maskingWriter) mask(buf []byte, final bool) ([]byte, []byte) {
+	masked := make([]byte, 0, len(buf))
+	for i := 0; i < len(buf); {
+		secret := m.secretAt(buf[i:])
+		if secret != nil {
+			masked = append(masked, maskedValue...)
+			i += len(secret)
+			continue
+		}
+		if !final && m.startsSecret(buf[i:]) {
+			return masked, buf[i:]
+		}
+		masked = append(masked, buf[i])
+		i++
+	}
+	return masked, nil
+}
+
+// secretAt returns the longest secret buf starts with, nil when there is none.
+func (m *maskingWriter) secretAt(buf []byte) []byte {
+	for _, secret := range m.secrets {
+		if bytes.HasPrefix(buf, secret) {
+			return secret
+		}
+	}
+	return nil
+}
+
+// startsSecret tells whether buf is the beginning of a secret that the next
+// write could complete.
+func (m *maskingWriter) startsSecret(buf []byte) bool {
+	for _, secret := range m.secrets {
+		if len(buf) < len(secret) && bytes.HasPrefix(secret, buf) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush writes what was held back, with the secrets it contains masked.
+func (m *maskingWriter) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	masked, _ := m.mask(m.pending, true)
+	_, _ = m.w.Write(masked)
+	m.pending = nil
+}
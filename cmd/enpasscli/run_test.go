@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseRunMapping(t *testing.T) {
+	tests := []struct {
+		arg    string
+		name   string
+		filter string
+		field  string
+	}{
+		{"TOKEN=GitHub", "TOKEN", "GitHub", ""},
+		{"KEY=AWS:Access Key", "KEY", "AWS", "Access Key"},
+		{"DB=db\\:prod", "DB", "db:prod", ""},
+		{"DB=db\\:prod:username", "DB", "db:prod", "username"},
+		{"DB=db:Label\\:x", "DB", "db", "Label:x"},
+		{"SITE=https://example.com", "SITE", "https://example.com", ""},
+		{"SITE=https://example.com:username", "SITE", "https://example.com", "username"},
+		{"REF=enpass://489e13cc-3dea-40a9-b883-2bd61f2f4f48/password", "REF", "enpass://489e13cc-3dea-40a9-b883-2bd61f2f4f48/password", ""},
+		{"EMPTY=AWS:", "EMPTY", "AWS", ""},
+		{"A=b=c", "A", "b=c", ""},
+	}
+	for _, test := range tests {
+		mapping, err := parseRunMapping(test.arg)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.arg, err)
+			continue
+		}
+		if mapping.name != test.name || mapping.filter != test.filter || mapping.field != test.field {
+			t.Errorf("%s: expected %s=%q field %q, got %s=%q field %q",
+				test.arg, test.name, test.filter, test.field, mapping.name, mapping.filter, mapping.field)
+		}
+	}
+
+	for _, arg := range []string{"GitHub", "=GitHub", "1VAR=GitHub", "MY-VAR=GitHub"} {
+		if _, err := parseRunMapping(arg); err == nil {
+			t.Errorf("%s: expected an error", arg)
+		}
+	}
+}
+
+func TestMaskingWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		secrets  []string
+		writes   []string
+		expected string
+	}{
+		{"no secrets", nil, []string{"plain ", "output"}, "plain output"},
+		{"one write", []string{"hunter22"}, []string{"pw hunter22 ok"}, "pw ******** ok"},
+		{"repeated", []string{"hunter22"}, []string{"hunter22hunter22"}, "****************"},
+		{"split across writes", []string{"hunter22"}, []string{"pw hun", "ter", "22 ok"}, "pw ******** ok"},
+		{"one byte per write", []string{"hunter22"}, []string{"h", "u", "n", "t", "e", "r", "2", "2"}, "********"},
+		{"false start", []string{"hunter22"}, []string{"hunt", "ing hunter22"}, "hunting ********"},
+		{"short values", []string{"abc", ""}, []string{"abc"}, "abc"},
+		{"contained secret", []string{"cdef", "abcdefgh"}, []string{"abcdefgh"}, "********"},
+		{"contained secret split", []string{"cdef", "abcdefgh"}, []string{"abcdef", "gh"}, "********"},
+		{"contained secret alone", []string{"cdef", "abcdefgh"}, []string{"abcdef", "gX"}, "ab********gX"},
+		{"overlapping secrets", []string{"xyab", "abcd"}, []string{"xyabcd"}, "********cd"},
+		{"overlapping secrets split", []string{"xyab", "abcd"}, []string{"xya", "bcd"}, "********cd"},
+		{"adjacent secrets", []string{"xyab", "abcd"}, []string{"xy", "ababcd"}, "****************"},
+		{"held back at flush", []string{"hunter22"}, []string{"pw hunter"}, "pw hunter"},
+		{"secret held back at flush", []string{"cdef", "abcdefgh"}, []string{"abcdef"}, "ab********"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		m := newMaskingWriter(&out, test.secrets)
+		for _, write := range test.writes {
+			if n, err := m.Write([]byte(write)); err != nil || n != len(write) {
+				t.Fatalf("%s: Write returned %d, %v", test.name, n, err)
+			}
+		}
+		m.Flush()
+		if out.String() != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, out.String())
+		}
+	}
+}
+
+// nothing that could still be a secret is written before it is known not to be one
+func TestMaskingWriter_HoldsBack(t *testing.T) {
+	var out bytes.Buffer
+	m := newMaskingWriter(&out, []string{"hunter22"})
+	_, _ = m.Write([]byte("pw: hunt"))
+	if out.String() != "pw: " {
+		t.Errorf("expected the start of the secret to be held back, got %q", out.String())
+	}
+	_, _ = m.Write([]byte("ed\n"))
+	if out.String() != "pw: hunted\n" {
+		t.Errorf("expected the held back bytes once they can't be a secret, got %q", out.String())
+	}
+	m.Flush()
+	if out.String() != "pw: hunted\n" {
+		t.Errorf("expected Flush to write nothing more, got %q", out.String())
+	}
+}
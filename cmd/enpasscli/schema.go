@@ -0,0 +1,72 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JSON schemas of the compact -json rows, one per renderer
+//
+//go:embed schemas/*.schema.json
+var rowSchemas embed.FS
+
+// rowSchema returns the JSON schema of the rows of a renderer.
+func rowSchema(kind string) (json.RawMessage, error) {
+	schema, err := rowSchemas.ReadFile("schemas/" + kind + ".schema.json")
+	if err != nil {
+		return nil, fmt.Errorf("no schema for %q: expected one of %s", kind, strings.Join(viewNames()[1:], ", "))
+	}
+	return schema, nil
+}
+
+// schemaCommand handles 'schema [KIND]': it prints the JSON schema of the
+// compact -json rows of a kind of entry, or without a kind one schema
+// accepting the row of any kind, told apart by their kind property.
+func schemaCommand(logger *logrus.Logger, args *Args) {
+	var schema interface{}
+	switch len(args.filters) {
+	case 0:
+		defs := make(map[string]map[string]interface{})
+		oneOf := make([]map[string]string, 0, len(renderers))
+		for _, kind := range viewNames()[1:] {
+			raw, err := rowSchema(kind)
+			if err != nil {
+				logger.WithError(err).Fatal("could not read schema")
+			}
+			var def map[string]interface{}
+			if err := json.Unmarshal(raw, &def); err != nil {
+				logger.WithError(err).Fatal("could not parse schema")
+			}
+			// only the root of the combined schema names the dialect
+			delete(def, "$schema")
+			defs[kind] = def
+			oneOf = append(oneOf, map[string]string{"$ref": "#/$defs/" + kind})
+		}
+		schema = map[string]interface{}{
+			"$schema":     "https://json-schema.org/draft/2020-12/schema",
+			"title":       "enpass-cli entry",
+			"description": "A row of list or show with -json, its kind tells its fields.",
+			"oneOf":       oneOf,
+			"$defs":       defs,
+		}
+	case 1:
+		def, err := rowSchema(args.filters[0])
+		if err != nil {
+			logger.WithError(err).Fatal("could not read schema")
+		}
+		schema = def
+	default:
+		logger.Fatal("usage: schema [KIND]")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(schema); err != nil {
+		logger.WithError(err).Fatal("could not write schema")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hazcod/enpass-cli/pkg/api"
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// serve subcommand printing a new token
+	serveCmdToken = "token"
+
+	defaultServeListen = "127.0.0.1:8765"
+	unixListenPrefix   = "unix:"
+)
+
+// serveNeedsVault tells whether the serve subcommand serves the vault, or only
+// prints a new token.
+func serveNeedsVault(args *Args) bool {
+	return len(args.filters) == 0 || args.filters[0] != serveCmdToken
+}
+
+// serveMutates tells whether any token may modify the vault, so it has to be
+// opened read-write.
+func serveMutates(args *Args) bool {
+	for _, token := range args.apiTokens {
+		if token.Allows(api.ScopeWrite) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveToken handles 'serve token [NAME]': it prints a new token and the
+// profile setting granting it read access.
+func serveToken(logger *logrus.Logger, args *Args) {
+	name := "client"
+	if len(args.filters) > 1 {
+		name = args.filters[1]
+	}
+	token, hash, err := api.NewToken()
+	if err != nil {
+		logger.WithError(err).Fatal("could not create token")
+	}
+	fmt.Printf("token: %s\n\n", token)
+	fmt.Printf("[[profiles.<name>.api_tokens]]\nname = %q\nsha256 = %q\nscopes = [%q]\n", name, hash, api.ScopeRead)
+}
+
+// listen opens -listen, which is a loopback address or unix:PATH. The
+// socket is only accessible by the user.
+func listen(address string) (net.Listener, func(), error) {
+	if socket, found := strings.CutPrefix(address, unixListenPrefix); found {
+		if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+			return nil, nil, errors.Wrap(err, "could not create socket directory")
+		}
+		_ = os.Remove(socket)
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "could not listen on "+socket)
+		}
+		if err := os.Chmod(socket, 0600); err != nil {
+			listener.Close()
+			return nil, nil, errors.Wrap(err, "could not restrict socket permissions")
+		}
+		return listener, func() { _ = os.Remove(socket) }, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid address "+address)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, nil, errors.New("refusing to listen on " + address + ", only loopback addresses and unix sockets are allowed")
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not listen on "+address)
+	}
+	return listener, func() {}, nil
+}
+
+// serveCommand handles 'serve': it answers the HTTP API on -listen for the
+// api_tokens of the profile until it is interrupted.
+func serveCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+	if len(args.filters) > 0 {
+		logger.Fatal("usage: serve, or serve token [NAME]")
+	}
+	if len(args.apiTokens) == 0 {
+		logger.Fatal("no api_tokens are configured in the profile, create one with 'serve token'")
+	}
+	for _, token := range args.apiTokens {
+		if token.Name == "" || len(token.SHA256) != 64 {
+			logger.Fatal("every api_tokens entry needs a name and the sha256 printed by 'serve token'")
+		}
+		for _, scope := range token.Scopes {
+			if !api.ValidScope(scope) {
+				logger.Fatalf("unknown scope %q of token %s", scope, token.Name)
+			}
+		}
+	}
+
+	// requests are always recorded, -audit or not
+	log := auditLog(args)
+
+	listener, cleanup, err := listen(*args.listen)
+	if err != nil {
+		logger.WithError(err).Fatal("could not serve")
+	}
+	defer cleanup()
+
+	server := &http.Server{
+		Handler:           api.NewServer(logger, vault, args.apiTokens, log),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	logger.WithField("address", listener.Addr().String()).WithField("audit_log", log.Path()).
+		WithField("read_only", vault.IsReadOnly()).Info("serving vault API")
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.WithError(err).Error("API server failed")
+	}
+	signal.Stop(signals)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/hazcod/enpass-cli/pkg/sshagent"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultAgentSocket is the socket 'ssh-agent' listens on when none is given,
+// in a directory only the user can enter.
+func defaultAgentSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "enpass-cli", "agent.sock")
+}
+
+// checkSocketDir refuses a socket directory that isn't a directory of the
+// user only, which another user could have created first under the shared
+// temporary directory when XDG_RUNTIME_DIR is unset.
+func checkSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Getuid() {
+		return errors.New(dir + " is not a directory owned by you")
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		return errors.Errorf("%s has mode %o instead of 700", dir, perm)
+	}
+	return nil
+}
+
+// sshAgentCommand handles 'ssh-agent [SOCKET]': it loads the private keys of
+// the vault, closes it and serves the keys until it is interrupted. Like
+// ssh-agent, it prints the SSH_AUTH_SOCK assignment for the shell to eval.
+func sshAgentCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+	if len(args.filters) > 1 {
+		logger.Fatal("usage: ssh-agent [SOCKET]")
+	}
+	socket, ownDir := defaultAgentSocket(), true
+	if len(args.filters) == 1 {
+		socket, ownDir = args.filters[0], false
+	}
+	log := logger.WithField("socket", socket)
+
+	var confirmSignature func(comment string) bool
+	if *args.sshConfirm {
+		confirmSignature = signatureConfirmation(logger, args)
+	}
+	agent := sshagent.New(logger, confirmSignature)
+	added, err := agent.LoadVault(vault, &sshagent.Options{Category: *args.sshCategory, Labels: args.sshKeyLabels})
+	if err != nil {
+		log.WithError(err).Fatal("could not load keys")
+	}
+	vault.Close()
+	if added == 0 {
+		logger.Warn("no private keys found, store them in the notes of " + *args.sshCategory +
+			" entries or in fields labelled " + sshagent.DefaultKeyLabel)
+	}
+	log.WithField("keys", added).Debug("loaded private keys")
+
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		log.WithError(err).Fatal("could not create socket directory")
+	}
+	if ownDir {
+		if err := checkSocketDir(filepath.Dir(socket)); err != nil {
+			log.WithError(err).Fatal("refusing to listen in socket directory")
+		}
+	}
+	// a socket left behind by an agent that didn't shut down cleanly
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		log.Fatal("another agent is listening on the socket")
+	}
+	_ = os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		log.WithError(err).Fatal("could not listen on socket")
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		log.WithError(err).Fatal("could not restrict socket permissions")
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	fmt.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", socket)
+	err = agent.Serve(listener)
+	signal.Stop(signals)
+	_ = os.Remove(socket)
+	if err != nil {
+		log.WithError(err).Fatal("agent failed")
+	}
+	log.Debug("agent stopped")
+}
+
+// signatureConfirmation asks before every signature, through SSH_ASKPASS
+// like ssh-add -c when it is set, or else on the terminal one at a time.
+func signatureConfirmation(logger *logrus.Logger, args *Args) func(comment string) bool {
+	var mu sync.Mutex
+	return func(comment string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		msg := "Allow use of key " + comment + "?"
+		askpass := os.Getenv("SSH_ASKPASS")
+		if askpass == "" {
+			return confirm(logger, args, msg)
+		}
+		cmd := exec.Command(askpass, msg)
+		cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+		if err := cmd.Run(); err != nil {
+			logger.WithError(err).Debug("signature not confirmed")
+			return false
+		}
+		return true
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// orders for -sort
+	sortByTitle  = "title"
+	sortByRecent = "recent"
+)
+
+// commands that record the use of an entry in last_used and usage_count, like
+// the Enpass apps do, when -recordUsage is passed
+var usageCommands = map[string]struct{}{
+	cmdCopy: {}, cmdPass: {}, cmdEnv: {},
+}
+
+// sortOrder is the value of -sort. A bare -sort sorts by title, so existing
+// scripts keep working; -sort=recent puts the most recently used entries first.
+type sortOrder string
+
+func (s *sortOrder) String() string {
+	if s == nil {
+		return ""
+	}
+	return string(*s)
+}
+
+func (s *sortOrder) Set(value string) error {
+	switch strings.ToLower(value) {
+	case "true", sortByTitle:
+		*s = sortByTitle
+	case "false", "":
+		*s = ""
+	case sortByRecent:
+		*s = sortByRecent
+	default:
+		return fmt.Errorf("unknown sort order %q: expected %s or %s", value, sortByTitle, sortByRecent)
+	}
+	return nil
+}
+
+// IsBoolFlag allows -sort without a value.
+func (s *sortOrder) IsBoolFlag() bool {
+	return true
+}
+
+// UnmarshalTOML accepts both the old sort = true and sort = "recent".
+func (s *sortOrder) UnmarshalTOML(value interface{}) error {
+	switch v := value.(type) {
+	case bool:
+		return s.Set(fmt.Sprint(v))
+	case string:
+		return s.Set(v)
+	default:
+		return fmt.Errorf("invalid sort order %v", value)
+	}
+}
+
+// sortEntryViews sorts the grouped entries by the given order.
+func sortEntryViews(entries []entryView, order sortOrder) {
+	switch order {
+	case sortByTitle:
+		sort.SliceStable(entries, func(i, j int) bool {
+			return strings.ToLower(entries[i].Title) < strings.ToLower(entries[j].Title)
+		})
+	case sortByRecent:
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].LastUsed > entries[j].LastUsed
+		})
+	}
+}
+
+// openForUsage opens the vault read-write so the use of an entry can be
+// recorded. When it can't be opened for writing, e.g. while the Enpass app
+// keeps it busy, recording is skipped rather than failing the command.
+func openForUsage(logger *logrus.Logger, vault *enpass.Vault, credentials *enpass.VaultCredentials) error {
+	err := vault.Open(credentials)
+	if err == nil {
+		return nil
+	}
+	logger.WithError(err).Debug("could not open vault for writing, not recording usage")
+	vault.Close()
+	return vault.OpenReadOnly(credentials)
+}
+
+// recordUsage updates last_used and usage_count of a used entry. Failing to
+// record it is not worth failing the command for.
+func recordUsage(logger *logrus.Logger, vault entrySource, card *enpass.Card) {
+	v, ok := vault.(*enpass.Vault)
+	if !ok || v.IsReadOnly() {
+		return
+	}
+	if err := v.RecordUsage(card.UUID); err != nil {
+		logger.WithError(err).WithField("title", card.Title).Warn("could not record usage of entry")
+	}
+}
+
+func archiveEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args, archive bool) {
+	card, err := vault.GetEntry(*args.cardType, args.filters, true)
+	if err != nil {
+		logger.WithError(err).Fatal("could not find unique entry")
+	}
+
+	if archive {
+		if err := vault.ArchiveEntry(card.UUID); err != nil {
+			logger.WithError(err).Fatal("could not archive entry")
+		}
+		logger.Printf("Archived: %s", card.Title)
+		return
+	}
+
+	if err := vault.UnarchiveEntry(card.UUID); err != nil {
+		logger.WithError(err).Fatal("could not unarchive entry")
+	}
+	logger.Printf("Unarchived: %s", card.Title)
+}
+
+func favoriteEntry(logger *logrus.Logger, vault *enpass.Vault, args *Args, favorite bool) {
+	card, err := vault.GetEntry(*args.cardType, args.filters, true)
+	if err != nil {
+		logger.WithError(err).Fatal("could not find unique entry")
+	}
+
+	if favorite {
+		if err := vault.FavoriteEntry(card.UUID); err != nil {
+			logger.WithError(err).Fatal("could not mark entry as favorite")
+		}
+		logger.Printf("Marked as favorite: %s", card.Title)
+		return
+	}
+
+	if err := vault.UnfavoriteEntry(card.UUID); err != nil {
+		logger.WithError(err).Fatal("could not remove favorite mark")
+	}
+	logger.Printf("Removed favorite mark: %s", card.Title)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+// watchMatches tells whether an event is about an entry the filters select,
+// by title or subtitle like the other commands. No filters select every entry.
+func watchMatches(event *enpass.ChangeEvent, args *Args) bool {
+	if len(args.filters) == 0 {
+		return true
+	}
+	for _, filter := range args.filters {
+		filter = strings.ToLower(filter)
+		found := strings.Contains(strings.ToLower(event.Title), filter) ||
+			strings.Contains(strings.ToLower(event.Subtitle), filter)
+		if found && !*args.and {
+			return true
+		} else if !found && *args.and {
+			return false
+		}
+	}
+	return *args.and
+}
+
+// runHook runs -hook through the shell for one event. The event is passed as
+// JSON on stdin and in ENPASS_EVENT, ENPASS_UUID and ENPASS_TITLE; the output of
+// the hook goes to stderr to keep stdout a stream of events.
+func runHook(logger *logrus.Logger, hook string, event *enpass.ChangeEvent, line []byte) {
+	cmd := exec.Command("/bin/sh", "-c", hook)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", hook)
+	}
+	cmd.Env = append(os.Environ(),
+		"ENPASS_EVENT="+string(event.Event),
+		"ENPASS_UUID="+event.UUID,
+		"ENPASS_TITLE="+event.Title,
+	)
+	cmd.Stdin = bytes.NewReader(line)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		logger.WithError(err).WithField("uuid", event.UUID).Warn("hook failed")
+	}
+}
+
+// watchCommand handles 'watch [FILTER...]': it prints a JSON line for every
+// entry created, updated, trashed or deleted until it is interrupted, and runs
+// -hook for each one.
+func watchCommand(logger *logrus.Logger, vault *enpass.Vault, args *Args) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	events, err := vault.Watch(ctx)
+	if err != nil {
+		logger.WithError(err).Fatal("could not watch vault")
+	}
+	logger.WithField("filters", args.filters).Info("watching vault for changes")
+
+	for event := range events {
+		if !watchMatches(&event, args) {
+			continue
+		}
+		line, err := json.Marshal(event)
+		if err != nil {
+			logger.WithError(err).Fatal("could not marshal event")
+		}
+		fmt.Println(string(line))
+		if *args.hook != "" {
+			runHook(logger, *args.hook, &event, line)
+		}
+	}
+}
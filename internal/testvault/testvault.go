@@ -0,0 +1,51 @@
+// Package testvault gives the tests of other packages throwaway vaults, created with
+// enpass.CreateVault and filled through the write API, so none of them depends on or
+// writes to the fixture in test/.
+package testvault
+
+import (
+	"testing"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Password : master password of the test vaults
+	Password = "absolutely-No-clue"
+	// EntryTitle, EntryPassword : title and password of the login entry Open adds
+	EntryTitle    = "Whatever"
+	EntryPassword = "noIdeaata11"
+)
+
+// Create : create an empty vault in a temporary directory removed after the test, and return it
+func Create(t testing.TB) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := enpass.CreateVault(dir, &enpass.VaultCredentials{Password: Password}, "TestVault"); err != nil {
+		t.Fatalf("CreateVault failed: %v", err)
+	}
+	return dir
+}
+
+// Open : open a new writable vault holding one login entry, EntryTitle, closed after the
+// test. It returns the vault and the UUID of the entry.
+func Open(t testing.TB) (*enpass.Vault, string) {
+	t.Helper()
+	vault, err := enpass.NewVault(Create(t), logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("NewVault failed: %v", err)
+	}
+	if err := vault.Open(&enpass.VaultCredentials{Password: Password}); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(vault.Close)
+
+	entryUUID, err := vault.CreateEntry(&enpass.EntryData{
+		Title: EntryTitle, Username: "anyone", Password: EntryPassword, Category: "login",
+	})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	return vault, entryUUID
+}
@@ -0,0 +1,227 @@
+// Package api serves a vault over a local HTTP JSON API, described by the OpenAPI document
+// at /v1/openapi.json. Clients authenticate with bearer tokens, whose scopes limit what they
+// may do, and every request is recorded in the hash-chained audit log.
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hazcod/enpass-cli/pkg/audit"
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Scope : what a token may do
+type Scope string
+
+const (
+	// ScopeRead : list entries and read the fields that aren't secret
+	ScopeRead Scope = "read"
+	// ScopeReadSecrets : read passwords, other sensitive fields, notes and TOTP codes
+	ScopeReadSecrets Scope = "read-secrets"
+	// ScopeWrite : create, update and trash entries
+	ScopeWrite Scope = "write"
+
+	// maxBodySize : requests bodies are single entries
+	maxBodySize = 1024 * 1024
+
+	entriesPath = "/v1/entries"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// Token : a client of the API. Only the SHA-256 hash of the token is kept.
+type Token struct {
+	Name   string  `toml:"name"`
+	SHA256 string  `toml:"sha256"`
+	Scopes []Scope `toml:"scopes"`
+}
+
+// Allows : whether the token has the scope
+func (t *Token) Allows(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// NewToken : a random token and the hash to configure for it
+func NewToken() (token string, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", errors.Wrap(err, "could not generate token")
+	}
+	token = hex.EncodeToString(secret)
+	return token, HashToken(token), nil
+}
+
+// HashToken : the hex SHA-256 hash of a token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidScope : whether s names a scope
+func ValidScope(s Scope) bool {
+	return s == ScopeRead || s == ScopeReadSecrets || s == ScopeWrite
+}
+
+// Server : the API of one vault
+type Server struct {
+	logger *logrus.Logger
+	vault  *enpass.Vault
+	tokens []Token
+	mux    *http.ServeMux
+
+	audit *audit.Log
+
+	// now : the time TOTP codes are computed for
+	now func() time.Time
+}
+
+// NewServer : create the API of the vault for the tokens. Every request is appended to log.
+func NewServer(logger *logrus.Logger, vault *enpass.Vault, tokens []Token, log *audit.Log) *Server {
+	s := &Server{logger: logger, vault: vault, tokens: tokens, audit: log, now: time.Now}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /v1/openapi.json", s.openAPI)
+	s.mux.Handle("GET "+entriesPath, s.authorize(ScopeRead, s.listEntries))
+	s.mux.Handle("POST "+entriesPath, s.authorize(ScopeWrite, s.createEntry))
+	s.mux.Handle("GET "+entriesPath+"/{uuid}", s.authorize(ScopeRead, s.getEntry))
+	s.mux.Handle("PATCH "+entriesPath+"/{uuid}", s.authorize(ScopeWrite, s.updateEntry))
+	s.mux.Handle("DELETE "+entriesPath+"/{uuid}", s.authorize(ScopeWrite, s.trashEntry))
+	s.mux.Handle("GET "+entriesPath+"/{uuid}/totp", s.authorize(ScopeReadSecrets, s.totp))
+	return s
+}
+
+// ServeHTTP : answer a request and record it in the audit log
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := s.authenticate(r)
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	s.mux.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), tokenKey{}, token)))
+
+	record := audit.Record{
+		Command: "serve",
+		Vault:   s.vault.Info().VaultName,
+		Request: &audit.Request{
+			Remote: r.RemoteAddr,
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Status: recorder.status,
+		},
+	}
+	if token != nil {
+		record.Request.Token = token.Name
+	}
+	// the pattern is only matched on the request the mux passed on, so take the UUID from
+	// the path, or from the location of a created entry
+	if rest, found := strings.CutPrefix(r.URL.Path, entriesPath+"/"); found {
+		record.UUID, _, _ = strings.Cut(rest, "/")
+	} else if location := recorder.Header().Get("Location"); location != "" {
+		record.UUID = strings.TrimPrefix(location, entriesPath+"/")
+	}
+	s.record(record)
+}
+
+// record : append a record to the audit log. Requests are answered even when it fails,
+// which is logged.
+func (s *Server) record(record audit.Record) {
+	if _, err := s.audit.Append(record); err != nil {
+		s.logger.WithError(err).Error("could not write audit log")
+	}
+}
+
+// authenticate : the token of the Authorization header, nil when there is none or it is unknown
+func (s *Server) authenticate(r *http.Request) *Token {
+	presented, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || presented == "" {
+		return nil
+	}
+	hash := []byte(HashToken(strings.TrimSpace(presented)))
+	for i := range s.tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(strings.ToLower(s.tokens[i].SHA256))) == 1 {
+			return &s.tokens[i]
+		}
+	}
+	return nil
+}
+
+// tokenKey : the context key of the authenticated token
+type tokenKey struct{}
+
+// authorize : only call next for tokens with the scope
+func (s *Server) authorize(scope Scope, next func(http.ResponseWriter, *http.Request, *Token)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := r.Context().Value(tokenKey{}).(*Token)
+		if token == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="enpass-cli"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or unknown bearer token"))
+			return
+		}
+		if !token.Allows(scope) {
+			writeError(w, http.StatusForbidden, errors.New("token lacks the "+string(scope)+" scope"))
+			return
+		}
+		next(w, r, token)
+	})
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPI)
+}
+
+// writeJSON : answer with v as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// ErrorResponse : the body of failed requests
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+// vaultError : answer with the status matching an error of the vault
+func vaultError(w http.ResponseWriter, err error) {
+	switch {
+	case enpass.IsNotFound(err), errors.Is(err, enpass.ErrDeleted):
+		writeError(w, http.StatusNotFound, enpass.ErrNotFound)
+	case errors.Is(err, enpass.ErrConflict):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, enpass.ErrBusy):
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// statusRecorder : remembers the status of the response for the audit log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hazcod/enpass-cli/internal/testvault"
+	"github.com/hazcod/enpass-cli/pkg/audit"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	readToken    = "read-token"
+	secretsToken = "secrets-token"
+	writeToken   = "write-token"
+)
+
+// newTestServer serves a new test vault to a token per scope. It returns the
+// UUID of the entry of the vault along with the server.
+func newTestServer(t *testing.T) (*httptest.Server, *audit.Log, string) {
+	t.Helper()
+	vault, entryUUID := testvault.Open(t)
+
+	tokens := []Token{
+		{Name: "dashboard", SHA256: HashToken(readToken), Scopes: []Scope{ScopeRead}},
+		{Name: "deploy", SHA256: HashToken(secretsToken), Scopes: []Scope{ScopeRead, ScopeReadSecrets}},
+		{Name: "provisioner", SHA256: strings.ToUpper(HashToken(writeToken)), Scopes: []Scope{ScopeRead, ScopeWrite}},
+	}
+	log := audit.New(filepath.Join(t.TempDir(), "audit.log"))
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	server := httptest.NewServer(NewServer(logger, vault, tokens, log))
+	t.Cleanup(server.Close)
+	return server, log, entryUUID
+}
+
+// do sends a request with the token and decodes the JSON response into v when it is set
+func do(t *testing.T, server *httptest.Server, token string, method string, path string, body string, v interface{}) *http.Response {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, server.URL+path, reader)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("could not decode %s %s: %v", method, path, err)
+		}
+	}
+	return resp
+}
+
+func TestServer_Auth(t *testing.T) {
+	server, _, entryUUID := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"no token", "", http.MethodGet, "/v1/entries", "", http.StatusUnauthorized},
+		{"unknown token", "nope", http.MethodGet, "/v1/entries", "", http.StatusUnauthorized},
+		{"read", readToken, http.MethodGet, "/v1/entries", "", http.StatusOK},
+		{"read totp", readToken, http.MethodGet, "/v1/entries/" + entryUUID + "/totp", "", http.StatusForbidden},
+		{"read create", readToken, http.MethodPost, "/v1/entries", `{"title":"x"}`, http.StatusForbidden},
+		{"secrets trash", secretsToken, http.MethodDelete, "/v1/entries/" + entryUUID, "", http.StatusForbidden},
+		{"openapi without token", "", http.MethodGet, "/v1/openapi.json", "", http.StatusOK},
+		{"unknown path", readToken, http.MethodGet, "/v1/nothing", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(t, server, tt.token, tt.method, tt.path, tt.body, nil)
+			if resp.StatusCode != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Read(t *testing.T) {
+	server, log, entryUUID := newTestServer(t)
+
+	var entries []Entry
+	do(t, server, readToken, http.MethodGet, "/v1/entries?q="+testvault.EntryTitle, "", &entries)
+	if len(entries) != 1 || entries[0].UUID != entryUUID {
+		t.Fatalf("expected the Whatever entry, got %+v", entries)
+	}
+
+	password := func(detail EntryDetail) Field {
+		for _, field := range detail.Fields {
+			if field.Type == "password" {
+				return field
+			}
+		}
+		t.Fatalf("entry has no password field: %+v", detail)
+		return Field{}
+	}
+
+	var redacted EntryDetail
+	do(t, server, readToken, http.MethodGet, "/v1/entries/"+entryUUID, "", &redacted)
+	if field := password(redacted); field.Value != "" || !field.Redacted {
+		t.Errorf("expected the password to be redacted for the read scope, got %+v", field)
+	}
+
+	var detail EntryDetail
+	do(t, server, secretsToken, http.MethodGet, "/v1/entries/"+entryUUID, "", &detail)
+	if field := password(detail); field.Value != testvault.EntryPassword || field.Redacted {
+		t.Errorf("expected the password for the read-secrets scope, got %+v", field)
+	}
+
+	var missing ErrorResponse
+	if resp := do(t, server, readToken, http.MethodGet, "/v1/entries/00000000-0000-0000-0000-000000000000", "", &missing); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown entry, got %d", resp.StatusCode)
+	}
+
+	if _, err := log.Verify(); err != nil {
+		t.Fatalf("audit log does not verify: %v", err)
+	}
+	records, err := log.Records()
+	if err != nil {
+		t.Fatalf("could not read audit log: %v", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("expected 4 audit records, got %d", len(records))
+	}
+	if r := records[2]; r.Command != "serve" || r.UUID != entryUUID || r.Request == nil ||
+		r.Request.Token != "deploy" || r.Request.Method != http.MethodGet || r.Request.Status != http.StatusOK {
+		t.Errorf("unexpected audit record %+v", r)
+	}
+}
+
+func TestServer_Write(t *testing.T) {
+	server, log, _ := newTestServer(t)
+
+	var created Created
+	resp := do(t, server, writeToken, http.MethodPost, "/v1/entries",
+		`{"title":"API entry","username":"bot","password":"s3cret","folders":["Automation"]}`, &created)
+	if resp.StatusCode != http.StatusCreated || created.UUID == "" {
+		t.Fatalf("create = %d %+v", resp.StatusCode, created)
+	}
+	if records, err := log.Records(); err != nil || len(records) != 1 || records[0].UUID != created.UUID {
+		t.Errorf("expected the created entry in the audit log, got %+v %v", records, err)
+	}
+
+	if resp := do(t, server, writeToken, http.MethodPost, "/v1/entries", `{"title":"x","unknown":1}`, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown property, got %d", resp.StatusCode)
+	}
+
+	if resp := do(t, server, writeToken, http.MethodPatch, "/v1/entries/"+created.UUID, `{"title":"Renamed"}`, nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("update = %d", resp.StatusCode)
+	}
+	var detail EntryDetail
+	do(t, server, writeToken, http.MethodGet, "/v1/entries/"+created.UUID, "", &detail)
+	if detail.Title != "Renamed" || len(detail.Folders) != 1 || detail.Folders[0] != "Automation" {
+		t.Errorf("unexpected entry after update %+v", detail)
+	}
+
+	if resp := do(t, server, writeToken, http.MethodDelete, "/v1/entries/"+created.UUID, "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("trash = %d", resp.StatusCode)
+	}
+	var entries []Entry
+	do(t, server, writeToken, http.MethodGet, "/v1/entries?q=Renamed", "", &entries)
+	if len(entries) != 0 {
+		t.Errorf("expected trashed entries to be hidden, got %+v", entries)
+	}
+	do(t, server, writeToken, http.MethodGet, "/v1/entries?q=Renamed&trashed=true", "", &entries)
+	if len(entries) != 1 || !entries[0].Trashed {
+		t.Errorf("expected the trashed entry with trashed=true, got %+v", entries)
+	}
+
+	if resp := do(t, server, writeToken, http.MethodDelete, "/v1/entries/00000000-0000-0000-0000-000000000000", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 trashing an unknown entry, got %d", resp.StatusCode)
+	}
+}
+
+func TestServer_OpenAPI(t *testing.T) {
+	server, _, _ := newTestServer(t)
+
+	var document struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	do(t, server, "", http.MethodGet, "/v1/openapi.json", "", &document)
+	if document.OpenAPI == "" {
+		t.Fatal("expected an OpenAPI document")
+	}
+	for _, path := range []string{"/v1/entries", "/v1/entries/{uuid}", "/v1/entries/{uuid}/totp"} {
+		if _, documented := document.Paths[path]; !documented {
+			t.Errorf("path %s is not documented", path)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+)
+
+// Entry : an entry in list responses, without fields
+type Entry struct {
+	UUID      string   `json:"uuid"`
+	Title     string   `json:"title"`
+	Subtitle  string   `json:"subtitle"`
+	Category  string   `json:"category"`
+	Folders   []string `json:"folders"`
+	Favorite  bool     `json:"favorite"`
+	Archived  bool     `json:"archived"`
+	Trashed   bool     `json:"trashed"`
+	LastUsed  int64    `json:"last_used"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+// Field : a field of an entry. Values of secret fields are only included for tokens with the
+// read-secrets scope, Redacted is set otherwise.
+type Field struct {
+	UID       int64  `json:"uid"`
+	Label     string `json:"label"`
+	Type      string `json:"type"`
+	Sensitive bool   `json:"sensitive"`
+	Value     string `json:"value,omitempty"`
+	Redacted  bool   `json:"redacted,omitempty"`
+}
+
+// EntryDetail : an entry with its fields and notes
+type EntryDetail struct {
+	Entry
+	Notes         string  `json:"notes,omitempty"`
+	NotesRedacted bool    `json:"notes_redacted,omitempty"`
+	Fields        []Field `json:"fields"`
+}
+
+// EntryInput : the body of create and update requests. Empty values leave an entry unchanged.
+type EntryInput struct {
+	Title    string   `json:"title"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	URL      string   `json:"url"`
+	Notes    string   `json:"notes"`
+	Category string   `json:"category"`
+	Folders  []string `json:"folders"`
+}
+
+// Created : the body of create responses
+type Created struct {
+	UUID string `json:"uuid"`
+}
+
+// TOTP : the body of totp responses
+type TOTP struct {
+	Code string `json:"code"`
+}
+
+func entryOf(card *enpass.Card) Entry {
+	folders := card.Folders
+	if folders == nil {
+		folders = []string{}
+	}
+	return Entry{
+		UUID:      card.UUID,
+		Title:     card.Title,
+		Subtitle:  card.Subtitle,
+		Category:  card.Category,
+		Folders:   folders,
+		Favorite:  card.IsFavorite(),
+		Archived:  card.IsArchived(),
+		Trashed:   card.IsTrashed(),
+		LastUsed:  card.LastUsed,
+		UpdatedAt: card.UpdatedAt,
+	}
+}
+
+// secret : whether the value of the field needs the read-secrets scope
+func secret(field *enpass.Card) bool {
+	return field.Sensitive || field.Type == "password" || strings.EqualFold(field.Type, "totp")
+}
+
+// listEntries : the entries matching the q parameters, combined like the filters of the
+// command line. Trashed entries are only included with trashed=true.
+func (s *Server) listEntries(w http.ResponseWriter, r *http.Request, _ *Token) {
+	query := r.URL.Query()
+	filters := query["q"]
+	if filters == nil {
+		filters = []string{}
+	}
+	cards, err := s.vault.GetEntries(query.Get("type"), filters)
+	if err != nil {
+		vaultError(w, err)
+		return
+	}
+
+	trashed := query.Get("trashed") == "true"
+	entries := make([]Entry, 0, len(cards))
+	for i := range cards {
+		if cards[i].IsDeleted() || (cards[i].IsTrashed() && !trashed) {
+			continue
+		}
+		entries = append(entries, entryOf(&cards[i]))
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// getEntry : an entry with its fields, secrets redacted unless the token may read them
+func (s *Server) getEntry(w http.ResponseWriter, r *http.Request, token *Token) {
+	fields, err := s.vault.GetEntryFields(r.PathValue("uuid"))
+	if err != nil {
+		vaultError(w, err)
+		return
+	}
+	if fields[0].IsDeleted() {
+		writeError(w, http.StatusNotFound, enpass.ErrNotFound)
+		return
+	}
+
+	secrets := token.Allows(ScopeReadSecrets)
+	detail := EntryDetail{Entry: entryOf(&fields[0]), Fields: make([]Field, 0, len(fields))}
+	if secrets {
+		detail.Notes = fields[0].Note
+	} else {
+		detail.NotesRedacted = fields[0].Note != ""
+	}
+	for i := range fields {
+		field := Field{UID: fields[i].FieldUID, Label: fields[i].Label, Type: fields[i].Type, Sensitive: fields[i].Sensitive}
+		if secret(&fields[i]) && !secrets {
+			field.Redacted = fields[i].RawValue != ""
+		} else if field.Value, err = fields[i].Decrypt(); err != nil {
+			writeError(w, http.StatusInternalServerError, errors.Wrap(err, "could not decrypt field "+fields[i].Label))
+			return
+		}
+		detail.Fields = append(detail.Fields, field)
+	}
+	if secrets {
+		s.recordUsage(&fields[0])
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// totp : the current code of the first TOTP field of the entry
+func (s *Server) totp(w http.ResponseWriter, r *http.Request, _ *Token) {
+	fields, err := s.vault.GetEntryFields(r.PathValue("uuid"))
+	if err != nil {
+		vaultError(w, err)
+		return
+	}
+	for i := range fields {
+		if !strings.EqualFold(fields[i].Type, "totp") || fields[i].RawValue == "" {
+			continue
+		}
+		secret, err := fields[i].Decrypt()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errors.Wrap(err, "could not decrypt TOTP secret"))
+			return
+		}
+		code, err := enpass.ComputeTOTP(secret, s.now())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.recordUsage(&fields[0])
+		writeJSON(w, http.StatusOK, TOTP{Code: code})
+		return
+	}
+	writeError(w, http.StatusNotFound, errors.New("entry has no TOTP field"))
+}
+
+// recordUsage : record the use of an entry whose secrets were read, when the vault is writable
+func (s *Server) recordUsage(card *enpass.Card) {
+	if s.vault.IsReadOnly() {
+		return
+	}
+	if err := s.vault.RecordUsage(card.UUID); err != nil {
+		s.logger.WithError(err).WithField("uuid", card.UUID).Debug("could not record usage")
+	}
+}
+
+// readInput : decode the entry of a create or update request
+func readInput(w http.ResponseWriter, r *http.Request) (*enpass.EntryData, bool) {
+	var input EntryInput
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid entry"))
+		return nil, false
+	}
+	return &enpass.EntryData{
+		Title:    input.Title,
+		Username: input.Username,
+		Password: input.Password,
+		URL:      input.URL,
+		Notes:    input.Notes,
+		Category: input.Category,
+		Folders:  input.Folders,
+	}, true
+}
+
+func (s *Server) createEntry(w http.ResponseWriter, r *http.Request, _ *Token) {
+	entry, ok := readInput(w, r)
+	if !ok {
+		return
+	}
+	if entry.Title == "" {
+		writeError(w, http.StatusBadRequest, errors.New("title is required"))
+		return
+	}
+	entryUUID, err := s.vault.CreateEntry(entry)
+	if err != nil {
+		vaultError(w, err)
+		return
+	}
+	w.Header().Set("Location", entriesPath+"/"+entryUUID)
+	writeJSON(w, http.StatusCreated, Created{UUID: entryUUID})
+}
+
+func (s *Server) updateEntry(w http.ResponseWriter, r *http.Request, _ *Token) {
+	entry, ok := readInput(w, r)
+	if !ok {
+		return
+	}
+	entryUUID := r.PathValue("uuid")
+	if _, err := s.vault.GetEntryFields(entryUUID); err != nil {
+		vaultError(w, err)
+		return
+	}
+	if err := s.vault.UpdateEntry(entryUUID, entry); err != nil {
+		vaultError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) trashEntry(w http.ResponseWriter, r *http.Request, _ *Token) {
+	entryUUID := r.PathValue("uuid")
+	if _, err := s.vault.GetEntryFields(entryUUID); err != nil {
+		vaultError(w, err)
+		return
+	}
+	if err := s.vault.TrashEntry(entryUUID); err != nil {
+		vaultError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,291 @@
+// Package audit keeps an append-only log of secrets revealed and entries changed. Every record
+// is a JSON object on its own line holding the hash of the record before it, so changing,
+// inserting or removing a record breaks the chain and is found by Verify. Records can be
+// shipped as they are to a SIEM; shipping them also guards against the tail of the log being
+// cut off, which the chain alone can't reveal.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// FieldAll : the field of records of commands revealing every field of an entry
+	FieldAll = "*"
+
+	// records are small, the last one is always within this many bytes of the end
+	tailSize = 64 * 1024
+
+	lockSuffix  = ".lock"
+	lockTimeout = 5 * time.Second
+	// locks older than this were left behind by a process that died while appending
+	lockStale = time.Minute
+)
+
+// Record : one secret revealed or entry changed
+type Record struct {
+	// Seq : the number of the record, starting at 1
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	// User : the account that ran the command
+	User    string `json:"user"`
+	Host    string `json:"host,omitempty"`
+	Command string `json:"command"`
+	// Vault : name of the vault
+	Vault string `json:"vault,omitempty"`
+	UUID  string `json:"uuid,omitempty"`
+	Title string `json:"title,omitempty"`
+	// Field : label or type of the field revealed or changed, FieldAll for every field
+	Field string `json:"field,omitempty"`
+	// Request : the API request, for records of the serve command
+	Request *Request `json:"request,omitempty"`
+	// Prev : the hash of the record before, empty for the first record
+	Prev string `json:"prev"`
+	// Hash : the SHA-256 hash of the record with an empty Hash
+	Hash string `json:"hash"`
+}
+
+// Request : an API request
+type Request struct {
+	Token  string `json:"token,omitempty"`
+	Remote string `json:"remote,omitempty"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+// TamperError : the log was modified at a line
+type TamperError struct {
+	Line   int
+	Reason string
+}
+
+func (e *TamperError) Error() string {
+	return "audit log was tampered with at line " + strconv.Itoa(e.Line) + ": " + e.Reason
+}
+
+// Log : an audit log file
+type Log struct {
+	path string
+	user string
+	host string
+	now  func() time.Time
+}
+
+// New : the log at path, created on the first Append
+func New(path string) *Log {
+	l := &Log{path: path, now: time.Now}
+	if u, err := user.Current(); err == nil {
+		l.user = u.Username
+	} else {
+		l.user = os.Getenv("USER")
+	}
+	l.host, _ = os.Hostname()
+	return l
+}
+
+// Path : the file of the log
+func (l *Log) Path() string {
+	return l.path
+}
+
+// hash : the hash of the record, which must have an empty Hash
+func hash(record *Record) (string, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return "", errors.Wrap(err, "could not marshal record")
+	}
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Append : add a record, filling in its sequence number, time, user, host and hashes. It
+// returns once the record is on disk.
+func (l *Log) Append(record Record) (Record, error) {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return record, errors.Wrap(err, "could not create audit log directory")
+	}
+	unlock, err := l.lock()
+	if err != nil {
+		return record, err
+	}
+	defer unlock()
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return record, errors.Wrap(err, "could not open audit log")
+	}
+	defer file.Close()
+
+	last, err := lastRecord(file)
+	if err != nil {
+		return record, err
+	}
+
+	record.Seq, record.Prev = 1, ""
+	if last != nil {
+		record.Seq, record.Prev = last.Seq+1, last.Hash
+	}
+	record.Time = l.now().UTC()
+	if record.User == "" {
+		record.User = l.user
+	}
+	if record.Host == "" {
+		record.Host = l.host
+	}
+	record.Hash = ""
+	if record.Hash, err = hash(&record); err != nil {
+		return record, err
+	}
+
+	line, err := json.Marshal(&record)
+	if err != nil {
+		return record, errors.Wrap(err, "could not marshal record")
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return record, errors.Wrap(err, "could not write audit log")
+	}
+	return record, errors.Wrap(file.Sync(), "could not write audit log")
+}
+
+// lastRecord : the last record of the log, nil when it is empty
+func lastRecord(file *os.File) (*Record, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read audit log")
+	}
+	if info.Size() == 0 {
+		return nil, nil
+	}
+
+	offset := info.Size() - tailSize
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "could not read audit log")
+	}
+	tail = bytes.TrimRight(tail, "\n")
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+
+	var last Record
+	if err := json.Unmarshal(tail, &last); err != nil || last.Hash == "" {
+		return nil, errors.New("last record of the audit log is damaged, check it with 'audit-log verify'")
+	}
+	return &last, nil
+}
+
+// lock : hold the lock file of the log, so records of concurrent commands don't share a
+// sequence number
+func (l *Log) lock() (func(), error) {
+	lockPath := l.path + lockSuffix
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { _ = os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrap(err, "could not lock audit log")
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > lockStale {
+			_ = os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("audit log is locked by another process, remove " + lockPath + " if none is running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Records : read the records of the log, in order, without verifying them
+func (l *Log) Records() ([]Record, error) {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return []Record{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not open audit log")
+	}
+	defer file.Close()
+
+	records := make([]Record, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, &TamperError{Line: line, Reason: "not a record"}
+		}
+		records = append(records, record)
+	}
+	return records, errors.Wrap(scanner.Err(), "could not read audit log")
+}
+
+// Verify : check the hash chain of the log and return the last record, nil when it is
+// empty. A *TamperError tells where the log was modified.
+func (l *Log) Verify() (*Record, error) {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not open audit log")
+	}
+	defer file.Close()
+	return Verify(file)
+}
+
+// Verify : check the hash chain of the records read from r and return the last one
+func Verify(r io.Reader) (*Record, error) {
+	var last *Record
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		raw := scanner.Bytes()
+		var record Record
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return last, &TamperError{Line: line, Reason: "not a record"}
+		}
+
+		// the line must be exactly what Append wrote, so no value can be added or changed
+		// without changing the hash
+		recorded := record.Hash
+		record.Hash = ""
+		sum, err := hash(&record)
+		if err != nil {
+			return last, err
+		}
+		record.Hash = recorded
+		canonical, err := json.Marshal(&record)
+		if err != nil {
+			return last, errors.Wrap(err, "could not marshal record")
+		}
+
+		switch {
+		case !bytes.Equal(canonical, raw):
+			return last, &TamperError{Line: line, Reason: "record was edited"}
+		case sum != recorded:
+			return last, &TamperError{Line: line, Reason: "hash does not match the record"}
+		case last == nil && (record.Seq != 1 || record.Prev != ""):
+			return last, &TamperError{Line: line, Reason: "records before it were removed"}
+		case last != nil && (record.Seq != last.Seq+1 || record.Prev != last.Hash):
+			return last, &TamperError{Line: line, Reason: "chain is broken, records were removed, inserted or reordered"}
+		}
+		last = &record
+	}
+	return last, errors.Wrap(scanner.Err(), "could not read audit log")
+}
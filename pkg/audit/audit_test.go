@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestLog : a log in a temporary directory with a fixed clock and user
+func newTestLog(t *testing.T) *Log {
+	t.Helper()
+	l := New(filepath.Join(t.TempDir(), "enpass-cli", "audit.log"))
+	l.user, l.host = "alice", "workstation"
+	l.now = func() time.Time { return time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC) }
+	return l
+}
+
+// appendRecords : append a record for each command
+func appendRecords(t *testing.T, l *Log, commands ...string) {
+	t.Helper()
+	for _, command := range commands {
+		if _, err := l.Append(Record{Command: command, UUID: "489e13cc", Title: "Whatever", Field: "password"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+}
+
+func TestLog_Append(t *testing.T) {
+	l := newTestLog(t)
+	if last, err := l.Verify(); err != nil || last != nil {
+		t.Fatalf("expected a missing log to verify empty, got %+v %v", last, err)
+	}
+	appendRecords(t, l, "pass", "copy", "edit")
+
+	info, err := os.Stat(l.Path())
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected mode 0600, got %o", perm)
+	}
+
+	records, err := l.Records()
+	if err != nil {
+		t.Fatalf("Records failed: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	for i, record := range records {
+		if record.Seq != int64(i+1) || record.User != "alice" || record.Host != "workstation" || record.Hash == "" {
+			t.Errorf("unexpected record %+v", record)
+		}
+		if i > 0 && record.Prev != records[i-1].Hash {
+			t.Errorf("record %d does not chain to the one before", record.Seq)
+		}
+	}
+	if records[0].Prev != "" {
+		t.Errorf("expected the first record to have no previous hash, got %q", records[0].Prev)
+	}
+
+	last, err := l.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if last == nil || last.Seq != 3 || last.Command != "edit" {
+		t.Errorf("expected the last record, got %+v", last)
+	}
+}
+
+func TestLog_AppendConcurrent(t *testing.T) {
+	l := newTestLog(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			appendRecords(t, l, "pass")
+		}()
+	}
+	wg.Wait()
+
+	last, err := l.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if last.Seq != 10 {
+		t.Errorf("expected 10 records, got %d", last.Seq)
+	}
+}
+
+func TestVerify_Tampering(t *testing.T) {
+	l := newTestLog(t)
+	appendRecords(t, l, "pass", "copy", "show", "delete")
+	data, err := os.ReadFile(l.Path())
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		line   int
+	}{
+		{"edited value", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"user":"alice"`, `"user":"bob"`, 1)
+			return lines
+		}, 2},
+		{"added value", func(lines []string) []string {
+			lines[2] = strings.Replace(lines[2], `{"seq"`, `{"note":"x","seq"`, 1)
+			return lines
+		}, 3},
+		{"removed record", func(lines []string) []string {
+			return append(lines[:1:1], lines[2:]...)
+		}, 2},
+		{"removed first record", func(lines []string) []string {
+			return lines[1:]
+		}, 1},
+		{"reordered records", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, 2},
+		{"garbage", func(lines []string) []string {
+			return append(lines, "\ngarbage")
+		}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(append([]string(nil), lines...))
+			_, err := Verify(bytes.NewBufferString(strings.Join(tampered, "")))
+			var tamperErr *TamperError
+			if !errors.As(err, &tamperErr) {
+				t.Fatalf("expected a TamperError, got %v", err)
+			}
+			if tamperErr.Line != tt.line {
+				t.Errorf("expected tampering at line %d, got %d: %v", tt.line, tamperErr.Line, err)
+			}
+		})
+	}
+}
+
+func TestLog_AppendDamaged(t *testing.T) {
+	l := newTestLog(t)
+	appendRecords(t, l, "pass")
+	file, err := os.OpenFile(l.Path(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	_, _ = file.WriteString("{\"seq\":2,\"ti")
+	file.Close()
+
+	if _, err := l.Append(Record{Command: "pass"}); err == nil {
+		t.Error("expected appending after a damaged record to fail")
+	}
+}
@@ -0,0 +1,224 @@
+package decrypt
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/hazcod/enpass-cli/internal/testvault"
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+)
+
+const plaintext = "the secret message\n"
+
+// ageEncrypt encrypts the plaintext to the recipient, armored when asked
+func ageEncrypt(t *testing.T, recipient age.Recipient, armored bool) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	var dst io.WriteCloser = nopCloser{&out}
+	if armored {
+		dst = agearmor.NewWriter(&out)
+	}
+	w, err := age.Encrypt(dst, recipient)
+	if err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	if _, err := io.WriteString(w, plaintext); err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	return out.Bytes()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// pgpKey generates an armored OpenPGP private key, encrypted when passphrase is set
+func pgpKey(t *testing.T, passphrase string) (*openpgp.Entity, string) {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Test", "", "test@example.org", nil)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	var out bytes.Buffer
+	w, err := pgparmor.Encode(&out, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatalf("could not armor key: %v", err)
+	}
+	if passphrase != "" {
+		if err := entity.EncryptPrivateKeys([]byte(passphrase), nil); err != nil {
+			t.Fatalf("could not encrypt key: %v", err)
+		}
+	}
+	if err := entity.SerializePrivateWithoutSigning(w, nil); err != nil {
+		t.Fatalf("could not serialize key: %v", err)
+	}
+	w.Close()
+	return entity, out.String()
+}
+
+// pgpEncrypt encrypts the plaintext to the entity, or with the passphrase when entity is nil
+func pgpEncrypt(t *testing.T, entity *openpgp.Entity, passphrase string, armored bool) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	var dst io.WriteCloser = nopCloser{&out}
+	if armored {
+		var err error
+		if dst, err = pgparmor.Encode(&out, "PGP MESSAGE", nil); err != nil {
+			t.Fatalf("could not armor: %v", err)
+		}
+	}
+	var w io.WriteCloser
+	var err error
+	if entity != nil {
+		w, err = openpgp.Encrypt(dst, []*openpgp.Entity{entity}, nil, nil, nil)
+	} else {
+		w, err = openpgp.SymmetricallyEncrypt(dst, []byte(passphrase), nil, nil)
+	}
+	if err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	io.WriteString(w, plaintext)
+	w.Close()
+	dst.Close()
+	return out.Bytes()
+}
+
+func TestDecrypt(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("could not generate identity: %v", err)
+	}
+	other, _ := age.GenerateX25519Identity()
+	scrypt, err := age.NewScryptRecipient("correct horse")
+	if err != nil {
+		t.Fatalf("could not create recipient: %v", err)
+	}
+	scrypt.SetWorkFactor(10)
+	identityFile := "# created: today\n" + identity.String() + "\n"
+	plainEntity, plainPGPKey := pgpKey(t, "")
+	lockedEntity, lockedPGPKey := pgpKey(t, "battery staple")
+
+	tests := []struct {
+		name       string
+		message    []byte
+		key        Key
+		wantFormat Format
+		wantErr    bool
+	}{
+		{"age x25519", ageEncrypt(t, identity.Recipient(), false), Key{Material: identityFile}, FormatAge, false},
+		{"age armored", ageEncrypt(t, identity.Recipient(), true), Key{Material: identityFile}, FormatAge, false},
+		{"age wrong identity", ageEncrypt(t, other.Recipient(), false), Key{Material: identityFile}, FormatAge, true},
+		{"age passphrase", ageEncrypt(t, scrypt, false), Key{Material: "correct horse"}, FormatAge, false},
+		{"age sibling passphrase", ageEncrypt(t, scrypt, false), Key{Material: "nope", Passphrases: []string{"correct horse"}}, FormatAge, false},
+		{"openpgp key", pgpEncrypt(t, plainEntity, "", false), Key{Material: plainPGPKey}, FormatOpenPGP, false},
+		{"openpgp armored", pgpEncrypt(t, plainEntity, "", true), Key{Material: plainPGPKey}, FormatOpenPGP, false},
+		{"openpgp locked key", pgpEncrypt(t, lockedEntity, "", true), Key{Material: lockedPGPKey, Passphrases: []string{"wrong", "battery staple"}}, FormatOpenPGP, false},
+		{"openpgp locked key without passphrase", pgpEncrypt(t, lockedEntity, "", true), Key{Material: lockedPGPKey, Passphrases: []string{"wrong"}}, FormatOpenPGP, true},
+		{"openpgp symmetric", pgpEncrypt(t, nil, "hunter2", true), Key{Material: "hunter2"}, FormatOpenPGP, false},
+		{"openpgp symmetric wrong passphrase", pgpEncrypt(t, nil, "hunter2", false), Key{Material: "hunter3"}, FormatOpenPGP, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			format, err := Decrypt(bytes.NewReader(tt.message), &tt.key, &out)
+			if format != tt.wantFormat {
+				t.Errorf("Decrypt() format = %q, want %q", format, tt.wantFormat)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if out.Len() != 0 {
+					t.Errorf("Decrypt() wrote %q on error", out.String())
+				}
+				return
+			}
+			if out.String() != plaintext {
+				t.Errorf("Decrypt() = %q, want %q", out.String(), plaintext)
+			}
+		})
+	}
+}
+
+func TestDecrypt_EncryptedIdentity(t *testing.T) {
+	identity, _ := age.GenerateX25519Identity()
+	scrypt, _ := age.NewScryptRecipient("correct horse")
+	scrypt.SetWorkFactor(10)
+
+	var encrypted bytes.Buffer
+	armored := agearmor.NewWriter(&encrypted)
+	w, _ := age.Encrypt(armored, scrypt)
+	io.WriteString(w, identity.String()+"\n")
+	w.Close()
+	armored.Close()
+
+	key := Key{Material: encrypted.String(), Passphrases: []string{"wrong", "correct horse"}}
+	var out bytes.Buffer
+	if _, err := Decrypt(bytes.NewReader(ageEncrypt(t, identity.Recipient(), false)), &key, &out); err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if out.String() != plaintext {
+		t.Errorf("Decrypt() = %q, want %q", out.String(), plaintext)
+	}
+}
+
+// entryFields creates an entry and returns its fields
+func entryFields(t *testing.T, vault *enpass.Vault, entry *enpass.EntryData) []enpass.Card {
+	t.Helper()
+	entryUUID, err := vault.CreateEntry(entry)
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	fields, err := vault.GetEntryFields(entryUUID)
+	if err != nil {
+		t.Fatalf("GetEntryFields failed: %v", err)
+	}
+	return fields
+}
+
+func TestEntryKey(t *testing.T) {
+	vault, _ := testvault.Open(t)
+	identity, _ := age.GenerateX25519Identity()
+
+	fields := entryFields(t, vault, &enpass.EntryData{Title: "age", Username: "me", Password: "password", Notes: identity.String()})
+	key, err := EntryKey(fields, nil)
+	if err != nil {
+		t.Fatalf("EntryKey() error = %v", err)
+	}
+	if key.Material != identity.String() || strings.Join(key.Passphrases, ",") != "password" {
+		t.Errorf("EntryKey() = %+v, want the identity of the notes unlocked by the password", key)
+	}
+
+	// a referenced field is the key material, even when the notes hold a key
+	for i := range fields {
+		if fields[i].Type == "password" {
+			key, err = EntryKey(fields, &fields[i])
+		}
+	}
+	if err != nil || key.Material != "password" || len(key.Passphrases) != 0 {
+		t.Errorf("EntryKey() with field = %+v, %v", key, err)
+	}
+
+	fields = entryFields(t, vault, &enpass.EntryData{Title: "symmetric", Username: "me", Password: "hunter2"})
+	key, err = EntryKey(fields, nil)
+	if err != nil || key.Material != "hunter2" {
+		t.Errorf("EntryKey() without key = %+v, %v", key, err)
+	}
+
+	fields = entryFields(t, vault, &enpass.EntryData{Title: "nothing", Username: "me"})
+	if _, err := EntryKey(fields, nil); err == nil {
+		t.Error("EntryKey() expected an error for an entry without key")
+	}
+}
@@ -0,0 +1,67 @@
+package enpass
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Attachment : a file attached to an entry. Small attachments are kept in the attachment table,
+// encrypted with the key of their entry like password fields. Larger ones live in separate
+// <uuid>.enpassattach files next to the vault, which are not read.
+type Attachment struct {
+	UUID     string `json:"uuid"`
+	ItemUUID string `json:"item_uuid"`
+	Name     string `json:"name"`
+	Mime     string `json:"mime,omitempty"`
+	Size     int64  `json:"size"`
+
+	// encrypted content, empty when it is kept in a separate file
+	data []byte
+	// key of the entry the attachment belongs to
+	itemKey []byte
+}
+
+// GetAttachments : the attachments of the entry, or of every entry when entryUUID is empty.
+// Attachments of deleted entries, and deleted attachments, are left out.
+func (v *Vault) GetAttachments(entryUUID string) ([]Attachment, error) {
+	if v.db == nil || v.vaultInfo.VaultName == "" {
+		return nil, ErrNotInitialized
+	}
+
+	query := `
+		SELECT attachment.uuid, attachment.item_uuid, COALESCE(attachment.name, ''),
+		       COALESCE(attachment.mime, ''), COALESCE(attachment.size, 0), attachment.data, item.key
+		FROM attachment
+		INNER JOIN item ON item.uuid = attachment.item_uuid
+		WHERE COALESCE(attachment.deleted, 0) = 0 AND item.deleted = 0`
+	var args []interface{}
+	if entryUUID != "" {
+		query += " AND attachment.item_uuid = ?"
+		args = append(args, entryUUID)
+	}
+	rows, err := v.db.Query(query+" ORDER BY attachment.item_uuid, attachment.ID", args...)
+	if err != nil {
+		return nil, wrapBusy(err, "could not retrieve attachments from database")
+	}
+	defer rows.Close()
+
+	attachments := make([]Attachment, 0)
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.UUID, &a.ItemUUID, &a.Name, &a.Mime, &a.Size, &a.data, &a.itemKey); err != nil {
+			return nil, errors.Wrap(err, "could not read attachment from database")
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, errors.Wrap(rows.Err(), "error iterating database rows")
+}
+
+// Decrypt : the content of the attachment
+func (a *Attachment) Decrypt() ([]byte, error) {
+	if len(a.data) == 0 {
+		if a.Size == 0 {
+			return []byte{}, nil
+		}
+		return nil, errors.New("attachment " + a.Name + " is kept in " + a.UUID + ".enpassattach, which can't be read")
+	}
+	return decryptItemData(a.itemKey, a.data, a.ItemUUID)
+}
@@ -0,0 +1,71 @@
+package enpass
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+// addTestAttachment stores data as an attachment of the entry, sealed with the entry's key
+func addTestAttachment(t *testing.T, vault *Vault, entryUUID string, attachmentUUID string, name string, data []byte) {
+	t.Helper()
+	var itemKey []byte
+	if err := vault.db.QueryRow("SELECT key FROM item WHERE uuid = ?", entryUUID).Scan(&itemKey); err != nil {
+		t.Fatalf("could not read item key: %v", err)
+	}
+	block, err := aes.NewCipher(itemKey[:32])
+	if err != nil {
+		t.Fatalf("could not create cipher: %v", err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("could not create GCM: %v", err)
+	}
+	aad, _ := hex.DecodeString(strings.ReplaceAll(entryUUID, "-", ""))
+	var sealed []byte
+	if data != nil {
+		sealed = aesgcm.Seal(nil, itemKey[32:], data, aad)
+	}
+	if _, err := vault.db.Exec(
+		"INSERT INTO attachment (uuid, item_uuid, name, size, mime, deleted, data) VALUES (?, ?, ?, ?, 'text/plain', 0, ?)",
+		attachmentUUID, entryUUID, name, 2048, sealed,
+	); err != nil {
+		t.Fatalf("could not insert attachment: %v", err)
+	}
+}
+
+func TestVault_GetAttachments(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+
+	addTestAttachment(t, vault, testItemUUID, "2a6b1c1e-0f43-4b4e-9a57-3c9d1b8c2f10", "notes.txt", []byte("attached"))
+	addTestAttachment(t, vault, testItemUUID, "5d1f0e2a-7c3b-4e8d-b1a6-9f2c4d6e8a01", "large.bin", nil)
+
+	attachments, err := vault.GetAttachments(testItemUUID)
+	if err != nil {
+		t.Fatalf("GetAttachments failed: %v", err)
+	}
+	if len(attachments) != 2 || attachments[0].Name != "notes.txt" || attachments[0].ItemUUID != testItemUUID {
+		t.Fatalf("unexpected attachments %+v", attachments)
+	}
+	data, err := attachments[0].Decrypt()
+	if err != nil || string(data) != "attached" {
+		t.Errorf("expected the attachment content, got %q (%v)", data, err)
+	}
+	if _, err := attachments[1].Decrypt(); err == nil || !strings.Contains(err.Error(), ".enpassattach") {
+		t.Errorf("expected an attachment kept in a file to fail, got %v", err)
+	}
+
+	all, err := vault.GetAttachments("")
+	if err != nil || len(all) != 2 {
+		t.Errorf("expected the attachments of every entry, got %d (%v)", len(all), err)
+	}
+	if none, err := vault.GetAttachments("00000000-0000-0000-0000-000000000000"); err != nil || len(none) != 0 {
+		t.Errorf("expected no attachments of an unknown entry, got %d (%v)", len(none), err)
+	}
+}
@@ -0,0 +1,299 @@
+package enpass
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// metadata describing a single backup, stored next to the copied files
+	backupInfoFileName = "backup.json"
+	// backup IDs are the UTC creation time, so they sort chronologically
+	backupIDFormat = "20060102T150405Z"
+	// backups hold the whole vault, so keep them private
+	backupDirMode  = 0700
+	backupFileMode = 0600
+)
+
+// Backup : a snapshot of vault.enpassdb and vault.json taken at one point in time
+type Backup struct {
+	ID        string    `json:"id"`
+	VaultName string    `json:"vault_name"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// directory holding the backed up files
+	Path string `json:"-"`
+}
+
+// Backup : write an encrypted snapshot of the opened vault into a new directory below backupDir.
+// The database is copied through SQLCipher's sqlcipher_export() on the live connection, so the
+// snapshot is consistent even while the desktop app is using the vault. Reason is stored as a
+// free-form note, e.g. the command that triggered the backup.
+func (v *Vault) Backup(backupDir string, reason string) (*Backup, error) {
+	if v.db == nil || v.hexKey == "" {
+		return nil, ErrNotInitialized
+	}
+
+	backup, err := newBackupDir(backupDir, reason, v.vaultInfo.VaultName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := v.exportDatabase(filepath.Join(backup.Path, vaultFileName)); err != nil {
+		_ = os.RemoveAll(backup.Path)
+		return nil, errors.Wrap(err, "could not export database")
+	}
+
+	if err := copyFile(v.vaultInfoFilename, filepath.Join(backup.Path, vaultInfoFileName)); err != nil {
+		_ = os.RemoveAll(backup.Path)
+		return nil, errors.Wrap(err, "could not copy vault info")
+	}
+
+	if err := backup.writeInfo(); err != nil {
+		_ = os.RemoveAll(backup.Path)
+		return nil, err
+	}
+
+	v.logger.WithField("backup", backup.ID).Debug("created backup")
+	return backup, nil
+}
+
+// exportDatabase : copy every table of the opened database into a new SQLCipher file.
+// The new file is keyed with the same raw key and salt so the vault password keeps working on it.
+func (v *Vault) exportDatabase(path string) error {
+	salt, err := v.extractSalt()
+	if err != nil {
+		return errors.Wrap(err, "could not read database salt")
+	}
+
+	ctx := context.Background()
+	// ATTACH is scoped to a single connection, so pin one from the pool
+	conn, err := v.db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "could not get database connection")
+	}
+	defer func() { _ = conn.Close() }()
+
+	target := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	rawKey := "x'" + v.hexKey + hex.EncodeToString(salt) + "'"
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS backup KEY ?", target.String()+"?mode=rwc", rawKey); err != nil {
+		return errors.Wrap(err, "could not attach backup database")
+	}
+	defer func() { _, _ = conn.ExecContext(ctx, "DETACH DATABASE backup") }()
+
+	if _, err := conn.ExecContext(ctx, "SELECT sqlcipher_export('backup')"); err != nil {
+		return errors.Wrap(err, "could not copy database")
+	}
+
+	return os.Chmod(path, backupFileMode)
+}
+
+// ListBackups : return every backup found in backupDir, oldest first
+func ListBackups(backupDir string) ([]Backup, error) {
+	dirEntries, err := os.ReadDir(backupDir)
+	if os.IsNotExist(err) {
+		return []Backup{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not read backup directory")
+	}
+
+	backups := make([]Backup, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		backup, err := readBackupInfo(filepath.Join(backupDir, dirEntry.Name()))
+		if err != nil {
+			// not one of ours, or an incomplete backup
+			continue
+		}
+		backups = append(backups, *backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ID < backups[j].ID
+	})
+	return backups, nil
+}
+
+// PruneBackups : remove the oldest backups in backupDir so that at most keep remain
+func PruneBackups(backupDir string, keep int) error {
+	backups, err := ListBackups(backupDir)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(backups)-keep; i++ {
+		if err := os.RemoveAll(backups[i].Path); err != nil {
+			return errors.Wrap(err, "could not remove backup "+backups[i].ID)
+		}
+	}
+	return nil
+}
+
+// RestoreBackup : replace the files of the opened vault with the backup identified by id.
+// The vault is first saved with Backup under the reason "pre-restore <id>", so a restore can
+// itself be undone, and is closed before its files are replaced. A backup of another vault,
+// going by the UUID in vault.json, is refused unless force is set. So is a vault with a
+// journal SQLite left behind, as it would be replayed onto the restored database.
+func (v *Vault) RestoreBackup(backupDir string, id string, force bool) (*Backup, error) {
+	if v.db == nil || v.hexKey == "" {
+		return nil, ErrNotInitialized
+	}
+	if id == "" || filepath.Base(id) != id {
+		return nil, errors.New("invalid backup id: " + id)
+	}
+
+	backup, err := readBackupInfo(filepath.Join(backupDir, id))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find backup "+id)
+	}
+	backupVault, err := NewVault(backup.Path, v.logger.Level)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read backup "+id)
+	}
+	if backupUUID := backupVault.Info().VaultUUID; backupUUID != v.vaultInfo.VaultUUID && !force {
+		return nil, errors.Errorf("backup %s is of vault %s, not %s", id, backupUUID, v.vaultInfo.VaultUUID)
+	}
+	if err := checkHotJournal(v.databaseFilename); err != nil {
+		return nil, err
+	}
+
+	current, err := v.Backup(backupDir, "pre-restore "+id)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not save current vault before restoring")
+	}
+
+	v.Close()
+	if err := checkHotJournal(v.databaseFilename); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{vaultFileName, vaultInfoFileName} {
+		if err := replaceFile(filepath.Join(backup.Path, name), filepath.Join(filepath.Dir(v.databaseFilename), name)); err != nil {
+			return nil, errors.Wrap(err, "could not restore "+name)
+		}
+	}
+
+	v.logger.WithField("backup", id).WithField("previous", current.ID).Debug("restored backup")
+	return current, nil
+}
+
+// checkHotJournal : fail when a rollback journal or write-ahead log is next to the database.
+// SQLite replays those onto whatever file is at the database path when it is opened next.
+func checkHotJournal(databaseFilename string) error {
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if _, err := os.Stat(databaseFilename + suffix); err == nil {
+			return errors.New("vault has an unfinished write in " + filepath.Base(databaseFilename+suffix) +
+				", close the Enpass app and let it finish before restoring")
+		}
+	}
+	return nil
+}
+
+func newBackupDir(backupDir string, reason string, vaultName string) (*Backup, error) {
+	if err := os.MkdirAll(backupDir, backupDirMode); err != nil {
+		return nil, errors.Wrap(err, "could not create backup directory")
+	}
+
+	createdAt := time.Now().UTC()
+	id := createdAt.Format(backupIDFormat)
+	path := filepath.Join(backupDir, id)
+	// several backups can be taken within the same second
+	for i := 1; ; i++ {
+		err := os.Mkdir(path, backupDirMode)
+		if err == nil {
+			break
+		} else if !os.IsExist(err) {
+			return nil, errors.Wrap(err, "could not create backup")
+		}
+		id = createdAt.Format(backupIDFormat) + "-" + strconv.Itoa(i)
+		path = filepath.Join(backupDir, id)
+	}
+
+	return &Backup{
+		ID:        id,
+		VaultName: vaultName,
+		Reason:    reason,
+		CreatedAt: createdAt,
+		Path:      path,
+	}, nil
+}
+
+func (b *Backup) writeInfo() error {
+	info, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode backup info")
+	}
+	if err := os.WriteFile(filepath.Join(b.Path, backupInfoFileName), info, backupFileMode); err != nil {
+		return errors.Wrap(err, "could not write backup info")
+	}
+	return nil
+}
+
+func readBackupInfo(path string) (*Backup, error) {
+	info, err := os.ReadFile(filepath.Join(path, backupInfoFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var backup Backup
+	if err := json.Unmarshal(info, &backup); err != nil {
+		return nil, errors.Wrap(err, "could not parse backup info")
+	}
+	backup.Path = path
+
+	for _, name := range []string{vaultFileName, vaultInfoFileName} {
+		if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+			return nil, err
+		}
+	}
+	return &backup, nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, backupFileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// replaceFile : copy src next to dst and rename it into place, so dst is never half-written
+func replaceFile(src string, dst string) error {
+	mode := os.FileMode(backupFileMode)
+	if stat, err := os.Stat(dst); err == nil {
+		mode = stat.Mode().Perm()
+	}
+
+	tmp := dst + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
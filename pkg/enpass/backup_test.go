@@ -0,0 +1,147 @@
+package enpass
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestVault_BackupAndRestore(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	vault, err := NewVault(tmpDir, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("vault initialization failed: %v", err)
+	}
+	if err := vault.Open(&VaultCredentials{Password: testPassword}); err != nil {
+		t.Fatalf("opening vault failed: %v", err)
+	}
+
+	backup, err := vault.Backup(backupDir, "test")
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if _, err := vault.CreateEntry(&EntryData{Title: "After Backup", Password: "secret"}); err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	vault.Close()
+
+	// the snapshot must open with the vault password, i.e. keep the salt
+	snapshot, err := NewVault(backup.Path, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("backup initialization failed: %v", err)
+	}
+	if err := snapshot.OpenReadOnly(&VaultCredentials{Password: testPassword}); err != nil {
+		t.Fatalf("opening backup failed: %v", err)
+	}
+	Assert_GetEntries(t, snapshot, []string{"Whatever"}, 1)
+	Assert_GetEntries(t, snapshot, []string{"After Backup"}, 0)
+	snapshot.Close()
+
+	backups, err := ListBackups(backupDir)
+	if err != nil {
+		t.Fatalf("ListBackups failed: %v", err)
+	}
+	if len(backups) != 1 || backups[0].ID != backup.ID || backups[0].Reason != "test" {
+		t.Fatalf("unexpected backups: %+v", backups)
+	}
+
+	vault = openTestVault(t, tmpDir, true)
+	defer vault.Close()
+	if _, err := vault.RestoreBackup(backupDir, "../"+backup.ID, false); err == nil {
+		t.Error("RestoreBackup should reject ids outside the backup directory")
+	}
+
+	// a journal left next to the database would be replayed onto the restored one
+	journal := filepath.Join(tmpDir, vaultFileName+"-journal")
+	if err := os.WriteFile(journal, []byte("hot"), 0600); err != nil {
+		t.Fatalf("could not write journal: %v", err)
+	}
+	if _, err := vault.RestoreBackup(backupDir, backup.ID, false); err == nil {
+		t.Error("RestoreBackup should refuse a vault with a journal")
+	}
+	os.Remove(journal)
+
+	current, err := vault.RestoreBackup(backupDir, backup.ID, false)
+	if err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+	if current.ID == backup.ID || current.Reason != "pre-restore "+backup.ID {
+		t.Errorf("restore should save the current vault as a new backup, got %+v", current)
+	}
+
+	restored := openTestVault(t, tmpDir, true)
+	defer restored.Close()
+	Assert_GetEntries(t, restored, []string{"After Backup"}, 0)
+
+	// the pre-restore backup is a consistent export holding the entry created after the backup
+	previous := openTestVault(t, current.Path, true)
+	defer previous.Close()
+	Assert_GetEntries(t, previous, []string{"After Backup"}, 1)
+}
+
+func TestVault_RestoreBackup_OtherVault(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	otherDir := createTestVault(t, "Other")
+	defer os.RemoveAll(otherDir)
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	other := openTestVault(t, otherDir, true)
+	backup, err := other.Backup(backupDir, "test")
+	other.Close()
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	vault := openTestVault(t, tmpDir, true)
+	defer vault.Close()
+	if _, err := vault.RestoreBackup(backupDir, backup.ID, false); err == nil {
+		t.Fatal("RestoreBackup should refuse a backup of another vault")
+	}
+	if _, err := vault.RestoreBackup(backupDir, backup.ID, true); err != nil {
+		t.Fatalf("RestoreBackup with force failed: %v", err)
+	}
+	if name := vault.Info().VaultName; name != "DummyVault" {
+		t.Errorf("expected the vault info of the closed vault to be kept, got %s", name)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	vault, err := NewVault(tmpDir, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("vault initialization failed: %v", err)
+	}
+	defer vault.Close()
+	if err := vault.OpenReadOnly(&VaultCredentials{Password: testPassword}); err != nil {
+		t.Fatalf("opening vault failed: %v", err)
+	}
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		backup, err := vault.Backup(backupDir, "test")
+		if err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		ids = append(ids, backup.ID)
+	}
+
+	if err := PruneBackups(backupDir, 2); err != nil {
+		t.Fatalf("PruneBackups failed: %v", err)
+	}
+	backups, _ := ListBackups(backupDir)
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %d", len(backups))
+	}
+	if backups[0].ID != ids[1] || backups[1].ID != ids[2] {
+		t.Errorf("expected the newest backups to remain, got %s and %s", backups[0].ID, backups[1].ID)
+	}
+}
@@ -1,10 +1,7 @@
 package enpass
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"encoding/hex"
-	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -69,16 +66,31 @@ type Card struct {
 	Note      string
 	Trashed   int64
 	Deleted   int64
+	Favorite  int64
+	Archived  int64
 	Category  string
+	Template  string
 	Label     string
 	LastUsed  int64
 	Sensitive bool
 	Icon      string
 	RawValue  string
+	// item_field_uid of the field, unique within the item; -1 when unset
+	FieldUID int64
+	// number of times the item was used, e.g. its password copied
+	UsageCount int64
+	// name of the vault the card was read from
+	VaultName string
+	// titles of the folders the item is filed in
+	Folders []string
 
 	// encrypted
 	value   string
 	itemKey []byte
+
+	// modification timestamps of the item, used to detect concurrent writes
+	metaUpdatedAt int64
+	itemUpdatedAt int64
 }
 
 func (c *Card) IsTrashed() bool {
@@ -89,6 +101,14 @@ func (c *Card) IsDeleted() bool {
 	return c.Deleted != 0
 }
 
+func (c *Card) IsFavorite() bool {
+	return c.Favorite != 0
+}
+
+func (c *Card) IsArchived() bool {
+	return c.Archived != 0
+}
+
 func (c *Card) Decrypt() (string, error) {
 	// Intercept item fields without value
 	if len(c.value) == 0 {
@@ -100,15 +120,10 @@ func (c *Card) Decrypt() (string, error) {
 		return c.value, nil
 	}
 
-	// The key object is saved in binary from and actually consists of the
-	// AES key (32 bytes) and a nonce (12 bytes) for GCM
-	key := c.itemKey[:32]
-	nonce := c.itemKey[32:]
-
 	// If you deleted an item from Enpass, it stays in the database, but the
 	// entries are cleared
-	if len(nonce) == 0 {
-		return "", errors.New("this item has been deleted")
+	if len(c.itemKey) == 32 {
+		return "", ErrDeleted
 	}
 
 	// The value object holds the ciphertext (same length as plaintext) +
@@ -118,29 +133,9 @@ func (c *Card) Decrypt() (string, error) {
 		return "", errors.Wrap(err, "could not decode card hex cipherstring")
 	}
 
-	// As additional authenticated data (AAD) they use the UUID but without
-	// the dashes: e.g. a2ec30c0aeed41f7aed7cc50e69ff506
-	header, err := hex.DecodeString(strings.ReplaceAll(c.UUID, "-", ""))
-	if err != nil {
-		return "", errors.Wrap(err, "could not decode card hex AAD")
-	}
-
-	// Now we can initialize, decrypt the ciphertext and verify the AAD.
-	// You can compare the SHA-1 output with the value stored in the db
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return "", errors.Wrap(err, "could not initialize card cipher")
-	}
-
-	aesgcm, err := cipher.NewGCM(block)
+	plaintext, err := decryptItemData(c.itemKey, ciphertextAndTag, c.UUID)
 	if err != nil {
-		return "", errors.Wrap(err, "could not initialize GCM block")
+		return "", err
 	}
-
-	plaintext, err := aesgcm.Open(nil, nonce, ciphertextAndTag, header)
-	if err != nil {
-		return "", errors.Wrap(err, "could not decrypt data")
-	}
-
 	return string(plaintext), nil
 }
@@ -0,0 +1,229 @@
+package enpass
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// length of the key blob of an item: a 32 byte AES key and a 12 byte GCM nonce
+	itemKeyLength = 44
+)
+
+var (
+	// ErrDamaged : SQLite's integrity check found the database damaged, so Repair leaves it alone
+	ErrDamaged = errors.New("vault database is damaged, restore a backup instead of repairing it")
+)
+
+// FieldProblem : a field Check found a problem with
+type FieldProblem struct {
+	ItemUUID string `json:"item_uuid"`
+	// Title : the title of the entry, empty for orphaned fields
+	Title    string `json:"title,omitempty"`
+	FieldUID int64  `json:"field_uid"`
+	Label    string `json:"label,omitempty"`
+	Type     string `json:"type"`
+	Problem  string `json:"problem"`
+}
+
+// CheckReport : the problems Check found in the vault
+type CheckReport struct {
+	// Integrity : the problems SQLite's integrity_check found in the database
+	Integrity []string `json:"integrity,omitempty"`
+	// OrphanFields : fields whose entry doesn't exist
+	OrphanFields []FieldProblem `json:"orphan_fields,omitempty"`
+	// UndecryptableFields : encrypted fields the key of their entry can't decrypt
+	UndecryptableFields []FieldProblem `json:"undecryptable_fields,omitempty"`
+	// DuplicateUUIDs : UUIDs held by more than one entry
+	DuplicateUUIDs []string `json:"duplicate_uuids,omitempty"`
+	// ItemCount, InfoItemCount : the entries in the database, and the count in vault.json
+	ItemCount     int `json:"item_count"`
+	InfoItemCount int `json:"info_item_count"`
+}
+
+// CountMismatch : whether vault.json counts another number of entries than the database holds
+func (r *CheckReport) CountMismatch() bool {
+	return r.ItemCount != r.InfoItemCount
+}
+
+// OK : whether Check found no problems
+func (r *CheckReport) OK() bool {
+	return len(r.Integrity) == 0 && len(r.OrphanFields) == 0 && len(r.UndecryptableFields) == 0 &&
+		len(r.DuplicateUUIDs) == 0 && !r.CountMismatch()
+}
+
+// Repairable : whether Repair can fix every problem of the report
+func (r *CheckReport) Repairable() bool {
+	return len(r.Integrity) == 0 && len(r.UndecryptableFields) == 0 && len(r.DuplicateUUIDs) == 0
+}
+
+// Fixable : whether Repair has something to fix, which it never does in a damaged database
+func (r *CheckReport) Fixable() bool {
+	return len(r.Integrity) == 0 && (len(r.OrphanFields) > 0 || r.CountMismatch())
+}
+
+// Check : look for problems in the vault that keep entries from being read, or that the
+// Enpass apps may trip over. It only reads, see Repair for fixing the safe cases.
+func (v *Vault) Check() (*CheckReport, error) {
+	if v.db == nil || v.vaultInfo.VaultName == "" {
+		return nil, ErrNotInitialized
+	}
+
+	report := &CheckReport{InfoItemCount: v.vaultInfo.VaultNumItems}
+	var err error
+	if report.Integrity, err = v.IntegrityCheck(); err != nil {
+		return nil, err
+	}
+	if report.OrphanFields, err = v.orphanFields(); err != nil {
+		return nil, err
+	}
+	if report.UndecryptableFields, err = v.undecryptableFields(); err != nil {
+		return nil, err
+	}
+	if report.DuplicateUUIDs, err = v.duplicateUUIDs(); err != nil {
+		return nil, err
+	}
+	if err := v.db.QueryRow("SELECT COUNT(*) FROM item WHERE COALESCE(deleted, 0) = 0").Scan(&report.ItemCount); err != nil {
+		return nil, wrapBusy(err, "could not count entries")
+	}
+	return report, nil
+}
+
+// orphanFields : the fields whose entry doesn't exist
+func (v *Vault) orphanFields() ([]FieldProblem, error) {
+	rows, err := v.db.Query(`
+		SELECT COALESCE(itemfield.item_uuid, ''), COALESCE(` + v.column("itemfield.item_field_uid") + `, -1),
+		       COALESCE(itemfield.label, ''), COALESCE(itemfield.type, '')
+		FROM itemfield
+		LEFT JOIN item ON item.uuid = itemfield.item_uuid
+		WHERE item.uuid IS NULL
+	`)
+	if err != nil {
+		return nil, wrapBusy(err, "could not look for orphaned fields")
+	}
+	defer rows.Close()
+
+	var problems []FieldProblem
+	for rows.Next() {
+		problem := FieldProblem{Problem: "entry does not exist"}
+		if err := rows.Scan(&problem.ItemUUID, &problem.FieldUID, &problem.Label, &problem.Type); err != nil {
+			return nil, errors.Wrap(err, "could not read orphaned field")
+		}
+		problems = append(problems, problem)
+	}
+	return problems, errors.Wrap(rows.Err(), "error iterating database rows")
+}
+
+// undecryptableFields : the encrypted fields that fail to decrypt, with the reason
+func (v *Vault) undecryptableFields() ([]FieldProblem, error) {
+	cards, err := v.GetAllFields("password", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []FieldProblem
+	for _, card := range cards {
+		_, err := card.Decrypt()
+		if err == nil {
+			continue
+		}
+		problem := err.Error()
+		if len(card.itemKey) != itemKeyLength {
+			problem += fmt.Sprintf(" (item key is %d bytes, expected %d)", len(card.itemKey), itemKeyLength)
+		}
+		problems = append(problems, FieldProblem{
+			ItemUUID: card.UUID,
+			Title:    card.Title,
+			FieldUID: card.FieldUID,
+			Label:    card.Label,
+			Type:     card.Type,
+			Problem:  problem,
+		})
+	}
+	return problems, nil
+}
+
+// duplicateUUIDs : the UUIDs held by more than one entry
+func (v *Vault) duplicateUUIDs() ([]string, error) {
+	rows, err := v.db.Query("SELECT uuid FROM item GROUP BY uuid HAVING COUNT(*) > 1 ORDER BY uuid")
+	if err != nil {
+		return nil, wrapBusy(err, "could not look for duplicate entries")
+	}
+	defer rows.Close()
+
+	var duplicates []string
+	for rows.Next() {
+		var itemUUID string
+		if err := rows.Scan(&itemUUID); err != nil {
+			return nil, errors.Wrap(err, "could not read duplicate entry")
+		}
+		duplicates = append(duplicates, itemUUID)
+	}
+	return duplicates, errors.Wrap(rows.Err(), "error iterating database rows")
+}
+
+// Repair : fix the problems of a Check report that can be fixed without losing data:
+// orphaned fields are deleted and the entry count of vault.json is corrected. Other
+// problems are left alone, restore a backup for those. A database SQLite's integrity
+// check reports damaged isn't touched at all, Repair returns ErrDamaged instead.
+// Returns what was fixed.
+func (v *Vault) Repair(report *CheckReport) ([]string, error) {
+	if err := v.checkWritable(); err != nil {
+		return nil, err
+	}
+	if len(report.Integrity) > 0 {
+		return nil, ErrDamaged
+	}
+
+	var fixed []string
+	if len(report.OrphanFields) > 0 {
+		result, err := v.db.Exec("DELETE FROM itemfield WHERE item_uuid IS NULL OR item_uuid NOT IN (SELECT uuid FROM item)")
+		if err != nil {
+			return fixed, wrapBusy(err, "could not delete orphaned fields")
+		}
+		deleted, _ := result.RowsAffected()
+		fixed = append(fixed, fmt.Sprintf("deleted %d orphaned fields", deleted))
+	}
+
+	if report.CountMismatch() {
+		if err := v.setInfoItemCount(report.ItemCount); err != nil {
+			return fixed, err
+		}
+		fixed = append(fixed, fmt.Sprintf("set vault_items_count from %d to %d", report.InfoItemCount, report.ItemCount))
+	}
+
+	v.logger.WithField("fixed", fixed).Debug("repaired vault")
+	return fixed, nil
+}
+
+// setInfoItemCount : update vault_items_count in vault.json, keeping every other setting of it
+func (v *Vault) setInfoItemCount(count int) error {
+	raw, err := os.ReadFile(v.vaultInfoFilename)
+	if err != nil {
+		return errors.Wrap(err, "could not read vault info")
+	}
+	info := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return errors.Wrap(err, "could not parse vault info")
+	}
+	info["vault_items_count"] = json.RawMessage(fmt.Sprint(count))
+
+	updated, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode vault info")
+	}
+	// replace the file at once, the desktop app reads it while syncing
+	tmp := v.vaultInfoFilename + ".tmp"
+	if err := os.WriteFile(tmp, updated, 0600); err != nil {
+		return errors.Wrap(err, "could not write vault info")
+	}
+	if err := os.Rename(tmp, v.vaultInfoFilename); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrap(err, "could not replace vault info")
+	}
+	v.vaultInfo.VaultNumItems = count
+	return nil
+}
@@ -0,0 +1,100 @@
+package enpass
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestVault_Check(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+
+	report, err := vault.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() || report.ItemCount != 1 || report.InfoItemCount != 1 {
+		t.Fatalf("expected the test vault to be fine, got %+v", report)
+	}
+
+	brokenUUID, err := vault.CreateEntry(&EntryData{Title: "Broken", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	if _, err := vault.db.Exec("UPDATE item SET key = x'0102' WHERE uuid = ?", brokenUUID); err != nil {
+		t.Fatalf("could not break item key: %v", err)
+	}
+	if _, err := vault.db.Exec("INSERT INTO itemfield (item_uuid, item_field_uid, label, value, deleted, sensitive, type) VALUES ('gone', 1, 'Lost', 'x', 0, 0, 'text')"); err != nil {
+		t.Fatalf("could not insert orphaned field: %v", err)
+	}
+
+	report, err = vault.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(report.OrphanFields) != 1 || report.OrphanFields[0].ItemUUID != "gone" || report.OrphanFields[0].Label != "Lost" {
+		t.Errorf("expected the orphaned field, got %+v", report.OrphanFields)
+	}
+	if len(report.UndecryptableFields) != 1 || report.UndecryptableFields[0].ItemUUID != brokenUUID ||
+		!strings.Contains(report.UndecryptableFields[0].Problem, "item key is 2 bytes") {
+		t.Errorf("expected the field with the broken key, got %+v", report.UndecryptableFields)
+	}
+	if !report.CountMismatch() || report.ItemCount != 2 {
+		t.Errorf("expected the count of vault.json to be off, got %d and %d", report.ItemCount, report.InfoItemCount)
+	}
+	if report.OK() || report.Repairable() || !report.Fixable() {
+		t.Error("expected a broken key not to be repairable, and the rest to be fixable")
+	}
+
+	// nothing is deleted from a database SQLite reports damaged
+	damaged := *report
+	damaged.Integrity = []string{"row 3 missing from index"}
+	if damaged.Fixable() {
+		t.Error("expected a damaged database not to be fixable")
+	}
+	if _, err := vault.Repair(&damaged); !errors.Is(err, ErrDamaged) {
+		t.Errorf("expected ErrDamaged, got %v", err)
+	}
+	if unchanged, err := vault.Check(); err != nil || len(unchanged.OrphanFields) != 1 {
+		t.Errorf("expected the orphaned field to be kept, got %+v %v", unchanged, err)
+	}
+
+	fixed, err := vault.Repair(report)
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	if len(fixed) != 2 {
+		t.Errorf("expected 2 fixes, got %v", fixed)
+	}
+
+	report, err = vault.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(report.OrphanFields) != 0 || report.CountMismatch() || len(report.UndecryptableFields) != 1 {
+		t.Errorf("expected only the broken key to remain, got %+v", report)
+	}
+
+	// other settings of vault.json are kept
+	info, err := os.ReadFile(filepath.Join(tmpDir, vaultInfoFileName))
+	if err != nil {
+		t.Fatalf("could not read vault info: %v", err)
+	}
+	if !strings.Contains(string(info), `"creating_device": "BBB2"`) {
+		t.Errorf("expected vault.json to keep its settings, got %s", info)
+	}
+	reloaded, err := NewVault(tmpDir, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("NewVault failed: %v", err)
+	}
+	if count := reloaded.Info().VaultNumItems; count != 2 {
+		t.Errorf("expected the corrected count in vault.json, got %d", count)
+	}
+}
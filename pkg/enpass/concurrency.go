@@ -0,0 +1,127 @@
+package enpass
+
+import (
+	"database/sql"
+	"time"
+
+	sqlite3 "github.com/mutecomm/go-sqlcipher"
+	"github.com/pkg/errors"
+)
+
+const (
+	// how long SQLite waits for a lock held by another connection before giving up
+	defaultBusyTimeout = 5 * time.Second
+)
+
+var (
+	// ErrBusy : the database stayed locked by another connection for longer than the busy timeout
+	ErrBusy = errors.New("vault database is busy, try again later")
+	// ErrConflict : the entry was changed by someone else after it was read
+	ErrConflict = errors.New("entry was modified since it was read, reload it and try again")
+)
+
+// itemStamp : the modification timestamps of an item, used to detect concurrent changes
+type itemStamp struct {
+	metaUpdatedAt  int64
+	fieldUpdatedAt int64
+	updatedAt      int64
+}
+
+func (c *Card) stamp() itemStamp {
+	return itemStamp{
+		metaUpdatedAt:  c.metaUpdatedAt,
+		fieldUpdatedAt: c.UpdatedAt,
+		updatedAt:      c.itemUpdatedAt,
+	}
+}
+
+// rememberStamp : record the timestamps of a card as they were when it was read
+func (v *Vault) rememberStamp(card *Card) {
+	v.stampsMu.Lock()
+	defer v.stampsMu.Unlock()
+	if v.stamps == nil {
+		v.stamps = make(map[string]itemStamp)
+	}
+	v.stamps[card.UUID] = card.stamp()
+}
+
+// checkStamp : make sure an item wasn't modified since this vault last read it.
+// Items that were never read through this vault can't be checked and are accepted.
+func (v *Vault) checkStamp(tx *sql.Tx, entryUUID string) error {
+	v.stampsMu.Lock()
+	expected, seen := v.stamps[entryUUID]
+	v.stampsMu.Unlock()
+	if !seen {
+		return nil
+	}
+
+	current, err := v.queryStamp(tx, entryUUID)
+	if err == sql.ErrNoRows {
+		return errors.Wrap(ErrConflict, "entry was removed")
+	} else if err != nil {
+		return wrapBusy(err, "could not read entry timestamps")
+	}
+
+	if current != expected {
+		v.logger.WithField("uuid", entryUUID).Debug("entry changed since it was read")
+		return ErrConflict
+	}
+	return nil
+}
+
+// refreshStamp : record the timestamps of an item after this vault modified it
+func (v *Vault) refreshStamp(entryUUID string) {
+	current, err := v.queryStamp(v.db, entryUUID)
+
+	v.stampsMu.Lock()
+	defer v.stampsMu.Unlock()
+	if err != nil {
+		delete(v.stamps, entryUUID)
+		return
+	}
+	if v.stamps == nil {
+		v.stamps = make(map[string]itemStamp)
+	}
+	v.stamps[entryUUID] = current
+}
+
+// touchItem : bump the updated_at of an item so every write changes its stamp, even when
+// several writes happen within the same second. Schemas without updated_at are left as they are.
+func (v *Vault) touchItem(tx *sql.Tx, entryUUID string, now int64) error {
+	if !v.hasColumn("item.updated_at") {
+		return nil
+	}
+	_, err := tx.Exec("UPDATE item SET updated_at = MAX(COALESCE(updated_at, 0) + 1, ?) WHERE uuid = ?", now, entryUUID)
+	return wrapBusy(err, "could not update entry timestamp")
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (v *Vault) queryStamp(q queryRower, entryUUID string) (itemStamp, error) {
+	var stamp itemStamp
+	err := q.QueryRow(`
+		SELECT COALESCE(`+v.column("item.meta_updated_at")+`, 0), COALESCE(field_updated_at, 0),
+		       COALESCE(`+v.column("item.updated_at")+`, 0)
+		FROM item
+		WHERE uuid = ?
+	`, entryUUID).Scan(&stamp.metaUpdatedAt, &stamp.fieldUpdatedAt, &stamp.updatedAt)
+	return stamp, err
+}
+
+// wrapBusy : wrap an error, turning SQLite's busy and locked errors into ErrBusy
+func wrapBusy(err error, message string) error {
+	if isBusy(err) {
+		return errors.Wrap(ErrBusy, message)
+	}
+	return errors.Wrap(err, message)
+}
+
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package enpass
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func openTestVault(t *testing.T, path string, readOnly bool) *Vault {
+	t.Helper()
+	vault, err := NewVault(path, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("vault initialization failed: %v", err)
+	}
+	open := vault.Open
+	if readOnly {
+		open = vault.OpenReadOnly
+	}
+	if err := open(&VaultCredentials{Password: testPassword}); err != nil {
+		t.Fatalf("opening vault failed: %v", err)
+	}
+	return vault
+}
+
+func TestVault_ConcurrentReadersAndWriters(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+
+	const (
+		readers    = 4
+		writers    = 4
+		iterations = 5
+	)
+
+	// every goroutine gets its own Vault, like separate processes sharing one file
+	var wg sync.WaitGroup
+	errs := make(chan error, (readers+writers)*iterations*2)
+
+	for r := 0; r < readers; r++ {
+		vault := openTestVault(t, tmpDir, true)
+		defer vault.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				if _, err := vault.GetAllFields("", nil); err != nil {
+					errs <- fmt.Errorf("read: %w", err)
+				}
+			}
+		}()
+	}
+
+	for w := 0; w < writers; w++ {
+		vault := openTestVault(t, tmpDir, false)
+		defer vault.Close()
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				title := fmt.Sprintf("Stress %d-%d", w, i)
+				if _, err := vault.CreateEntry(&EntryData{Title: title, Password: title}); err != nil {
+					errs <- fmt.Errorf("create: %w", err)
+				}
+
+				// all writers fight over the same entry: losing a race must be reported, not applied
+				card, err := vault.GetEntry("password", []string{"Whatever"}, true)
+				if err != nil {
+					errs <- fmt.Errorf("get: %w", err)
+					continue
+				}
+				err = vault.UpdateEntry(card.UUID, &EntryData{Password: title})
+				if err != nil && !errors.Is(err, ErrConflict) {
+					errs <- fmt.Errorf("update: %w", err)
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	vault := openTestVault(t, tmpDir, true)
+	defer vault.Close()
+	Assert_GetEntries(t, vault, []string{"Stress"}, writers*iterations)
+
+	var integrity string
+	if err := vault.db.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil || integrity != "ok" {
+		t.Errorf("integrity check failed: %s %v", integrity, err)
+	}
+}
+
+func TestVault_UpdateConflict(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+
+	first := openTestVault(t, tmpDir, false)
+	defer first.Close()
+	second := openTestVault(t, tmpDir, false)
+	defer second.Close()
+
+	card, err := first.GetEntry("password", []string{"Whatever"}, true)
+	if err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+	if _, err := second.GetEntry("password", []string{"Whatever"}, true); err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+
+	if err := first.UpdateEntry(card.UUID, &EntryData{Password: "first"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+	// a second write from the same vault builds on its own change
+	if err := first.UpdateEntry(card.UUID, &EntryData{Password: "first again"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+	if err := second.UpdateEntry(card.UUID, &EntryData{Password: "second"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if err := second.TrashEntry(card.UUID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package enpass
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PBKDF2 iterations of new vaults, what current Enpass versions use
+	defaultKDFIterations = 320000
+	// vault.json version written by Enpass 6
+	vaultInfoVersion = 6
+	// signature of the Identity row of an Enpass database
+	identitySignature = "WalletxDb"
+)
+
+// vaultSchema : the tables, indexes and triggers of an Enpass 6 vault database
+var vaultSchema = []string{
+	`CREATE TABLE Identity(ID INTEGER PRIMARY KEY AUTOINCREMENT CHECK (ID=1), Version INTEGER, Signature TEXT, Sync_UUID TEXT, Hash TEXT, Info BLOB)`,
+	`CREATE TABLE identical_passwords(vault_uuid TEXT NOT NULL, item_uuid TEXT NOT NULL, item_field_uid INTEGER NOT NULL, hash TEXT NOT NULL, initial TEXT NOT NULL,  UNIQUE(vault_uuid,item_uuid,item_field_uid))`,
+	`CREATE TABLE preferences(id INTEGER PRIMARY KEY AUTOINCREMENT,vault TEXT,key TEXT,value BLOB,UNIQUE(vault,key) ON CONFLICT REPLACE)`,
+	`CREATE TABLE share_info(ID INTEGER PRIMARY KEY AUTOINCREMENT,uuid TEXT UNIQUE NOT NULL,title TEXT,value TEXT,updated_at INTEGER,deleted INTEGER)`,
+	`CREATE TABLE vault_info(ID INTEGER PRIMARY KEY AUTOINCREMENT,vault_uuid TEXT UNIQUE NOT NULL,mp BLOB,keyfile BLOB,key BLOB, deleted INTEGER NOT NULL DEFAULT 0, updated_at INTEGER NOT NULL DEFAULT 0,UNIQUE(vault_uuid) ON CONFLICT REPLACE)`,
+	`CREATE TABLE shared_vault_info ( ID INTEGER PRIMARY KEY AUTOINCREMENT,vault_uuid TEXT UNIQUE NOT NULL,mp BLOB,keyfile BLOB,key BLOB, permission_scope INTEGER DEFAULT 0,deleted INTEGER DEFAULT 0,updated_at INTEGER DEFAULT (strftime('%s', 'now')), UNIQUE(vault_uuid) ON CONFLICT REPLACE)`,
+	`CREATE TABLE item(ID INTEGER PRIMARY KEY AUTOINCREMENT,uuid TEXT UNIQUE NOT NULL,created_at INTEGER,meta_updated_at INTEGER,field_updated_at INTEGER,title TEXT,subtitle TEXT,note TEXT,icon TEXT,favorite INTEGER DEFAULT 0,trashed INTEGER DEFAULT 0,archived INTEGER DEFAULT 0,deleted INTEGER DEFAULT 0,auto_submit INTEGER DEFAULT 1,form_data TEXT DEFAULT '',category TEXT,template TEXT,wearable INTEGER DEFAULT 0,usage_count INTEGER DEFAULT 0,last_used INTEGER,key BLOB,extra TEXT DEFAULT '',updated_at INTEGER DEFAULT 0)`,
+	`CREATE TABLE itemfield(ID INTEGER PRIMARY KEY AUTOINCREMENT,item_uuid TEXT,item_field_uid INTEGER,label TEXT,value TEXT,deleted INTEGER,sensitive INTEGER,historical INTEGER,type TEXT,form_id TEXT,updated_at INTEGER,value_updated_at INTEGER,orde INTEGER,wearable INTEGER,history TEXT,initial TEXT,hash TEXT,strength INTEGER DEFAULT -1,algo_version INTEGER DEFAULT 0,expiry INTEGER DEFAULT 0,excluded INTEGER DEFAULT 0,pwned_check_time INTEGER DEFAULT 0,extra TEXT DEFAULT '',UNIQUE(item_uuid,item_field_uid) ON CONFLICT REPLACE)`,
+	`CREATE TABLE versions(ID INTEGER PRIMARY KEY AUTOINCREMENT,verison_key TEXT UNIQUE NOT NULL,verison_value INTEGER,UNIQUE(verison_key) ON CONFLICT REPLACE)`,
+	`CREATE TABLE folder(ID INTEGER PRIMARY KEY AUTOINCREMENT,uuid TEXT UNIQUE NOT NULL,title TEXT,icon TEXT,updated_at INTEGER,deleted INTEGER,parent_uuid TEXT,extra TEXT DEFAULT '')`,
+	`CREATE TABLE folder_items(ID INTEGER PRIMARY KEY AUTOINCREMENT,folder_uuid TEXT,item_uuid TEXT,updated_at INTEGER,deleted INTEGER,extra TEXT DEFAULT '',UNIQUE(folder_uuid, item_uuid) ON CONFLICT REPLACE)`,
+	`CREATE TABLE attachment(ID INTEGER PRIMARY KEY AUTOINCREMENT,uuid TEXT UNIQUE NOT NULL,item_uuid TEXT,name TEXT,size INTEGER,orde INTEGER,mime TEXT,updated_at INTEGER,created_at INTEGER,deleted INTEGER,internal INTEGER,password blob,data blob,extra TEXT DEFAULT '')`,
+	`CREATE TABLE custom_icon(ID INTEGER PRIMARY KEY AUTOINCREMENT,uuid TEXT NOT NULL,data BLOB,updated_at INTEGER,deleted INTEGER,type INTEGER,extra TEXT, UNIQUE(uuid) ON CONFLICT REPLACE)`,
+	`CREATE TABLE template(ID INTEGER PRIMARY KEY AUTOINCREMENT,uuid TEXT UNIQUE NOT NULL,title TEXT,cateogry_uuid TEXT,icon TEXT,field_json TEXT,updated_at INTEGER,deleted INTEGER,extra TEXT DEFAULT '')`,
+	`CREATE TABLE category(ID INTEGER PRIMARY KEY AUTOINCREMENT,uuid TEXT,title TEXT,icon TEXT,updated_at INTEGER,deleted INTEGER,extra TEXT DEFAULT '')`,
+	`CREATE TABLE password_history(ID INTEGER PRIMARY KEY AUTOINCREMENT,uuid TEXT UNIQUE NOT NULL,password BLOB,created_at INTEGER,domain TEXT,deleted INTEGER,extra TEXT,updated_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')))`,
+	`CREATE TABLE passkeys(uuid TEXT NOT NULL UNIQUE , item_uuid TEXT NOT NULL, label TEXT DEFAULT '',credential_id TEXT NOT NULL,relying_party_id TEXT NOT NULL,relying_party_name TEXT,user_handle TEXT NOT NULL,user_display_name TEXT,private_key BLOB NOT NULL,key_algorithm INTEGER NOT NULL,type TEXT,counter INTEGER DEFAULT 0,associated_domains TEXT DEFAULT '',created_platform TEXT NOT NULL,created_device TEXT NOT NULL ,created_app_version TEXT DEFAULT '',last_used_device TEXT DEFAULT '',last_used_time INTEGER DEFAULT (strftime('%s','now')),created_at INTEGER DEFAULT (strftime('%s','now')),updated_at INTEGER DEFAULT (strftime('%s','now')),trashed INTEGER DEFAULT 0,deleted INTEGER DEFAULT 0,extra1 TEXT DEFAULT '',extra2 TEXT DEFAULT '',extra3 TEXT DEFAULT '', PRIMARY KEY(uuid,credential_id))`,
+	`CREATE INDEX idx_vault_uuid ON identical_passwords(vault_uuid)`,
+	`CREATE INDEX idx_hash ON identical_passwords(hash)`,
+	`CREATE INDEX 'itemfield_type_index' ON itemfield ('type' ASC)`,
+	`CREATE TRIGGER tgr_folder_item_update_on_delete_item_v1 AFTER UPDATE OF deleted ON item FOR EACH ROW WHEN NEW. deleted= 1 BEGIN UPDATE folder_items SET deleted = 1  WHERE item_uuid == OLD.uuid; END`,
+}
+
+// vaultMigrations : data migrations of the Enpass app that a new vault doesn't need
+var vaultMigrations = map[string]int{
+	"itemfield-history":           2,
+	"attachment-updated_at":       1,
+	"fix-orphaned-templates":      1,
+	"fix-wrong-ordered-templates": 1,
+}
+
+// vaultInfoFile : vault.json as Enpass writes it, VaultInfo holds the part we read
+type vaultInfoFile struct {
+	VaultInfo
+	VaultAttCount           int    `json:"vault_att_count"`
+	VaultIcon               string `json:"vault_icon"`
+	LastModifiedTime        int64  `json:"last_modified_time"`
+	LastPasswordChangedTime int64  `json:"last_password_changed_time"`
+}
+
+// CreateVault : create a new, empty vault called name in the directory path, which may not
+// hold a vault yet. The database is keyed like Enpass does: a random salt is stored in its
+// first bytes and the key is derived from the password, and keyfile if given, with PBKDF2.
+// Open the vault with NewVault afterwards.
+func CreateVault(path string, credentials *VaultCredentials, name string) error {
+	if path == "" {
+		return errors.New("empty vault path provided")
+	}
+	if name == "" {
+		return errors.New("empty vault name provided")
+	}
+	if credentials == nil || credentials.Password == "" {
+		return errors.New("empty vault password provided")
+	}
+
+	v := Vault{
+		logger:            *logrus.New(),
+		databaseFilename:  filepath.Join(path, vaultFileName),
+		vaultInfoFilename: filepath.Join(path, vaultInfoFileName),
+		vaultInfo: VaultInfo{
+			EncryptionAlgo: dbEncryptionAlgo,
+			KDFAlgo:        keyDerivationAlgo,
+			KDFIterations:  defaultKDFIterations,
+			VaultName:      name,
+			VaultUUID:      uuid.New().String(),
+			VaultVersion:   vaultInfoVersion,
+		},
+	}
+	if credentials.KeyfilePath != "" {
+		v.vaultInfo.HasKeyfile = 1
+	}
+	for _, existing := range []string{v.databaseFilename, v.vaultInfoFilename} {
+		if _, err := os.Stat(existing); err == nil {
+			return errors.New("vault already exists: " + existing)
+		}
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return errors.Wrap(err, "could not create vault directory")
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return errors.Wrap(err, "could not generate salt")
+	}
+	masterPassword, err := v.generateMasterPassword([]byte(credentials.Password), credentials.KeyfilePath)
+	if err != nil {
+		return errors.Wrap(err, "could not generate vault unlock key")
+	}
+	dbKey, err := v.deriveKey(masterPassword, salt)
+	if err != nil {
+		return errors.Wrap(err, "could not derive database key from master password")
+	}
+
+	if err := v.createDatabase(hex.EncodeToString(dbKey)[:masterKeyLength], salt); err != nil {
+		_ = os.Remove(v.databaseFilename)
+		return err
+	}
+	if err := v.writeVaultInfo(); err != nil {
+		_ = os.Remove(v.databaseFilename)
+		return err
+	}
+
+	credentials.DBKey = dbKey
+	v.logger.WithField("vault_uuid", v.vaultInfo.VaultUUID).Debug("created vault")
+	return nil
+}
+
+// createDatabase : create the SQLCipher database with the vault schema. The salt is given
+// with the raw key so SQLCipher stores the one the key was derived with.
+func (v *Vault) createDatabase(hexKey string, salt []byte) error {
+	uri := url.URL{Scheme: "file", Path: filepath.ToSlash(v.databaseFilename)}
+	params := fmt.Sprintf("?_pragma_key=x'%s%s'&mode=rwc", hexKey, hex.EncodeToString(salt))
+	db, err := sql.Open("sqlite3", uri.String()+params)
+	if err != nil {
+		return errors.Wrap(err, "could not create database")
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "could not begin transaction")
+	}
+	defer tx.Rollback()
+
+	for _, statement := range vaultSchema {
+		if _, err := tx.Exec(statement); err != nil {
+			return errors.Wrap(err, "could not create vault schema")
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO Identity (ID, Version, Signature, Sync_UUID) VALUES (1, ?, ?, ?)",
+		vaultInfoVersion, identitySignature, uuid.New().String()); err != nil {
+		return errors.Wrap(err, "could not write identity")
+	}
+	for key, value := range vaultMigrations {
+		if _, err := tx.Exec("INSERT INTO versions (verison_key, verison_value) VALUES (?, ?)", key, value); err != nil {
+			return errors.Wrap(err, "could not write schema versions")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "could not commit transaction")
+	}
+	return os.Chmod(v.databaseFilename, 0600)
+}
+
+// writeVaultInfo : write vault.json for a new vault
+func (v *Vault) writeVaultInfo() error {
+	now := time.Now().Unix()
+	info, err := json.MarshalIndent(vaultInfoFile{
+		VaultInfo:               v.vaultInfo,
+		VaultIcon:               "vault/v3",
+		LastModifiedTime:        now,
+		LastPasswordChangedTime: now,
+	}, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode vault info")
+	}
+	if err := os.WriteFile(v.vaultInfoFilename, info, 0600); err != nil {
+		return errors.Wrap(err, "could not write vault info")
+	}
+	return nil
+}
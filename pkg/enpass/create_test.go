@@ -0,0 +1,98 @@
+package enpass
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// createTestVault : create an empty vault protected by testPassword in a new temporary directory
+func createTestVault(t *testing.T, name string) string {
+	t.Helper()
+	tmpDir, err := os.MkdirTemp("", "enpass-test-*")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	if err := CreateVault(tmpDir, &VaultCredentials{Password: testPassword}, name); err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("CreateVault failed: %v", err)
+	}
+	return tmpDir
+}
+
+func TestCreateVault(t *testing.T) {
+	tmpDir := createTestVault(t, "Fresh")
+	defer os.RemoveAll(tmpDir)
+
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+	if info := vault.Info(); info.VaultName != "Fresh" || info.VaultUUID == "" || info.KDFIterations != defaultKDFIterations {
+		t.Errorf("unexpected vault info %+v", info)
+	}
+	if cards, err := vault.GetEntries("", nil); err != nil || len(cards) != 0 {
+		t.Fatalf("expected an empty vault, got %v %v", cards, err)
+	}
+
+	entryUUID, err := vault.CreateEntry(&EntryData{Title: "First", Username: "me", Password: "secret", Folders: []string{"Work"}})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	card, err := vault.GetEntry("password", []string{"First"}, true)
+	if err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+	if password, err := card.Decrypt(); err != nil || password != "secret" || card.UUID != entryUUID {
+		t.Errorf("expected the new entry to decrypt, got %q %v", password, err)
+	}
+
+	wrong, err := NewVault(tmpDir, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("NewVault failed: %v", err)
+	}
+	if err := wrong.OpenReadOnly(&VaultCredentials{Password: "wrong"}); err == nil {
+		wrong.Close()
+		t.Error("expected the wrong password to be refused")
+	}
+
+	if err := CreateVault(tmpDir, &VaultCredentials{Password: testPassword}, "Again"); err == nil {
+		t.Error("expected CreateVault to refuse an existing vault")
+	}
+}
+
+func TestCreateVault_Keyfile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "enpass-test-*")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	keyfile := filepath.Join(tmpDir, "vault.enpasskey")
+	if err := os.WriteFile(keyfile, []byte("<Key>00112233445566778899aabbccddeeff</Key>"), 0600); err != nil {
+		t.Fatalf("could not write keyfile: %v", err)
+	}
+	vaultDir := filepath.Join(tmpDir, "vault")
+
+	credentials := &VaultCredentials{Password: testPassword, KeyfilePath: keyfile}
+	if err := CreateVault(vaultDir, credentials, "Keyed"); err != nil {
+		t.Fatalf("CreateVault failed: %v", err)
+	}
+	if credentials.DBKey == nil {
+		t.Error("expected CreateVault to set the database key")
+	}
+
+	vault, err := NewVault(vaultDir, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("NewVault failed: %v", err)
+	}
+	defer vault.Close()
+	if vault.Info().HasKeyfile != 1 {
+		t.Error("expected the vault info to require a keyfile")
+	}
+	if err := vault.OpenReadOnly(&VaultCredentials{Password: testPassword}); err == nil {
+		t.Fatal("expected opening without the keyfile to fail")
+	}
+	if err := vault.OpenReadOnly(&VaultCredentials{Password: testPassword, KeyfilePath: keyfile}); err != nil {
+		t.Fatalf("opening with the keyfile failed: %v", err)
+	}
+}
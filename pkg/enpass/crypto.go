@@ -52,3 +52,38 @@ func EncryptValue(plaintext string, uuid string) (encryptedValue string, itemKey
 
 	return encryptedValue, itemKey, nil
 }
+
+// decryptItemData decrypts data sealed with the key of an item. The key object is saved in
+// binary form and consists of the AES key (32 bytes) and a nonce (12 bytes) for GCM.
+// As additional authenticated data (AAD) the UUID is used without the dashes,
+// e.g. a2ec30c0aeed41f7aed7cc50e69ff506.
+func decryptItemData(itemKey []byte, ciphertextAndTag []byte, uuid string) ([]byte, error) {
+	if len(itemKey) < 32 {
+		return nil, errors.Errorf("item key is %d bytes, too short for an AES-256 key", len(itemKey))
+	}
+	key := itemKey[:32]
+	nonce := itemKey[32:]
+
+	header, err := hex.DecodeString(strings.ReplaceAll(uuid, "-", ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode card hex AAD")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not initialize card cipher")
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not initialize GCM block")
+	}
+	if len(nonce) != aesgcm.NonceSize() {
+		return nil, errors.Errorf("item key has a %d byte nonce, expected %d", len(nonce), aesgcm.NonceSize())
+	}
+
+	plaintext, err := aesgcm.Open(nil, nonce, ciphertextAndTag, header)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decrypt data")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,344 @@
+package enpass
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Change : how an entry or value differs between two vaults
+type Change string
+
+const (
+	// ChangeAdded : only the other vault has it
+	ChangeAdded Change = "added"
+	// ChangeRemoved : only this vault has it
+	ChangeRemoved Change = "removed"
+	// ChangeChanged : both vaults have it, with different values
+	ChangeChanged Change = "changed"
+)
+
+// DiffOptions : what Diff reports
+type DiffOptions struct {
+	// Secrets : include the values of sensitive fields and notes, which are redacted otherwise
+	Secrets bool
+}
+
+// EntryDiff : an entry that differs between two vaults
+type EntryDiff struct {
+	UUID   string `json:"uuid"`
+	Title  string `json:"title"`
+	Change Change `json:"change"`
+	// Modified, OtherModified : when the entry was last modified in this and the other vault,
+	// as Unix time, 0 where it is missing
+	Modified      int64 `json:"modified,omitempty"`
+	OtherModified int64 `json:"other_modified,omitempty"`
+	// Values : the attributes and fields that differ, for changed entries
+	Values []ValueDiff `json:"values,omitempty"`
+}
+
+// OtherIsNewer : whether the other vault modified the entry last, so it wins a
+// last-writer-wins merge
+func (d *EntryDiff) OtherIsNewer() bool {
+	return d.OtherModified > d.Modified
+}
+
+// ValueDiff : an attribute or field of an entry that differs between two vaults
+type ValueDiff struct {
+	// Name : the attribute, e.g. title or folders, or the label or type of the field
+	Name string `json:"name"`
+	// FieldUID : item_field_uid of the field, -1 for attributes and fields without one
+	FieldUID   int64  `json:"field_uid"`
+	Change     Change `json:"change"`
+	Value      string `json:"value,omitempty"`
+	OtherValue string `json:"other_value,omitempty"`
+	// Redacted : the values are secret and left out
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// entryFields : an entry with its fields keyed for comparison
+type entryFields struct {
+	item   Card
+	fields map[string]Card
+	// keys of fields in display order
+	order []string
+}
+
+// modified : when the entry was last modified, the latest of the timestamps itemStamp compares
+func (e *entryFields) modified() int64 {
+	return max(e.item.UpdatedAt, e.item.metaUpdatedAt, e.item.itemUpdatedAt)
+}
+
+// fieldKey : how a field is matched between vaults, by item_field_uid when it has one
+func fieldKey(card *Card) string {
+	if card.FieldUID >= 0 {
+		return "uid:" + strconv.FormatInt(card.FieldUID, 10)
+	}
+	return "type:" + card.Type + "/" + card.Label
+}
+
+// diffEntries : every entry of the vault with its fields, by UUID
+func (v *Vault) diffEntries() (map[string]*entryFields, error) {
+	cards, err := v.GetAllFields("", nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*entryFields)
+	for _, card := range cards {
+		entry, found := entries[card.UUID]
+		if !found {
+			entry = &entryFields{item: card, fields: make(map[string]Card)}
+			entries[card.UUID] = entry
+		}
+		key := fieldKey(&card)
+		if _, duplicate := entry.fields[key]; !duplicate {
+			entry.order = append(entry.order, key)
+		}
+		entry.fields[key] = card
+	}
+	return entries, nil
+}
+
+// Diff : compare the entries of this vault with those of other by UUID, and their fields by
+// item_field_uid. Entries only other has are ChangeAdded, those only this vault has are
+// ChangeRemoved. The filters of the vaults apply, so entries without fields aren't compared.
+func (v *Vault) Diff(other *Vault, opts *DiffOptions) ([]EntryDiff, error) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+	ours, err := v.diffEntries()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read vault")
+	}
+	theirs, err := other.diffEntries()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read other vault")
+	}
+
+	diffs := make([]EntryDiff, 0)
+	for entryUUID, our := range ours {
+		their, found := theirs[entryUUID]
+		if !found {
+			diffs = append(diffs, EntryDiff{UUID: entryUUID, Title: our.item.Title, Change: ChangeRemoved, Modified: our.modified()})
+			continue
+		}
+		values, err := diffEntry(our, their, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not compare entry "+our.item.Title)
+		}
+		if len(values) > 0 {
+			diffs = append(diffs, EntryDiff{
+				UUID: entryUUID, Title: their.item.Title, Change: ChangeChanged,
+				Modified: our.modified(), OtherModified: their.modified(), Values: values,
+			})
+		}
+	}
+	for entryUUID, their := range theirs {
+		if _, found := ours[entryUUID]; !found {
+			diffs = append(diffs, EntryDiff{UUID: entryUUID, Title: their.item.Title, Change: ChangeAdded, OtherModified: their.modified()})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		if a, b := strings.ToLower(diffs[i].Title), strings.ToLower(diffs[j].Title); a != b {
+			return a < b
+		}
+		return diffs[i].UUID < diffs[j].UUID
+	})
+	return diffs, nil
+}
+
+// diffEntry : the attributes and fields that differ between two versions of an entry
+func diffEntry(our *entryFields, their *entryFields, opts *DiffOptions) ([]ValueDiff, error) {
+	values := make([]ValueDiff, 0)
+	add := func(d ValueDiff, sensitive bool) {
+		if sensitive && !opts.Secrets {
+			d.Value, d.OtherValue, d.Redacted = "", "", true
+		}
+		values = append(values, d)
+	}
+
+	a, b := our.item, their.item
+	for _, attr := range []struct {
+		name      string
+		ours      string
+		theirs    string
+		sensitive bool
+	}{
+		{"title", a.Title, b.Title, false},
+		{"subtitle", a.Subtitle, b.Subtitle, false},
+		{"category", a.Category, b.Category, false},
+		{"note", a.Note, b.Note, true},
+		{"folders", strings.Join(a.Folders, ", "), strings.Join(b.Folders, ", "), false},
+		{"trashed", strconv.FormatBool(a.IsTrashed()), strconv.FormatBool(b.IsTrashed()), false},
+		{"archived", strconv.FormatBool(a.IsArchived()), strconv.FormatBool(b.IsArchived()), false},
+		{"favorite", strconv.FormatBool(a.IsFavorite()), strconv.FormatBool(b.IsFavorite()), false},
+	} {
+		if attr.ours != attr.theirs {
+			add(ValueDiff{Name: attr.name, FieldUID: -1, Change: ChangeChanged, Value: attr.ours, OtherValue: attr.theirs}, attr.sensitive)
+		}
+	}
+
+	for _, key := range our.order {
+		ourField := our.fields[key]
+		ourValue, err := ourField.Decrypt()
+		if err != nil {
+			return nil, err
+		}
+		theirField, found := their.fields[key]
+		if !found {
+			add(ValueDiff{Name: fieldLabel(&ourField), FieldUID: ourField.FieldUID, Change: ChangeRemoved, Value: ourValue}, ourField.Sensitive)
+			continue
+		}
+		theirValue, err := theirField.Decrypt()
+		if err != nil {
+			return nil, err
+		}
+		if ourValue != theirValue || ourField.Label != theirField.Label {
+			add(ValueDiff{Name: fieldLabel(&theirField), FieldUID: theirField.FieldUID, Change: ChangeChanged, Value: ourValue, OtherValue: theirValue},
+				ourField.Sensitive || theirField.Sensitive)
+		}
+	}
+	for _, key := range their.order {
+		if _, found := our.fields[key]; found {
+			continue
+		}
+		theirField := their.fields[key]
+		theirValue, err := theirField.Decrypt()
+		if err != nil {
+			return nil, err
+		}
+		add(ValueDiff{Name: fieldLabel(&theirField), FieldUID: theirField.FieldUID, Change: ChangeAdded, OtherValue: theirValue}, theirField.Sensitive)
+	}
+	return values, nil
+}
+
+// fieldLabel : the label of a field, or its type when it has none
+func fieldLabel(card *Card) string {
+	if card.Label != "" {
+		return card.Label
+	}
+	return card.Type
+}
+
+// CopyEntryFrom : make the entry with the UUID the same as in other, adding it when this vault
+// doesn't have it. The item and its fields are copied as they are stored, so encrypted values
+// stay readable with the item key copied along. Only the folders of the entry are matched by
+// title. ErrConflict is returned when this vault changed the entry since it was last read.
+func (v *Vault) CopyEntryFrom(other *Vault, entryUUID string) error {
+	if err := v.checkWritable(); err != nil {
+		return err
+	}
+	if other.db == nil {
+		return errors.Wrap(ErrNotInitialized, "other vault")
+	}
+
+	source := []Card{{UUID: entryUUID}}
+	if err := other.attachFolders(source); err != nil {
+		return errors.Wrap(err, "could not read folders of other vault")
+	}
+
+	tx, err := v.db.Begin()
+	if err != nil {
+		return wrapBusy(err, "could not begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := v.checkStamp(tx, entryUUID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM itemfield WHERE item_uuid = ?", entryUUID); err != nil {
+		return wrapBusy(err, "could not replace item fields")
+	}
+	if _, err := tx.Exec("DELETE FROM item WHERE uuid = ?", entryUUID); err != nil {
+		return wrapBusy(err, "could not replace item")
+	}
+
+	copied, err := copyRows(tx, other.db, "item", "uuid = ?", entryUUID)
+	if err != nil {
+		return err
+	}
+	if copied == 0 {
+		return ErrNotFound
+	}
+	if _, err := copyRows(tx, other.db, "itemfield", "item_uuid = ?", entryUUID); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	if err := setItemFolders(tx, entryUUID, source[0].Folders, now); err != nil {
+		return err
+	}
+	// a changed stamp makes other devices pick up the copy
+	if err := v.touchItem(tx, entryUUID, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return wrapBusy(err, "could not commit transaction")
+	}
+
+	v.refreshStamp(entryUUID)
+	v.logger.WithField("uuid", entryUUID).Debug("copied entry from other vault")
+	return nil
+}
+
+// copyRows : insert the rows of table matching where in from into tx, with the columns both
+// databases have except the row ID, and return how many were copied
+func copyRows(tx *sql.Tx, from *sql.DB, table string, where string, args ...interface{}) (int, error) {
+	columns, err := tableColumns(tx, table)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := from.Query("SELECT * FROM "+table+" WHERE "+where, args...)
+	if err != nil {
+		return 0, wrapBusy(err, "could not read "+table+" of other vault")
+	}
+	defer rows.Close()
+
+	sourceColumns, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not read "+table+" of other vault")
+	}
+	shared := make([]string, 0, len(sourceColumns))
+	indexes := make([]int, 0, len(sourceColumns))
+	for i, column := range sourceColumns {
+		if _, exists := columns[strings.ToLower(column.Name())]; exists && !strings.EqualFold(column.Name(), "id") {
+			shared = append(shared, column.Name())
+			indexes = append(indexes, i)
+		}
+	}
+	insert := "INSERT INTO " + table + " (" + strings.Join(shared, ", ") + ") VALUES (?" +
+		strings.Repeat(", ?", len(shared)-1) + ")"
+
+	copied := 0
+	for rows.Next() {
+		values := make([]interface{}, len(sourceColumns))
+		pointers := make([]interface{}, len(sourceColumns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return copied, errors.Wrap(err, "could not read "+table+" of other vault")
+		}
+		insertValues := make([]interface{}, 0, len(indexes))
+		for _, i := range indexes {
+			// the driver reads text as bytes, which would be written back as blobs, or NULL when empty
+			if b, isBytes := values[i].([]byte); isBytes && sourceColumns[i].DatabaseTypeName() != "BLOB" {
+				values[i] = string(b)
+			}
+			insertValues = append(insertValues, values[i])
+		}
+		if _, err := tx.Exec(insert, insertValues...); err != nil {
+			return copied, wrapBusy(err, "could not copy "+table)
+		}
+		copied++
+	}
+	return copied, errors.Wrap(rows.Err(), "error iterating database rows")
+}
@@ -0,0 +1,153 @@
+package enpass
+
+import (
+	"os"
+	"testing"
+)
+
+// findDiff : the diff of the entry with the title, nil when there is none
+func findDiff(diffs []EntryDiff, title string) *EntryDiff {
+	for i := range diffs {
+		if diffs[i].Title == title {
+			return &diffs[i]
+		}
+	}
+	return nil
+}
+
+func TestEntryFields_Modified(t *testing.T) {
+	tests := []struct {
+		name     string
+		item     Card
+		expected int64
+	}{
+		{"fields", Card{UpdatedAt: 30, metaUpdatedAt: 20, itemUpdatedAt: 10}, 30},
+		{"metadata", Card{UpdatedAt: 10, metaUpdatedAt: 30, itemUpdatedAt: 20}, 30},
+		{"item", Card{UpdatedAt: 10, metaUpdatedAt: 20, itemUpdatedAt: 30}, 30},
+		{"missing", Card{}, 0},
+	}
+	for _, test := range tests {
+		entry := entryFields{item: test.item}
+		if modified := entry.modified(); modified != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, modified)
+		}
+	}
+}
+
+func TestVault_Diff(t *testing.T) {
+	oursDir, theirsDir := copyTestVault(t), copyTestVault(t)
+	defer os.RemoveAll(oursDir)
+	defer os.RemoveAll(theirsDir)
+	ours := openTestVault(t, oursDir, false)
+	defer ours.Close()
+	theirs := openTestVault(t, theirsDir, false)
+	defer theirs.Close()
+
+	if diffs, err := ours.Diff(theirs, nil); err != nil || len(diffs) != 0 {
+		t.Fatalf("expected copies of a vault not to differ, got %+v %v", diffs, err)
+	}
+
+	if _, err := ours.CreateEntry(&EntryData{Title: "Only ours", Password: "a"}); err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	if _, err := theirs.CreateEntry(&EntryData{Title: "Only theirs", Password: "b", Folders: []string{"Work"}}); err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	if err := theirs.UpdateEntry(testItemUUID, &EntryData{Password: "changed", Notes: "new notes"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+
+	diffs, err := ours.Diff(theirs, nil)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if len(diffs) != 3 {
+		t.Fatalf("expected 3 diffs, got %+v", diffs)
+	}
+	if d := findDiff(diffs, "Only ours"); d == nil || d.Change != ChangeRemoved {
+		t.Errorf("expected the entry only we have to be removed, got %+v", d)
+	}
+	if d := findDiff(diffs, "Only theirs"); d == nil || d.Change != ChangeAdded || d.OtherModified == 0 {
+		t.Errorf("expected the entry only they have to be added, got %+v", d)
+	}
+	changed := findDiff(diffs, "Whatever")
+	if changed == nil || changed.Change != ChangeChanged || !changed.OtherIsNewer() {
+		t.Fatalf("expected Whatever to be changed by them last, got %+v", changed)
+	}
+	names := map[string]ValueDiff{}
+	for _, value := range changed.Values {
+		names[value.Name] = value
+	}
+	if password, found := names["password"]; !found || !password.Redacted || password.OtherValue != "" {
+		t.Errorf("expected the password change to be redacted, got %+v", changed.Values)
+	}
+	if note, found := names["note"]; !found || !note.Redacted {
+		t.Errorf("expected the note change to be redacted, got %+v", changed.Values)
+	}
+
+	diffs, err = ours.Diff(theirs, &DiffOptions{Secrets: true})
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	for _, value := range findDiff(diffs, "Whatever").Values {
+		if value.Name == "password" && (value.Value != "noIdeaata11" || value.OtherValue != "changed") {
+			t.Errorf("expected the password values with Secrets, got %+v", value)
+		}
+	}
+
+	for _, d := range diffs {
+		if d.Change == ChangeRemoved {
+			continue
+		}
+		if err := ours.CopyEntryFrom(theirs, d.UUID); err != nil {
+			t.Fatalf("CopyEntryFrom %s failed: %v", d.Title, err)
+		}
+	}
+
+	diffs, err = ours.Diff(theirs, nil)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].Title != "Only ours" {
+		t.Errorf("expected only our own entry to differ after copying, got %+v", diffs)
+	}
+
+	card, err := ours.GetEntry("password", []string{"Whatever"}, true)
+	if err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+	if password, err := card.Decrypt(); err != nil || password != "changed" {
+		t.Errorf("expected the copied password to decrypt, got %q %v", password, err)
+	}
+	copied, err := ours.GetEntry("password", []string{"Only theirs"}, true)
+	if err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+	if len(copied.Folders) != 1 || copied.Folders[0] != "Work" {
+		t.Errorf("expected the copied entry in its folder, got %v", copied.Folders)
+	}
+}
+
+func TestVault_CopyEntryFromConflict(t *testing.T) {
+	oursDir, theirsDir := copyTestVault(t), copyTestVault(t)
+	defer os.RemoveAll(oursDir)
+	defer os.RemoveAll(theirsDir)
+	ours := openTestVault(t, oursDir, false)
+	defer ours.Close()
+	theirs := openTestVault(t, theirsDir, true)
+	defer theirs.Close()
+
+	if _, err := ours.Diff(theirs, nil); err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	// another connection changes the entry after it was compared
+	other := openTestVault(t, oursDir, false)
+	defer other.Close()
+	if err := other.UpdateEntry(testItemUUID, &EntryData{Title: "Renamed"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+
+	if err := ours.CopyEntryFrom(theirs, testItemUUID); err != ErrConflict {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package enpass
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// directory below the Enpass data directory holding one directory per vault
+	vaultsDirName = "Vaults"
+)
+
+// DiscoveredVault : a vault found in an Enpass data directory
+type DiscoveredVault struct {
+	// Name : the name of the vault directory, e.g. "primary"
+	Name string
+	// Path : the vault directory, usable with NewVault
+	Path string
+}
+
+// DefaultDataDirs : the directories the Enpass desktop app keeps its data in by default on this OS
+func DefaultDataDirs() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return []string{}
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{
+			filepath.Join(home, "Library", "Containers", "in.sinew.Enpass-Desktop", "Data", "Documents"),
+			filepath.Join(home, "Documents", "Enpass"),
+		}
+	default:
+		return []string{
+			filepath.Join(home, "Documents", "Enpass"),
+			filepath.Join(home, ".local", "share", "Enpass"),
+		}
+	}
+}
+
+// DiscoverVaults : find the vaults in an Enpass data directory laid out as Vaults/<name>/vault.enpassdb.
+// A missing data directory is not an error and yields no vaults.
+func DiscoverVaults(dataDir string) ([]DiscoveredVault, error) {
+	vaultsDir := filepath.Join(dataDir, vaultsDirName)
+	dirEntries, err := os.ReadDir(vaultsDir)
+	if os.IsNotExist(err) {
+		return []DiscoveredVault{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not read vaults directory")
+	}
+
+	vaults := make([]DiscoveredVault, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		vaultPath := filepath.Join(vaultsDir, dirEntry.Name())
+		if _, err := os.Stat(filepath.Join(vaultPath, vaultFileName)); err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(vaultPath, vaultInfoFileName)); err != nil {
+			continue
+		}
+		vaults = append(vaults, DiscoveredVault{Name: dirEntry.Name(), Path: vaultPath})
+	}
+
+	sort.Slice(vaults, func(i, j int) bool {
+		return vaults[i].Name < vaults[j].Name
+	})
+	return vaults, nil
+}
@@ -0,0 +1,45 @@
+package enpass
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscoverVaults(t *testing.T) {
+	dataDir, err := os.MkdirTemp("", "enpass-data-*")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	for _, name := range []string{"primary", "team"} {
+		vaultDir := filepath.Join(dataDir, "Vaults", name)
+		if err := os.MkdirAll(vaultDir, 0700); err != nil {
+			t.Fatalf("could not create vault dir: %v", err)
+		}
+		os.WriteFile(filepath.Join(vaultDir, "vault.enpassdb"), nil, 0600)
+		os.WriteFile(filepath.Join(vaultDir, "vault.json"), nil, 0600)
+	}
+	// directories without a vault database are ignored
+	os.MkdirAll(filepath.Join(dataDir, "Vaults", "empty"), 0700)
+
+	vaults, err := DiscoverVaults(dataDir)
+	if err != nil {
+		t.Fatalf("DiscoverVaults failed: %v", err)
+	}
+	if len(vaults) != 2 {
+		t.Fatalf("expected 2 vaults, got %d", len(vaults))
+	}
+	if vaults[0].Name != "primary" || vaults[1].Name != "team" {
+		t.Errorf("unexpected vault names: %s, %s", vaults[0].Name, vaults[1].Name)
+	}
+	if vaults[0].Path != filepath.Join(dataDir, "Vaults", "primary") {
+		t.Errorf("unexpected vault path: %s", vaults[0].Path)
+	}
+
+	vaults, err = DiscoverVaults(filepath.Join(dataDir, "missing"))
+	if err != nil || len(vaults) != 0 {
+		t.Errorf("expected no vaults and no error for a missing data dir, got %v, %v", vaults, err)
+	}
+}
@@ -0,0 +1,53 @@
+package enpass
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned by this package, possibly wrapped with more context. Test for them with
+// errors.Is, and for ErrAmbiguous and ErrUnsupportedSchema with errors.As. ErrBusy and
+// ErrConflict are about concurrent access, see concurrency.go.
+var (
+	// ErrNotFound : no entry matched
+	ErrNotFound = errors.New("entry not found")
+	// ErrDeleted : the entry was deleted, or moved to the trash
+	ErrDeleted = errors.New("entry has been deleted or trashed")
+	// ErrWrongPassword : the password, keyfile or key doesn't open the vault database
+	ErrWrongPassword = errors.New("wrong password or keyfile, or unsupported database version")
+	// ErrKeyfileRequired : the vault is protected by a keyfile as well, and none was given
+	ErrKeyfileRequired = errors.New("vault needs a keyfile, specify one")
+	// ErrKeyfileNotNeeded : a keyfile was given for a vault that isn't protected by one
+	ErrKeyfileNotNeeded = errors.New("vault has no keyfile, you are specifying an unnecessary one")
+	// ErrNotInitialized : the vault wasn't opened, or was closed
+	ErrNotInitialized = errors.New("vault is not initialized")
+	// ErrReadOnly : a write was attempted on a vault opened with OpenReadOnly
+	ErrReadOnly = errors.New("vault is opened read-only")
+	// ErrNoVault : the vault directory lacks the database or vault.json
+	ErrNoVault = errors.New("vault does not exist")
+)
+
+// IsNotFound : whether err means that no entry matched
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// ErrAmbiguous : several entries match where a single one was asked for
+type ErrAmbiguous struct {
+	// Matches : one card of each matching entry
+	Matches []Card
+}
+
+func (e *ErrAmbiguous) Error() string {
+	titles := make([]string, 0, len(e.Matches))
+	for _, card := range e.Matches {
+		title := card.Title
+		if card.VaultName != "" {
+			title += " (" + card.VaultName + ")"
+		}
+		titles = append(titles, title)
+	}
+	return fmt.Sprintf("%d entries match: %s", len(e.Matches), strings.Join(titles, ", "))
+}
@@ -0,0 +1,104 @@
+package enpass
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func TestErrors_Open(t *testing.T) {
+	vault, err := NewVault(vaultPath, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("vault initialization failed: %v", err)
+	}
+	defer vault.Close()
+
+	if err := vault.OpenReadOnly(&VaultCredentials{Password: "wrong"}); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("expected ErrWrongPassword, got %v", err)
+	}
+	if err := vault.OpenReadOnly(&VaultCredentials{Password: testPassword, KeyfilePath: "key"}); !errors.Is(err, ErrKeyfileNotNeeded) {
+		t.Errorf("expected ErrKeyfileNotNeeded, got %v", err)
+	}
+	if _, err := vault.GetEntries("password", nil); !errors.Is(err, ErrNotInitialized) {
+		t.Errorf("expected ErrNotInitialized, got %v", err)
+	}
+
+	if _, err := NewVault(t.TempDir(), logrus.ErrorLevel); !errors.Is(err, ErrNoVault) {
+		t.Errorf("expected ErrNoVault, got %v", err)
+	}
+}
+
+// an error opening the database other than a wrong key must not be reported as a wrong password
+func TestErrors_OpenBusy(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	writer := openTestVault(t, tmpDir, false)
+	defer writer.Close()
+
+	conn, err := writer.db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("could not get connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "BEGIN EXCLUSIVE"); err != nil {
+		t.Fatalf("could not lock database: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	vault, err := NewVault(tmpDir, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("vault initialization failed: %v", err)
+	}
+	defer vault.Close()
+	vault.BusyTimeout = 100 * time.Millisecond
+	if err := vault.OpenReadOnly(&VaultCredentials{Password: testPassword}); !errors.Is(err, ErrBusy) {
+		t.Errorf("expected ErrBusy, got %v", err)
+	}
+}
+
+func TestErrors_Entries(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+
+	if _, err := vault.GetEntry("password", []string{"Nope"}, true); !errors.Is(err, ErrNotFound) || !IsNotFound(err) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	duplicateUUID, err := vault.CreateEntry(&EntryData{Title: "Whatever", Password: "other"})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	_, err = vault.GetEntry("password", []string{"Whatever"}, true)
+	var ambiguous *ErrAmbiguous
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("expected ErrAmbiguous, got %v", err)
+	}
+	if len(ambiguous.Matches) != 2 {
+		t.Errorf("expected 2 matches, got %+v", ambiguous.Matches)
+	}
+	if _, err := vault.GetEntry("password", []string{"Whatever"}, false); err != nil {
+		t.Errorf("expected the first match without unique, got %v", err)
+	}
+
+	if err := vault.TrashEntry(duplicateUUID); err != nil {
+		t.Fatalf("TrashEntry failed: %v", err)
+	}
+	if _, err := vault.Resolve("enpass://" + duplicateUUID + "/password"); !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted resolving a trashed entry, got %v", err)
+	}
+	if err := vault.TrashEntry("00000000-0000-0000-0000-000000000000"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound trashing a missing entry, got %v", err)
+	}
+
+	readOnly := openTestVault(t, tmpDir, true)
+	defer readOnly.Close()
+	if _, err := readOnly.CreateEntry(&EntryData{Title: "Nope", Password: "nope"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
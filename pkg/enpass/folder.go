@@ -0,0 +1,182 @@
+package enpass
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Folder : a folder items can be filed in. The Enpass 6 apps show these as tags;
+// they are stored in the folder table and linked to items through folder_items.
+type Folder struct {
+	UUID       string
+	Title      string
+	ParentUUID string
+}
+
+// ListFolders : return every folder of the vault, sorted by title
+func (v *Vault) ListFolders() ([]Folder, error) {
+	if v.db == nil {
+		return nil, ErrNotInitialized
+	}
+
+	rows, err := v.db.Query(`
+		SELECT uuid, COALESCE(title, ''), COALESCE(parent_uuid, '')
+		FROM folder
+		WHERE COALESCE(deleted, 0) = 0
+		ORDER BY lower(title)
+	`)
+	if err != nil {
+		return nil, wrapBusy(err, "could not retrieve folders")
+	}
+	defer rows.Close()
+
+	folders := make([]Folder, 0)
+	for rows.Next() {
+		var folder Folder
+		if err := rows.Scan(&folder.UUID, &folder.Title, &folder.ParentUUID); err != nil {
+			return nil, errors.Wrap(err, "could not read folder from database")
+		}
+		folders = append(folders, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating database rows")
+	}
+
+	return folders, nil
+}
+
+// attachFolders : fill in Card.Folders for every card
+func (v *Vault) attachFolders(cards []Card) error {
+	if len(cards) == 0 {
+		return nil
+	}
+
+	rows, err := v.db.Query(`
+		SELECT folder_items.item_uuid, COALESCE(folder.title, '')
+		FROM folder_items
+		INNER JOIN folder ON folder.uuid = folder_items.folder_uuid
+		WHERE COALESCE(folder_items.deleted, 0) = 0 AND COALESCE(folder.deleted, 0) = 0
+		ORDER BY lower(folder.title)
+	`)
+	if err != nil {
+		return wrapBusy(err, "could not retrieve folder membership")
+	}
+	defer rows.Close()
+
+	itemFolders := make(map[string][]string)
+	for rows.Next() {
+		var itemUUID, title string
+		if err := rows.Scan(&itemUUID, &title); err != nil {
+			return errors.Wrap(err, "could not read folder membership")
+		}
+		itemFolders[itemUUID] = append(itemFolders[itemUUID], title)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "error iterating database rows")
+	}
+
+	for i := range cards {
+		cards[i].Folders = itemFolders[cards[i].UUID]
+	}
+	return nil
+}
+
+// folderFilterQuery : the WHERE clause restricting items to the given folders and their subfolders
+func folderFilterQuery(folders []string) (string, []interface{}) {
+	placeholders := make([]string, 0, len(folders))
+	values := make([]interface{}, 0, len(folders))
+	for _, folder := range folders {
+		placeholders = append(placeholders, "?")
+		values = append(values, strings.ToLower(folder))
+	}
+
+	return `item.uuid IN (
+			WITH RECURSIVE selected(uuid) AS (
+				SELECT uuid FROM folder
+				WHERE COALESCE(deleted, 0) = 0 AND lower(title) IN (` + strings.Join(placeholders, ", ") + `)
+				UNION
+				SELECT folder.uuid FROM folder
+				INNER JOIN selected ON folder.parent_uuid = selected.uuid
+				WHERE COALESCE(folder.deleted, 0) = 0
+			)
+			SELECT item_uuid FROM folder_items
+			WHERE COALESCE(deleted, 0) = 0 AND folder_uuid IN (SELECT uuid FROM selected)
+		)`, values
+}
+
+// setItemFolders : file an item in exactly the given folders, creating folders that don't exist yet.
+// Memberships are soft-deleted like the Enpass apps do, so the change syncs to other devices.
+func setItemFolders(tx *sql.Tx, itemUUID string, titles []string, now int64) error {
+	folderUUIDs := make(map[string]struct{}, len(titles))
+	for _, title := range titles {
+		if title == "" {
+			continue
+		}
+		folderUUID, err := findOrCreateFolder(tx, title, now)
+		if err != nil {
+			return err
+		}
+		folderUUIDs[folderUUID] = struct{}{}
+	}
+
+	rows, err := tx.Query("SELECT folder_uuid FROM folder_items WHERE item_uuid = ? AND COALESCE(deleted, 0) = 0", itemUUID)
+	if err != nil {
+		return wrapBusy(err, "could not retrieve folder membership")
+	}
+	current := make([]string, 0)
+	for rows.Next() {
+		var folderUUID string
+		if err := rows.Scan(&folderUUID); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "could not read folder membership")
+		}
+		current = append(current, folderUUID)
+	}
+	rows.Close()
+
+	for _, folderUUID := range current {
+		if _, keep := folderUUIDs[folderUUID]; keep {
+			delete(folderUUIDs, folderUUID)
+			continue
+		}
+		if _, err := tx.Exec("UPDATE folder_items SET deleted = 1, updated_at = ? WHERE folder_uuid = ? AND item_uuid = ?",
+			now, folderUUID, itemUUID); err != nil {
+			return wrapBusy(err, "could not remove item from folder")
+		}
+	}
+
+	for folderUUID := range folderUUIDs {
+		// the unique (folder_uuid, item_uuid) constraint replaces soft-deleted rows
+		if _, err := tx.Exec(`
+			INSERT INTO folder_items (folder_uuid, item_uuid, updated_at, deleted)
+			VALUES (?, ?, ?, 0)
+		`, folderUUID, itemUUID, now); err != nil {
+			return wrapBusy(err, "could not add item to folder")
+		}
+	}
+
+	return nil
+}
+
+func findOrCreateFolder(tx *sql.Tx, title string, now int64) (string, error) {
+	var folderUUID string
+	err := tx.QueryRow("SELECT uuid FROM folder WHERE lower(title) = ? AND COALESCE(deleted, 0) = 0 LIMIT 1",
+		strings.ToLower(title)).Scan(&folderUUID)
+	if err == nil {
+		return folderUUID, nil
+	} else if err != sql.ErrNoRows {
+		return "", wrapBusy(err, "could not look up folder")
+	}
+
+	folderUUID = uuid.New().String()
+	if _, err := tx.Exec(`
+		INSERT INTO folder (uuid, title, icon, updated_at, deleted, parent_uuid)
+		VALUES (?, ?, '', ?, 0, '')
+	`, folderUUID, title, now); err != nil {
+		return "", wrapBusy(err, "could not create folder")
+	}
+	return folderUUID, nil
+}
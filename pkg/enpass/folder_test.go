@@ -0,0 +1,80 @@
+package enpass
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestVault_Folders(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+
+	folders, err := vault.ListFolders()
+	if err != nil {
+		t.Fatalf("ListFolders failed: %v", err)
+	}
+	if len(folders) != 0 {
+		t.Fatalf("expected no folders in the test vault, got %d", len(folders))
+	}
+
+	uuid, err := vault.CreateEntry(&EntryData{Title: "Filed", Password: "secret", Folders: []string{"Project A"}})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+
+	folders, _ = vault.ListFolders()
+	if len(folders) != 1 || folders[0].Title != "Project A" {
+		t.Fatalf("expected folder 'Project A', got %+v", folders)
+	}
+
+	// a subfolder of Project A, as created by the Enpass app
+	if _, err := vault.db.Exec(`INSERT INTO folder (uuid, title, updated_at, deleted, parent_uuid)
+		VALUES ('sub-folder', 'Staging', 0, 0, ?)`, folders[0].UUID); err != nil {
+		t.Fatalf("could not create subfolder: %v", err)
+	}
+	nested, err := vault.CreateEntry(&EntryData{Title: "Nested", Password: "secret", Folders: []string{"staging"}})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+
+	cards, err := vault.GetEntries("password", []string{"Filed"})
+	if err != nil || len(cards) != 1 {
+		t.Fatalf("GetEntries failed: %v", err)
+	}
+	if !reflect.DeepEqual(cards[0].Folders, []string{"Project A"}) {
+		t.Errorf("expected folders [Project A], got %v", cards[0].Folders)
+	}
+
+	vault.FilterFolders = []string{"project a"}
+	cards, _ = vault.GetEntries("password", nil)
+	if len(cards) != 2 {
+		t.Errorf("expected the entry and its subfolder entry, got %d cards", len(cards))
+	}
+	vault.FilterFolders = []string{"Staging"}
+	cards, _ = vault.GetEntries("password", nil)
+	if len(cards) != 1 || cards[0].UUID != nested {
+		t.Errorf("expected only the nested entry, got %d cards", len(cards))
+	}
+	vault.FilterFolders = nil
+
+	if err := vault.UpdateEntry(uuid, &EntryData{Folders: []string{"Project B"}}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+	cards, _ = vault.GetEntries("password", []string{"Filed"})
+	if !reflect.DeepEqual(cards[0].Folders, []string{"Project B"}) {
+		t.Errorf("expected folders [Project B], got %v", cards[0].Folders)
+	}
+
+	// updates without folders leave them alone
+	if err := vault.UpdateEntry(uuid, &EntryData{Title: "Filed Again"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+	cards, _ = vault.GetEntries("password", []string{"Filed Again"})
+	if !reflect.DeepEqual(cards[0].Folders, []string{"Project B"}) {
+		t.Errorf("expected folders [Project B], got %v", cards[0].Folders)
+	}
+}
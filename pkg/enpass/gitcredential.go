@@ -0,0 +1,337 @@
+package enpass
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GitCredential : the attributes exchanged with git by credential helpers,
+// see https://git-scm.com/docs/git-credential#IOFMT
+type GitCredential struct {
+	Protocol string
+	Host     string
+	// Path : only sent by git with credential.useHttpPath
+	Path     string
+	Username string
+	Password string
+}
+
+// gitCredentialMatch : an entry whose URL field matches a git credential query
+type gitCredentialMatch struct {
+	uuid   string
+	fields []Card
+	score  int
+}
+
+// ReadGitCredential : parse the key=value lines git writes to helpers, up to an empty line
+// or the end of r. A url attribute is split into its parts, unknown attributes are ignored.
+func ReadGitCredential(r io.Reader) (*GitCredential, error) {
+	cred := &GitCredential{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, errors.New("invalid credential line " + line + ": expected key=value")
+		}
+
+		switch key {
+		case "protocol":
+			cred.Protocol = value
+		case "host":
+			cred.Host = value
+		case "path":
+			cred.Path = value
+		case "username":
+			cred.Username = value
+		case "password":
+			cred.Password = value
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid credential url")
+			}
+			cred.Protocol, cred.Host, cred.Path = u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				cred.Username = u.User.Username()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read credential")
+	}
+	if cred.Protocol == "" || cred.Host == "" {
+		return nil, errors.New("credential needs a protocol and host")
+	}
+	return cred, nil
+}
+
+// Write : write the set attributes as key=value lines for git
+func (c *GitCredential) Write(w io.Writer) error {
+	for _, attr := range [][2]string{
+		{"protocol", c.Protocol}, {"host", c.Host}, {"path", c.Path},
+		{"username", c.Username}, {"password", c.Password},
+	} {
+		if attr[1] == "" {
+			continue
+		}
+		if strings.ContainsAny(attr[1], "\n\x00") {
+			return errors.New("credential " + attr[0] + " contains a newline or NUL byte")
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", attr[0], attr[1]); err != nil {
+			return errors.Wrap(err, "could not write credential")
+		}
+	}
+	return nil
+}
+
+// URL : the URL of the credential, as stored in the URL field of new entries
+func (c *GitCredential) URL() string {
+	u := url.URL{Scheme: c.Protocol, Host: c.Host}
+	if c.Path != "" {
+		u.Path = "/" + c.Path
+	}
+	return u.String()
+}
+
+// matchScore : how well the URL of an entry matches the credential, 0 when it doesn't.
+// URLs without a scheme are taken to be https. The path of the entry must be a prefix of the
+// requested path; longer prefixes are more specific. Without a requested path, entries for the
+// whole host are preferred.
+func (c *GitCredential) matchScore(entryURL string) int {
+	entryURL = strings.TrimSpace(entryURL)
+	if !strings.Contains(entryURL, "://") {
+		entryURL = "https://" + entryURL
+	}
+	u, err := url.Parse(entryURL)
+	if err != nil || !strings.EqualFold(u.Scheme, c.Protocol) || !strings.EqualFold(u.Host, c.Host) {
+		return 0
+	}
+
+	entryPath, path := gitPath(u.Path), gitPath(c.Path)
+	switch {
+	case entryPath == "":
+		return 2
+	case path == "":
+		// a path below the host still matches when git doesn't send one
+		return 1
+	case path == entryPath || strings.HasPrefix(path, entryPath+"/"):
+		return 3 + len(entryPath)
+	default:
+		return 0
+	}
+}
+
+func gitPath(path string) string {
+	return strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+}
+
+// findGitCredentials : the entries matching the credential, best match first. Entries
+// with another username than the credential's are left out.
+func (v *Vault) findGitCredentials(c *GitCredential) ([]gitCredentialMatch, error) {
+	urls, err := v.GetAllFields("url", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not retrieve URL fields")
+	}
+
+	best := map[string]int{}
+	order := make([]string, 0)
+	for _, field := range urls {
+		if field.IsTrashed() || field.IsDeleted() {
+			continue
+		}
+		score := c.matchScore(field.RawValue)
+		if score == 0 {
+			continue
+		}
+		if _, seen := best[field.UUID]; !seen {
+			order = append(order, field.UUID)
+		}
+		if score > best[field.UUID] {
+			best[field.UUID] = score
+		}
+	}
+
+	matches := make([]gitCredentialMatch, 0, len(order))
+	for _, entryUUID := range order {
+		fields, err := v.GetEntryFields(entryUUID)
+		if err != nil {
+			return nil, err
+		}
+		if c.Username != "" {
+			if username := gitUsername(fields); username != "" && username != c.Username {
+				continue
+			}
+		}
+		matches = append(matches, gitCredentialMatch{uuid: entryUUID, fields: fields, score: best[entryUUID]})
+	}
+
+	// stable, so equally good matches keep the order of the vault
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	return matches, nil
+}
+
+// bestGitCredential : the single best match, an error when several match equally well
+func (v *Vault) bestGitCredential(c *GitCredential) (*gitCredentialMatch, error) {
+	matches, err := v.findGitCredentials(c)
+	if err != nil || len(matches) == 0 {
+		return nil, err
+	}
+	if len(matches) > 1 && matches[1].score == matches[0].score {
+		ambiguous := &ErrAmbiguous{}
+		for _, match := range matches {
+			if match.score == matches[0].score {
+				ambiguous.Matches = append(ambiguous.Matches, match.fields[0])
+			}
+		}
+		return nil, errors.Wrap(ambiguous, "add a username or a path to the URL of "+c.URL())
+	}
+	return &matches[0], nil
+}
+
+// gitUsername : the username of an entry, or its email when it has none
+func gitUsername(fields []Card) string {
+	for _, fieldType := range []string{"username", "email"} {
+		for _, field := range fields {
+			if field.Type == fieldType && field.RawValue != "" {
+				return field.RawValue
+			}
+		}
+	}
+	return ""
+}
+
+// gitPassword : the decrypted password of an entry
+func gitPassword(fields []Card) (string, error) {
+	for _, field := range fields {
+		if field.Type == "password" && field.RawValue != "" {
+			return field.Decrypt()
+		}
+	}
+	return "", nil
+}
+
+// GetGitCredential : fill in the username and password of the entry whose URL field best
+// matches the credential. It returns nil when no entry matches.
+func (v *Vault) GetGitCredential(c *GitCredential) (*GitCredential, *Card, error) {
+	match, err := v.bestGitCredential(c)
+	if err != nil || match == nil {
+		return nil, nil, err
+	}
+
+	password, err := gitPassword(match.fields)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not decrypt password")
+	}
+	if password == "" {
+		return nil, nil, nil
+	}
+
+	found := *c
+	found.Username = gitUsername(match.fields)
+	found.Password = password
+	return &found, &match.fields[0], nil
+}
+
+// StoreGitCredential : save a credential git reports as working. The best matching entry is
+// updated when its username or password differs, otherwise a new entry titled after the host
+// is created. It returns the UUID of the entry.
+func (v *Vault) StoreGitCredential(c *GitCredential) (string, error) {
+	match, changed, err := v.gitStoreMatch(c)
+	if err != nil {
+		return "", err
+	}
+	if match == nil {
+		return v.CreateEntry(&EntryData{
+			Title:    c.Host,
+			Username: c.Username,
+			Password: c.Password,
+			URL:      c.URL(),
+		})
+	}
+	if !changed {
+		return match.uuid, nil
+	}
+
+	updates := &EntryData{Password: c.Password}
+	if gitUsername(match.fields) == "" {
+		updates.Username = c.Username
+	}
+	return match.uuid, v.UpdateEntry(match.uuid, updates)
+}
+
+// WouldStoreGitCredential : whether StoreGitCredential would write to the vault. It only
+// reads, so callers can keep the vault read-only when git stores a credential it already has.
+func (v *Vault) WouldStoreGitCredential(c *GitCredential) (bool, error) {
+	_, changed, err := v.gitStoreMatch(c)
+	return changed, err
+}
+
+// gitStoreMatch : the entry StoreGitCredential updates, nil when it creates one, and whether
+// the vault changes at all
+func (v *Vault) gitStoreMatch(c *GitCredential) (*gitCredentialMatch, bool, error) {
+	if c.Username == "" || c.Password == "" {
+		return nil, false, errors.New("credential needs a username and password to be stored")
+	}
+
+	match, err := v.bestGitCredential(c)
+	if err != nil {
+		return nil, false, err
+	}
+	if match == nil {
+		return nil, true, nil
+	}
+
+	password, err := gitPassword(match.fields)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "could not decrypt password")
+	}
+	return match, password != c.Password || gitUsername(match.fields) != c.Username, nil
+}
+
+// EraseGitCredential : trash the entry best matching a credential git reports as rejected.
+// When the credential has a password, only an entry with that password is trashed.
+// It returns the UUID of the trashed entry, or an empty string when none matched.
+func (v *Vault) EraseGitCredential(c *GitCredential) (string, error) {
+	match, err := v.gitEraseMatch(c)
+	if err != nil || match == nil {
+		return "", err
+	}
+	return match.uuid, v.TrashEntry(match.uuid)
+}
+
+// WouldEraseGitCredential : whether EraseGitCredential would trash an entry. It only reads.
+func (v *Vault) WouldEraseGitCredential(c *GitCredential) (bool, error) {
+	match, err := v.gitEraseMatch(c)
+	return match != nil, err
+}
+
+// gitEraseMatch : the entry EraseGitCredential trashes, nil when none
+func (v *Vault) gitEraseMatch(c *GitCredential) (*gitCredentialMatch, error) {
+	match, err := v.bestGitCredential(c)
+	if err != nil || match == nil {
+		return nil, err
+	}
+
+	if c.Password != "" {
+		password, err := gitPassword(match.fields)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not decrypt password")
+		}
+		if password != c.Password {
+			return nil, nil
+		}
+	}
+	return match, nil
+}
@@ -0,0 +1,171 @@
+package enpass
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadGitCredential(t *testing.T) {
+	cred, err := ReadGitCredential(strings.NewReader(
+		"capability[]=authtype\nprotocol=https\nhost=git.example.com:8443\npath=team/repo.git\nusername=bob\nwwwauth[]=Basic realm=\"git\"\n\nignored=after blank line\n"))
+	if err != nil {
+		t.Fatalf("ReadGitCredential failed: %v", err)
+	}
+	expected := GitCredential{Protocol: "https", Host: "git.example.com:8443", Path: "team/repo.git", Username: "bob"}
+	if *cred != expected {
+		t.Errorf("unexpected credential %+v", cred)
+	}
+
+	cred, err = ReadGitCredential(strings.NewReader("url=https://alice@git.example.com/team/repo.git\n"))
+	if err != nil {
+		t.Fatalf("ReadGitCredential failed: %v", err)
+	}
+	expected = GitCredential{Protocol: "https", Host: "git.example.com", Path: "team/repo.git", Username: "alice"}
+	if *cred != expected {
+		t.Errorf("unexpected credential from url %+v", cred)
+	}
+
+	for _, invalid := range []string{"protocol=https\n", "host=git.example.com\n", "protocol=https\nhost\n"} {
+		if _, err := ReadGitCredential(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected error reading %q", invalid)
+		}
+	}
+
+	var out bytes.Buffer
+	cred = &GitCredential{Protocol: "https", Host: "git.example.com", Username: "bob", Password: "secret"}
+	if err := cred.Write(&out); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if out.String() != "protocol=https\nhost=git.example.com\nusername=bob\npassword=secret\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	cred.Password = "multi\nline"
+	if err := cred.Write(&out); err == nil {
+		t.Error("expected error writing a password with a newline")
+	}
+}
+
+// gitCredentialTranscript feeds a request to the helper action and returns what it answers
+func gitCredentialTranscript(t *testing.T, vault *Vault, action string, request string) string {
+	t.Helper()
+	cred, err := ReadGitCredential(strings.NewReader(request))
+	if err != nil {
+		t.Fatalf("ReadGitCredential failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	switch action {
+	case "get":
+		found, _, err := vault.GetGitCredential(cred)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if found != nil {
+			if err := found.Write(&out); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+	case "store":
+		if _, err := vault.StoreGitCredential(cred); err != nil {
+			t.Fatalf("store failed: %v", err)
+		}
+	case "erase":
+		if _, err := vault.EraseGitCredential(cred); err != nil {
+			t.Fatalf("erase failed: %v", err)
+		}
+	}
+	return out.String()
+}
+
+func TestVault_GitCredential(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+
+	for _, entry := range []*EntryData{
+		{Title: "Forge", Username: "alice", Password: "alice-pw", URL: "https://git.example.com"},
+		{Title: "Forge team", Username: "bob", Password: "bob-pw", URL: "git.example.com/team/"},
+		{Title: "Plain HTTP", Username: "carol", Password: "carol-pw", URL: "http://legacy.example.com"},
+	} {
+		if _, err := vault.CreateEntry(entry); err != nil {
+			t.Fatalf("CreateEntry failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		request  string
+		expected string
+	}{
+		{"host", "protocol=https\nhost=git.example.com\n",
+			"protocol=https\nhost=git.example.com\nusername=alice\npassword=alice-pw\n"},
+		{"path", "protocol=https\nhost=git.example.com\npath=team/repo.git\n",
+			"protocol=https\nhost=git.example.com\npath=team/repo.git\nusername=bob\npassword=bob-pw\n"},
+		{"other path", "protocol=https\nhost=git.example.com\npath=teams/repo.git\n",
+			"protocol=https\nhost=git.example.com\npath=teams/repo.git\nusername=alice\npassword=alice-pw\n"},
+		{"username", "protocol=https\nhost=git.example.com\npath=team/repo.git\nusername=alice\n",
+			"protocol=https\nhost=git.example.com\npath=team/repo.git\nusername=alice\npassword=alice-pw\n"},
+		{"protocol", "protocol=https\nhost=legacy.example.com\n", ""},
+		{"http", "protocol=http\nhost=legacy.example.com\n",
+			"protocol=http\nhost=legacy.example.com\nusername=carol\npassword=carol-pw\n"},
+		{"unknown host", "protocol=https\nhost=example.com\n", ""},
+	}
+	for _, test := range tests {
+		if out := gitCredentialTranscript(t, vault, "get", test.request); out != test.expected {
+			t.Errorf("get %s: expected %q, got %q", test.name, test.expected, out)
+		}
+	}
+
+	// a new host creates an entry, a new password for a known one updates it
+	gitCredentialTranscript(t, vault, "store", "protocol=https\nhost=new.example.com\nusername=dave\npassword=dave-pw\n")
+	gitCredentialTranscript(t, vault, "store", "protocol=https\nhost=git.example.com\nusername=alice\npassword=rotated\n")
+	if out := gitCredentialTranscript(t, vault, "get", "protocol=https\nhost=new.example.com\n"); out != "protocol=https\nhost=new.example.com\nusername=dave\npassword=dave-pw\n" {
+		t.Errorf("unexpected stored credential %q", out)
+	}
+	if out := gitCredentialTranscript(t, vault, "get", "protocol=https\nhost=git.example.com\n"); !strings.HasSuffix(out, "password=rotated\n") {
+		t.Errorf("expected the updated password, got %q", out)
+	}
+	cards, err := vault.GetEntries("password", []string{"git.example.com"})
+	if err != nil {
+		t.Fatalf("GetEntries failed: %v", err)
+	}
+	if len(cards) != 0 {
+		t.Errorf("expected no entry titled after the known host, got %d", len(cards))
+	}
+
+	// storing or erasing what changes nothing needs no write
+	for _, test := range []struct {
+		name     string
+		would    func(*GitCredential) (bool, error)
+		request  string
+		expected bool
+	}{
+		{"store unchanged", vault.WouldStoreGitCredential, "protocol=https\nhost=git.example.com\nusername=alice\npassword=rotated\n", false},
+		{"store new password", vault.WouldStoreGitCredential, "protocol=https\nhost=git.example.com\nusername=alice\npassword=again\n", true},
+		{"store new host", vault.WouldStoreGitCredential, "protocol=https\nhost=other.example.com\nusername=alice\npassword=x\n", true},
+		{"erase other password", vault.WouldEraseGitCredential, "protocol=https\nhost=new.example.com\nusername=dave\npassword=other\n", false},
+		{"erase", vault.WouldEraseGitCredential, "protocol=https\nhost=new.example.com\nusername=dave\npassword=dave-pw\n", true},
+	} {
+		cred, err := ReadGitCredential(strings.NewReader(test.request))
+		if err != nil {
+			t.Fatalf("ReadGitCredential failed: %v", err)
+		}
+		if would, err := test.would(cred); err != nil || would != test.expected {
+			t.Errorf("%s: expected %v, got %v (%v)", test.name, test.expected, would, err)
+		}
+	}
+
+	// erasing needs the rejected password to match
+	gitCredentialTranscript(t, vault, "erase", "protocol=https\nhost=new.example.com\nusername=dave\npassword=other\n")
+	if out := gitCredentialTranscript(t, vault, "get", "protocol=https\nhost=new.example.com\n"); out == "" {
+		t.Error("expected the entry to survive erasing another password")
+	}
+	gitCredentialTranscript(t, vault, "erase", "protocol=https\nhost=new.example.com\nusername=dave\npassword=dave-pw\n")
+	if out := gitCredentialTranscript(t, vault, "get", "protocol=https\nhost=new.example.com\n"); out != "" {
+		t.Errorf("expected the erased entry to be trashed, got %q", out)
+	}
+}
@@ -0,0 +1,190 @@
+package enpass
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/publicsuffix"
+)
+
+// MatchKind : how the URL field of an entry matches a site, better matches are larger
+type MatchKind int
+
+const (
+	// MatchNone : the URL is of another site
+	MatchNone MatchKind = iota
+	// MatchDomain : the hosts share their registrable domain, e.g. login.example.com and www.example.com
+	MatchDomain
+	// MatchRule : the hosts belong to domains a URLRule treats as one site
+	MatchRule
+	// MatchHost : the hosts are equal
+	MatchHost
+)
+
+// String : the name of the match kind
+func (k MatchKind) String() string {
+	switch k {
+	case MatchDomain:
+		return "domain"
+	case MatchRule:
+		return "rule"
+	case MatchHost:
+		return "host"
+	default:
+		return "none"
+	}
+}
+
+// URLRule : domains that are one site when matching URLs, e.g. a company domain and the
+// domain of its single sign-on provider. Subdomains of the domains are included.
+type URLRule struct {
+	Domains []string
+}
+
+// URLMatch : an entry with a URL field matching a site
+type URLMatch struct {
+	// Card : the URL field that matches best
+	Card
+	Kind MatchKind
+	// PathPrefix : whether the path of the URL field is a prefix of the site's path
+	PathPrefix bool
+	// SameScheme : whether the URL field has the site's scheme
+	SameScheme bool
+}
+
+// siteURL : parse a URL as typed into a browser, where the scheme defaults to https
+func siteURL(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("URL has no host")
+	}
+	return u, nil
+}
+
+// registrableDomain : the domain below the public suffix, e.g. example.co.uk for
+// login.example.co.uk, or the host itself for IP addresses and hosts like localhost
+func registrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// inDomain : whether host is domain or one of its subdomains
+func inDomain(host string, domain string) bool {
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	return domain != "" && (host == domain || strings.HasSuffix(host, "."+domain))
+}
+
+// covers : whether the rule treats both hosts as one site
+func (r URLRule) covers(a string, b string) bool {
+	var coversA, coversB bool
+	for _, domain := range r.Domains {
+		coversA = coversA || inDomain(a, domain)
+		coversB = coversB || inDomain(b, domain)
+	}
+	return coversA && coversB
+}
+
+// matchURL : how well the URL of a field matches the site
+func matchURL(site *url.URL, fieldURL string, rules []URLRule) URLMatch {
+	u, err := siteURL(fieldURL)
+	if err != nil {
+		return URLMatch{}
+	}
+
+	host, fieldHost := strings.ToLower(site.Hostname()), strings.ToLower(u.Hostname())
+	var match URLMatch
+	switch {
+	case host == fieldHost:
+		match.Kind = MatchHost
+	case net.ParseIP(host) == nil && registrableDomain(host) == registrableDomain(fieldHost):
+		match.Kind = MatchDomain
+	}
+	for _, rule := range rules {
+		if match.Kind < MatchRule && rule.covers(host, fieldHost) {
+			match.Kind = MatchRule
+		}
+	}
+	if match.Kind == MatchNone {
+		return match
+	}
+
+	path, fieldPath := strings.TrimSuffix(site.Path, "/"), strings.TrimSuffix(u.Path, "/")
+	match.PathPrefix = fieldPath != "" && (path == fieldPath || strings.HasPrefix(path, fieldPath+"/"))
+	match.SameScheme = strings.EqualFold(site.Scheme, u.Scheme)
+	return match
+}
+
+// better : whether m ranks above other: by kind, then a matching path, then the scheme,
+// then the entry used most recently
+func (m URLMatch) better(other URLMatch) bool {
+	if m.Kind != other.Kind {
+		return m.Kind > other.Kind
+	}
+	if m.PathPrefix != other.PathPrefix {
+		return m.PathPrefix
+	}
+	if m.SameScheme != other.SameScheme {
+		return m.SameScheme
+	}
+	return m.LastUsed > other.LastUsed
+}
+
+// MatchURL : the entries with a url field matching the site, best match first. A field
+// matches when its host equals the site's, shares its registrable domain according to
+// the public suffix list, or when one of URLRules covers both hosts.
+func (v *Vault) MatchURL(site string) ([]URLMatch, error) {
+	siteU, err := siteURL(site)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid URL "+site)
+	}
+
+	fields, err := v.GetAllFields("url", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not retrieve URL fields")
+	}
+
+	best := map[string]URLMatch{}
+	order := make([]string, 0)
+	for _, field := range fields {
+		if field.IsTrashed() || field.IsDeleted() || field.RawValue == "" {
+			continue
+		}
+		match := matchURL(siteU, field.RawValue, v.URLRules)
+		if match.Kind == MatchNone {
+			continue
+		}
+		match.Card = field
+		existing, seen := best[field.UUID]
+		if !seen {
+			order = append(order, field.UUID)
+		}
+		if !seen || match.better(existing) {
+			best[field.UUID] = match
+		}
+	}
+
+	matches := make([]URLMatch, 0, len(order))
+	for _, entryUUID := range order {
+		matches = append(matches, best[entryUUID])
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].better(matches[j])
+	})
+	return matches, nil
+}
@@ -0,0 +1,89 @@
+package enpass
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMatchURL(t *testing.T) {
+	site, err := siteURL("https://login.corp.example.com/sso/start")
+	if err != nil {
+		t.Fatalf("siteURL failed: %v", err)
+	}
+	rules := []URLRule{{Domains: []string{"corp.example.com", "example.okta.com"}}}
+
+	tests := map[string]URLMatch{
+		"https://login.corp.example.com":      {Kind: MatchHost, SameScheme: true},
+		"login.corp.example.com/sso":          {Kind: MatchHost, PathPrefix: true, SameScheme: true},
+		"http://LOGIN.corp.example.com:8080/": {Kind: MatchHost},
+		"https://www.example.com":             {Kind: MatchDomain, SameScheme: true},
+		"https://corp.example.com/ssologin":   {Kind: MatchRule, SameScheme: true},
+		"https://other.example.com":           {Kind: MatchDomain, SameScheme: true},
+		"https://example.okta.com/app":        {Kind: MatchRule, SameScheme: true},
+		"https://okta.com":                    {},
+		"https://example.co.uk":               {},
+		"not a url ://":                       {},
+	}
+	for fieldURL, expected := range tests {
+		match := matchURL(site, fieldURL, rules)
+		if match.Kind != expected.Kind || match.PathPrefix != expected.PathPrefix || match.SameScheme != expected.SameScheme {
+			t.Errorf("matchURL(%s) = %s %v %v, expected %s %v %v", fieldURL,
+				match.Kind, match.PathPrefix, match.SameScheme, expected.Kind, expected.PathPrefix, expected.SameScheme)
+		}
+	}
+
+	// hosts below a public suffix don't share their registrable domain
+	site, _ = siteURL("alice.github.io")
+	if match := matchURL(site, "https://bob.github.io", nil); match.Kind != MatchNone {
+		t.Errorf("expected no match between github.io pages, got %s", match.Kind)
+	}
+	site, _ = siteURL("http://192.168.1.1/admin")
+	if match := matchURL(site, "192.168.1.2", nil); match.Kind != MatchNone {
+		t.Errorf("expected no match between IP addresses, got %s", match.Kind)
+	}
+}
+
+func TestVault_MatchURL(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+
+	for _, entry := range []*EntryData{
+		{Title: "Corporate", Password: "a", URL: "https://corp.example.com"},
+		{Title: "Login", Password: "b", URL: "https://login.corp.example.com"},
+		{Title: "Okta", Password: "c", URL: "https://example.okta.com"},
+		{Title: "Elsewhere", Password: "d", URL: "https://example.org"},
+	} {
+		if _, err := vault.CreateEntry(entry); err != nil {
+			t.Fatalf("CreateEntry failed: %v", err)
+		}
+	}
+
+	matches, err := vault.MatchURL("https://login.corp.example.com/path")
+	if err != nil {
+		t.Fatalf("MatchURL failed: %v", err)
+	}
+	if len(matches) != 2 || matches[0].Title != "Login" || matches[1].Title != "Corporate" {
+		t.Fatalf("unexpected matches %+v", matches)
+	}
+
+	vault.URLRules = []URLRule{{Domains: []string{"example.com", "example.okta.com"}}}
+	matches, err = vault.MatchURL("login.corp.example.com")
+	if err != nil {
+		t.Fatalf("MatchURL failed: %v", err)
+	}
+	titles := []string{}
+	for _, m := range matches {
+		titles = append(titles, m.Title+":"+m.Kind.String())
+	}
+	// equally good matches may come in any order
+	if len(titles) != 3 || titles[0] != "Login:host" || titles[1]+titles[2] != "Corporate:ruleOkta:rule" && titles[1]+titles[2] != "Okta:ruleCorporate:rule" {
+		t.Errorf("unexpected matches with rules %v", titles)
+	}
+
+	if _, err := vault.MatchURL("https://"); err == nil {
+		t.Error("expected error matching a URL without host")
+	}
+}
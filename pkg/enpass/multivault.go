@@ -0,0 +1,149 @@
+package enpass
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// CredentialsFunc : returns the credentials to open a vault with. It is called once per vault
+// and must return a new VaultCredentials every time, since opening a vault stores its key in them.
+type CredentialsFunc func(vault *Vault) *VaultCredentials
+
+// SharedCredentials : use the same password and keyfile for every vault
+func SharedCredentials(password string, keyfilePath string) CredentialsFunc {
+	return func(*Vault) *VaultCredentials {
+		return &VaultCredentials{Password: password, KeyfilePath: keyfilePath}
+	}
+}
+
+// MultiVault : a set of opened vaults that are searched together.
+// Every returned Card carries the name of its vault in Card.VaultName.
+type MultiVault struct {
+	vaults []*Vault
+}
+
+// NewMultiVault : combine already opened vaults
+func NewMultiVault(vaults ...*Vault) *MultiVault {
+	return &MultiVault{vaults: vaults}
+}
+
+// OpenMultiVault : create and open read-only a vault for each path
+func OpenMultiVault(vaultPaths []string, logLevel logrus.Level, credentials CredentialsFunc) (*MultiVault, error) {
+	m := &MultiVault{vaults: make([]*Vault, 0, len(vaultPaths))}
+	for _, vaultPath := range vaultPaths {
+		vault, err := NewVault(vaultPath, logLevel)
+		if err != nil {
+			m.Close()
+			return nil, errors.Wrap(err, "could not create vault "+vaultPath)
+		}
+		if err := vault.OpenReadOnly(credentials(vault)); err != nil {
+			vault.Close()
+			m.Close()
+			return nil, errors.Wrap(err, "could not open vault "+vault.vaultInfo.VaultName)
+		}
+		m.vaults = append(m.vaults, vault)
+	}
+	return m, nil
+}
+
+// Vaults : the vaults that are searched
+func (m *MultiVault) Vaults() []*Vault {
+	return m.vaults
+}
+
+// SetFilterAnd : set FilterAnd on every vault
+func (m *MultiVault) SetFilterAnd(and bool) {
+	for _, vault := range m.vaults {
+		vault.FilterAnd = and
+	}
+}
+
+// SetFilterFolders : set FilterFolders on every vault
+func (m *MultiVault) SetFilterFolders(folders []string) {
+	for _, vault := range m.vaults {
+		vault.FilterFolders = folders
+	}
+}
+
+// Close : close every vault
+func (m *MultiVault) Close() {
+	for _, vault := range m.vaults {
+		vault.Close()
+	}
+}
+
+// GetEntries : like Vault.GetEntries, across all vaults
+func (m *MultiVault) GetEntries(cardType string, filters []string) ([]Card, error) {
+	return m.collect(func(vault *Vault) ([]Card, error) {
+		return vault.GetEntries(cardType, filters)
+	})
+}
+
+// GetAllFields : like Vault.GetAllFields, across all vaults
+func (m *MultiVault) GetAllFields(cardType string, filters []string) ([]Card, error) {
+	return m.collect(func(vault *Vault) ([]Card, error) {
+		return vault.GetAllFields(cardType, filters)
+	})
+}
+
+// GetEntry : like Vault.GetEntry, across all vaults. With unique set, a match
+// in more than one vault is an error just like several matches in one vault.
+func (m *MultiVault) GetEntry(cardType string, filters []string, unique bool) (*Card, error) {
+	var matches []Card
+	for _, vault := range m.vaults {
+		card, err := vault.GetEntry(cardType, filters, unique)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrap(err, "could not search vault "+vault.vaultInfo.VaultName)
+		}
+
+		matches = append(matches, *card)
+		if !unique {
+			break
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, ErrNotFound
+	} else if len(matches) > 1 {
+		return nil, &ErrAmbiguous{Matches: matches}
+	}
+	return &matches[0], nil
+}
+
+// Resolve : like Vault.Resolve, in the vault the reference names or, without one, in every vault
+func (m *MultiVault) Resolve(reference string) (*Card, error) {
+	ref, err := ParseRef(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vault := range m.vaults {
+		if !ref.matchesVault(vault.vaultInfo) {
+			continue
+		}
+		card, err := vault.Resolve(reference)
+		if errors.Is(err, ErrNotFound) && ref.Vault == "" {
+			continue
+		}
+		return card, err
+	}
+	return nil, errors.Wrap(ErrNotFound, "could not resolve "+reference+": no such vault or entry")
+}
+
+func (m *MultiVault) collect(query func(vault *Vault) ([]Card, error)) ([]Card, error) {
+	if len(m.vaults) == 0 {
+		return nil, errors.New("no vaults to search")
+	}
+
+	cards := make([]Card, 0)
+	for _, vault := range m.vaults {
+		vaultCards, err := query(vault)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not search vault "+vault.vaultInfo.VaultName)
+		}
+		cards = append(cards, vaultCards...)
+	}
+	return cards, nil
+}
@@ -0,0 +1,82 @@
+package enpass
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func copyNamedTestVault(t *testing.T, name string) string {
+	t.Helper()
+	tmpDir := copyTestVault(t)
+	info, _ := os.ReadFile(filepath.Join(tmpDir, "vault.json"))
+	info = []byte(strings.Replace(string(info), `"DummyVault"`, `"`+name+`"`, 1))
+	os.WriteFile(filepath.Join(tmpDir, "vault.json"), info, 0600)
+	return tmpDir
+}
+
+func TestMultiVault_GetAllFields(t *testing.T) {
+	personal := copyNamedTestVault(t, "Personal")
+	defer os.RemoveAll(personal)
+	team := copyNamedTestVault(t, "Team")
+	defer os.RemoveAll(team)
+
+	multi, err := OpenMultiVault([]string{personal, team}, logrus.ErrorLevel, SharedCredentials(testPassword, ""))
+	if err != nil {
+		t.Fatalf("OpenMultiVault failed: %v", err)
+	}
+	defer multi.Close()
+
+	cards, err := multi.GetAllFields("password", []string{"Whatever"})
+	if err != nil {
+		t.Fatalf("GetAllFields failed: %v", err)
+	}
+	vaultNames := map[string]int{}
+	for _, card := range cards {
+		vaultNames[card.VaultName]++
+	}
+	if len(vaultNames) != 2 || vaultNames["Personal"] == 0 || vaultNames["Team"] == 0 {
+		t.Errorf("expected results from both vaults, got %v", vaultNames)
+	}
+
+	entries, err := multi.GetEntries("password", []string{"Whatever"})
+	if err != nil {
+		t.Fatalf("GetEntries failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(entries))
+	}
+
+	if _, err := multi.GetEntry("password", []string{"Whatever"}, true); err == nil {
+		t.Error("GetEntry should fail when the entry exists in both vaults")
+	}
+	card, err := multi.GetEntry("password", []string{"Whatever"}, false)
+	if err != nil || card.VaultName != "Personal" {
+		t.Errorf("expected the first vault's entry, got %v, %v", card, err)
+	}
+	if _, err := multi.GetEntry("password", []string{"inexistent"}, true); err == nil {
+		t.Error("GetEntry should fail when nothing matches")
+	}
+}
+
+func TestOpenMultiVault_WrongPassword(t *testing.T) {
+	personal := copyNamedTestVault(t, "Personal")
+	defer os.RemoveAll(personal)
+	team := copyNamedTestVault(t, "Team")
+	defer os.RemoveAll(team)
+
+	credentials := func(vault *Vault) *VaultCredentials {
+		if vault.Info().VaultName == "Team" {
+			return &VaultCredentials{Password: "wrong"}
+		}
+		return &VaultCredentials{Password: testPassword}
+	}
+	if _, err := OpenMultiVault([]string{personal, team}, logrus.ErrorLevel, credentials); err == nil {
+		t.Fatal("OpenMultiVault should fail when a vault can't be opened")
+	} else if !strings.Contains(err.Error(), "Team") {
+		t.Errorf("error should name the failing vault: %v", err)
+	}
+}
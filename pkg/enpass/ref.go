@@ -0,0 +1,174 @@
+package enpass
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// RefScheme : scheme of references to entry fields
+	RefScheme = "enpass"
+	refPrefix = RefScheme + "://"
+)
+
+// Ref : a stable reference to one field of an entry, enpass://<vault>/<item-uuid>/<field>.
+// Unlike title filters, a reference keeps pointing at the same field when entries are renamed
+// or similar entries are added.
+type Ref struct {
+	// Vault : UUID or name of the vault; empty matches any vault
+	Vault string
+	// ItemUUID : UUID of the entry
+	ItemUUID string
+	// Field : item_field_uid, label or type of the field
+	Field string
+}
+
+// IsRef : whether s looks like a reference rather than a filter
+func IsRef(s string) bool {
+	return strings.HasPrefix(s, refPrefix)
+}
+
+// ParseRef : parse enpass://<vault>/<item-uuid>/<field>. The vault may be left out as in
+// enpass://<item-uuid>/<field>. Segments are URL path escaped.
+func ParseRef(s string) (Ref, error) {
+	if !IsRef(s) {
+		return Ref{}, errors.New("not an " + refPrefix + " reference: " + s)
+	}
+
+	segments := strings.Split(strings.TrimPrefix(s, refPrefix), "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return Ref{}, errors.Wrap(err, "invalid reference "+s)
+		}
+		segments[i] = unescaped
+	}
+
+	var ref Ref
+	switch len(segments) {
+	case 2:
+		ref = Ref{ItemUUID: segments[0], Field: segments[1]}
+	case 3:
+		ref = Ref{Vault: segments[0], ItemUUID: segments[1], Field: segments[2]}
+	default:
+		return Ref{}, errors.New("invalid reference " + s + ": expected " + refPrefix + "<vault>/<item-uuid>/<field>")
+	}
+	if ref.ItemUUID == "" || ref.Field == "" {
+		return Ref{}, errors.New("invalid reference " + s + ": empty item or field")
+	}
+	return ref, nil
+}
+
+// String : the reference as enpass://<vault>/<item-uuid>/<field>
+func (r Ref) String() string {
+	segments := []string{r.ItemUUID, r.Field}
+	if r.Vault != "" {
+		segments = append([]string{r.Vault}, segments...)
+	}
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return refPrefix + strings.Join(segments, "/")
+}
+
+// matchesVault : whether the reference may point into the vault
+func (r Ref) matchesVault(info VaultInfo) bool {
+	return r.Vault == "" || r.Vault == info.VaultUUID || strings.EqualFold(r.Vault, info.VaultName)
+}
+
+// Resolve : return the field a reference points to. Decrypt the returned Card for its value.
+func (v *Vault) Resolve(reference string) (*Card, error) {
+	ref, err := ParseRef(reference)
+	if err != nil {
+		return nil, err
+	}
+	if !ref.matchesVault(v.vaultInfo) {
+		return nil, errors.New("reference " + reference + " points to another vault than " + v.vaultInfo.VaultName)
+	}
+
+	fields, err := v.GetEntryFields(ref.ItemUUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not resolve "+reference)
+	}
+	if fields[0].IsTrashed() || fields[0].IsDeleted() {
+		return nil, errors.Wrap(ErrDeleted, "could not resolve "+reference)
+	}
+
+	if card := findField(fields, ref.Field); card != nil {
+		return card, nil
+	}
+	return nil, errors.Wrap(ErrNotFound, "could not resolve "+reference+": entry has no field "+ref.Field)
+}
+
+// findField : the field with the given item_field_uid, label or type, in that order of preference
+func findField(fields []Card, field string) *Card {
+	if uid, err := strconv.ParseInt(field, 10, 64); err == nil && uid >= 0 {
+		for i := range fields {
+			if fields[i].FieldUID == uid {
+				return &fields[i]
+			}
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].Label, field) {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].Type, field) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// Ref : the most stable reference to a field returned by this vault. The field is named by
+// its item_field_uid when that is unique within the entry, then by its label, then by its type.
+func (v *Vault) Ref(card *Card) (Ref, error) {
+	fields, err := v.GetEntryFields(card.UUID)
+	if err != nil {
+		return Ref{}, errors.Wrap(err, "could not build reference")
+	}
+
+	vault := v.vaultInfo.VaultUUID
+	if vault == "" {
+		vault = v.vaultInfo.VaultName
+	}
+	ref := Ref{Vault: vault, ItemUUID: card.UUID}
+
+	candidates := []string{card.Label, card.Type}
+	if card.FieldUID >= 0 {
+		candidates = append([]string{strconv.FormatInt(card.FieldUID, 10)}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		// the field must be the one the reference resolves to, and no other
+		// field may claim the same name
+		if found := findField(fields, candidate); found != nil && sameField(found, card) && uniqueField(fields, candidate) {
+			ref.Field = candidate
+			return ref, nil
+		}
+	}
+	return Ref{}, errors.New("the field has no unique uid, label or type to reference it by")
+}
+
+func sameField(a *Card, b *Card) bool {
+	return a.UUID == b.UUID && a.FieldUID == b.FieldUID && a.Label == b.Label && a.Type == b.Type && a.RawValue == b.RawValue
+}
+
+// uniqueField : whether exactly one field has the uid, label or type
+func uniqueField(fields []Card, field string) bool {
+	matches := 0
+	uid, uidErr := strconv.ParseInt(field, 10, 64)
+	for _, f := range fields {
+		if (uidErr == nil && f.FieldUID == uid) || strings.EqualFold(f.Label, field) || strings.EqualFold(f.Type, field) {
+			matches++
+		}
+	}
+	return matches == 1
+}
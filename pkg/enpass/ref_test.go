@@ -0,0 +1,122 @@
+package enpass
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const testItemUUID = "489e13cc-3dea-40a9-b883-2bd61f2f4f48"
+
+func TestParseRef(t *testing.T) {
+	ref, err := ParseRef("enpass://DummyVault/" + testItemUUID + "/Access%20Key")
+	if err != nil {
+		t.Fatalf("ParseRef failed: %v", err)
+	}
+	if ref.Vault != "DummyVault" || ref.ItemUUID != testItemUUID || ref.Field != "Access Key" {
+		t.Errorf("unexpected reference %+v", ref)
+	}
+	if ref.String() != "enpass://DummyVault/"+testItemUUID+"/Access%20Key" {
+		t.Errorf("unexpected string form %s", ref.String())
+	}
+
+	ref, err = ParseRef("enpass://" + testItemUUID + "/password")
+	if err != nil || ref.Vault != "" || ref.Field != "password" {
+		t.Errorf("expected reference without vault, got %+v, %v", ref, err)
+	}
+
+	for _, invalid := range []string{"github.com", "enpass://", "enpass://a/b/c/d", "enpass://vault//field"} {
+		if _, err := ParseRef(invalid); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestVault_Resolve(t *testing.T) {
+	vault := openTestVault(t, vaultPath, true)
+	defer vault.Close()
+
+	tests := map[string]string{
+		"enpass://24b18ce1-6e6a-40d7-a584-123ae9e2996c/" + testItemUUID + "/11": "password",
+		"enpass://dummyvault/" + testItemUUID + "/Port%20No.":                   "Port No.",
+		"enpass://" + testItemUUID + "/url":                                     "url",
+	}
+	for reference, expected := range tests {
+		card, err := vault.Resolve(reference)
+		if err != nil {
+			t.Errorf("Resolve(%s) failed: %v", reference, err)
+			continue
+		}
+		if !strings.EqualFold(card.Label, expected) && card.Type != expected {
+			t.Errorf("Resolve(%s) returned field %q (%s)", reference, card.Label, card.Type)
+		}
+	}
+
+	password, err := vault.Resolve("enpass://" + testItemUUID + "/11")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if decrypted, err := password.Decrypt(); err != nil || decrypted != "noIdeaata11" {
+		t.Errorf("unexpected password %q, %v", decrypted, err)
+	}
+
+	if _, err := vault.Resolve("enpass://OtherVault/" + testItemUUID + "/11"); err == nil {
+		t.Error("expected error resolving a reference to another vault")
+	}
+	for _, reference := range []string{
+		"enpass://" + testItemUUID + "/nonexistent",
+		"enpass://00000000-0000-0000-0000-000000000000/password",
+	} {
+		if _, err := vault.Resolve(reference); !IsNotFound(err) {
+			t.Errorf("expected ErrNotFound resolving %s, got %v", reference, err)
+		}
+	}
+}
+
+func TestVault_Ref(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+
+	card, err := vault.GetEntry("password", []string{"Whatever"}, true)
+	if err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+	ref, err := vault.Ref(card)
+	if err != nil {
+		t.Fatalf("Ref failed: %v", err)
+	}
+	if ref.String() != "enpass://24b18ce1-6e6a-40d7-a584-123ae9e2996c/"+testItemUUID+"/11" {
+		t.Errorf("unexpected reference %s", ref)
+	}
+
+	// entries created by the CLI have no field uids, their fields are referenced by type
+	uuid, err := vault.CreateEntry(&EntryData{Title: "Renamed later", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	created, err := vault.GetEntryByUUID(uuid)
+	if err != nil {
+		t.Fatalf("GetEntryByUUID failed: %v", err)
+	}
+	ref, err = vault.Ref(created)
+	if err != nil {
+		t.Fatalf("Ref failed: %v", err)
+	}
+	if ref.Field != "password" {
+		t.Errorf("expected the password field to be referenced by type, got %q", ref.Field)
+	}
+
+	if err := vault.UpdateEntry(uuid, &EntryData{Title: "New title"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+	resolved, err := vault.Resolve(ref.String())
+	if err != nil {
+		t.Fatalf("Resolve after rename failed: %v", err)
+	}
+	if resolved.Title != "New title" {
+		t.Errorf("expected the renamed entry, got %q", resolved.Title)
+	}
+}
@@ -0,0 +1,211 @@
+package enpass
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// KnownVaultVersion : the vault.json version this package was written against
+	KnownVaultVersion = 6
+)
+
+// requiredColumns : the columns entries can't be read without
+var requiredColumns = map[string][]string{
+	"item": {"uuid", "created_at", "field_updated_at", "title", "subtitle", "note",
+		"trashed", "deleted", "category", "key"},
+	"itemfield": {"item_uuid", "type", "label", "value", "deleted", "sensitive"},
+}
+
+// optionalColumns : columns older or newer schemas may lack, and the value read instead
+var optionalColumns = map[string]string{
+	"item.icon":                "''",
+	"item.last_used":           "0",
+	"item.meta_updated_at":     "0",
+	"item.updated_at":          "0",
+	"item.favorite":            "0",
+	"item.archived":            "0",
+	"item.usage_count":         "0",
+	"item.template":            "''",
+	"itemfield.item_field_uid": "-1",
+	"itemfield.orde":           "itemfield.ID",
+}
+
+// ErrUnsupportedSchema : the database lacks columns entries can't be read without,
+// e.g. because Enpass changed its schema
+type ErrUnsupportedSchema struct {
+	// VaultVersion : the version of vault.json
+	VaultVersion int
+	// Missing : the missing columns as table.column
+	Missing []string
+}
+
+func (e *ErrUnsupportedSchema) Error() string {
+	return fmt.Sprintf("unsupported schema of vault version %d, missing %s", e.VaultVersion, strings.Join(e.Missing, ", "))
+}
+
+// SchemaInfo : what opening the vault found out about its database
+type SchemaInfo struct {
+	// VaultVersion : the version of vault.json, see KnownVaultVersion
+	VaultVersion int `json:"vault_version"`
+	// CipherCompatibility : the SQLCipher major version whose page size, KDF and HMAC settings
+	// the database uses
+	CipherCompatibility int `json:"cipher_compatibility"`
+	// CipherVersion : the version of the SQLCipher library
+	CipherVersion string `json:"cipher_version"`
+	// Columns : the columns of the item and itemfield tables
+	Columns map[string][]string `json:"columns"`
+	// MissingColumns : optional columns the database lacks as table.column, read as defaults
+	MissingColumns []string `json:"missing_columns,omitempty"`
+}
+
+// hasColumn : whether the database has the table.column
+func (s *SchemaInfo) hasColumn(name string) bool {
+	table, column, _ := strings.Cut(name, ".")
+	for _, c := range s.Columns[table] {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// Schema : what opening the vault found out about its database
+func (v *Vault) Schema() SchemaInfo {
+	if v.schema == nil {
+		return SchemaInfo{VaultVersion: v.vaultInfo.VaultVersion}
+	}
+	return *v.schema
+}
+
+// hasColumn : whether the database has the optional table.column, assumed before the schema is read
+func (v *Vault) hasColumn(name string) bool {
+	return v.schema == nil || v.schema.hasColumn(name)
+}
+
+// column : the qualified optional column for queries, or the value read instead when the database lacks it
+func (v *Vault) column(name string) string {
+	if !v.hasColumn(name) {
+		return optionalColumns[name]
+	}
+	return name
+}
+
+// requireColumns : ErrUnsupportedSchema when the database lacks optional columns a write can't do without
+func (v *Vault) requireColumns(names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if !v.hasColumn(name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return &ErrUnsupportedSchema{VaultVersion: v.vaultInfo.VaultVersion, Missing: missing}
+	}
+	return nil
+}
+
+// readSchema : record the columns of the opened database, failing with ErrUnsupportedSchema
+// when required ones are missing
+func (v *Vault) readSchema() error {
+	schema := &SchemaInfo{
+		VaultVersion:        v.vaultInfo.VaultVersion,
+		CipherCompatibility: v.cipherVersion,
+		Columns:             make(map[string][]string),
+	}
+	if err := v.db.QueryRow("PRAGMA cipher_version").Scan(&schema.CipherVersion); err != nil {
+		return wrapBusy(err, "could not read SQLCipher version")
+	}
+	// libraries older than the settings asked for ignore cipher_compatibility and use their own
+	if major, err := strconv.Atoi(strings.SplitN(schema.CipherVersion, ".", 2)[0]); err == nil && major < schema.CipherCompatibility {
+		schema.CipherCompatibility = major
+	}
+
+	var missing []string
+	for table, required := range requiredColumns {
+		columns, err := tableColumns(v.db, table)
+		if err != nil {
+			return err
+		}
+		for column := range columns {
+			schema.Columns[table] = append(schema.Columns[table], column)
+		}
+		sort.Strings(schema.Columns[table])
+		for _, column := range required {
+			if _, found := columns[column]; !found {
+				missing = append(missing, table+"."+column)
+			}
+		}
+	}
+	for name := range optionalColumns {
+		if !schema.hasColumn(name) {
+			schema.MissingColumns = append(schema.MissingColumns, name)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(schema.MissingColumns)
+	v.schema = schema
+
+	if len(missing) > 0 {
+		return &ErrUnsupportedSchema{VaultVersion: schema.VaultVersion, Missing: missing}
+	}
+	if schema.VaultVersion != KnownVaultVersion {
+		v.logger.WithField("vault_version", schema.VaultVersion).Warn("vault version is unknown, reading it anyway")
+	}
+	if len(schema.MissingColumns) > 0 {
+		v.logger.WithField("missing", schema.MissingColumns).Debug("database lacks optional columns")
+	}
+	return nil
+}
+
+// IntegrityCheck : the problems SQLite's integrity_check finds in the database, none when it is intact
+func (v *Vault) IntegrityCheck() ([]string, error) {
+	if v.db == nil {
+		return nil, ErrNotInitialized
+	}
+	rows, err := v.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, wrapBusy(err, "could not check database integrity")
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return nil, errors.Wrap(err, "could not read integrity check")
+		}
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	return problems, errors.Wrap(rows.Err(), "error iterating database rows")
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// tableColumns : the lower-cased column names of a table
+func tableColumns(q queryer, table string) (map[string]struct{}, error) {
+	rows, err := q.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, wrapBusy(err, "could not read columns of "+table)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]struct{})
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "could not read columns of "+table)
+		}
+		columns[strings.ToLower(name)] = struct{}{}
+	}
+	return columns, errors.Wrap(rows.Err(), "error iterating database rows")
+}
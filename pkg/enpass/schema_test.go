@@ -0,0 +1,149 @@
+package enpass
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// dropColumns : rebuild a table of the vault at path without the given columns,
+// like a schema of another Enpass version would look
+func dropColumns(t *testing.T, path string, table string, drop ...string) {
+	t.Helper()
+	vault := openTestVault(t, path, false)
+	defer vault.Close()
+
+	columns, err := tableColumns(vault.db, table)
+	if err != nil {
+		t.Fatalf("could not read columns: %v", err)
+	}
+	for _, column := range drop {
+		delete(columns, column)
+	}
+	kept := make([]string, 0, len(columns))
+	for column := range columns {
+		kept = append(kept, column)
+	}
+
+	for _, statement := range []string{
+		"CREATE TABLE rebuilt AS SELECT " + strings.Join(kept, ", ") + " FROM " + table,
+		"DROP TABLE " + table,
+		"ALTER TABLE rebuilt RENAME TO " + table,
+	} {
+		if _, err := vault.db.Exec(statement); err != nil {
+			t.Fatalf("could not rebuild %s: %v", table, err)
+		}
+	}
+}
+
+func TestVault_Schema(t *testing.T) {
+	vault := openTestVault(t, "../../test", true)
+	defer vault.Close()
+
+	schema := vault.Schema()
+	if schema.VaultVersion != KnownVaultVersion || schema.CipherCompatibility == 0 || schema.CipherVersion == "" {
+		t.Errorf("unexpected schema info %+v", schema)
+	}
+	if len(schema.MissingColumns) != 0 {
+		t.Errorf("expected no missing columns, got %v", schema.MissingColumns)
+	}
+	if !schema.hasColumn("itemfield.orde") || schema.hasColumn("itemfield.nonexistent") {
+		t.Errorf("unexpected itemfield columns %v", schema.Columns["itemfield"])
+	}
+
+	problems, err := vault.IntegrityCheck()
+	if err != nil || len(problems) != 0 {
+		t.Errorf("expected an intact database, got %v %v", problems, err)
+	}
+}
+
+func TestVault_SchemaMissingOptionalColumns(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	dropColumns(t, tmpDir, "item", "icon", "usage_count")
+	dropColumns(t, tmpDir, "itemfield", "orde")
+
+	vault := openTestVault(t, tmpDir, true)
+	defer vault.Close()
+
+	missing := strings.Join(vault.Schema().MissingColumns, ",")
+	if missing != "item.icon,item.usage_count,itemfield.orde" {
+		t.Errorf("unexpected missing columns %s", missing)
+	}
+
+	card, err := vault.GetEntry("password", []string{"Whatever"}, true)
+	if err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+	if password, err := card.Decrypt(); err != nil || password != "noIdeaata11" {
+		t.Errorf("expected the password to decrypt, got %q %v", password, err)
+	}
+}
+
+// writes and the queries behind them read missing optional columns as defaults, and
+// writes that can't do without one fail with ErrUnsupportedSchema
+func TestVault_SchemaMissingOptionalColumns_Write(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	dropColumns(t, tmpDir, "item", "icon", "last_used", "meta_updated_at", "updated_at", "favorite", "usage_count", "template")
+	dropColumns(t, tmpDir, "itemfield", "item_field_uid")
+
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+
+	if card, err := vault.GetEntryByUUID(testItemUUID); err != nil || card.Title != "Whatever" || card.FieldUID != -1 {
+		t.Errorf("expected the entry with defaults, got %+v %v", card, err)
+	}
+	if items, err := vault.readWatchedItems(); err != nil || len(items) != 1 {
+		t.Errorf("expected the watched entry, got %v %v", items, err)
+	}
+
+	entryUUID, err := vault.CreateEntry(&EntryData{Title: "New", Username: "me", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	if _, err := vault.GetEntryByUUID(entryUUID); err != nil {
+		t.Fatalf("GetEntryByUUID failed: %v", err)
+	}
+	if err := vault.UpdateEntry(entryUUID, &EntryData{Password: "changed"}); err != nil {
+		t.Errorf("UpdateEntry failed: %v", err)
+	}
+	if err := vault.ArchiveEntry(entryUUID); err != nil {
+		t.Errorf("ArchiveEntry failed: %v", err)
+	}
+	if err := vault.TrashEntry(entryUUID); err != nil {
+		t.Errorf("TrashEntry failed: %v", err)
+	}
+
+	var schemaErr *ErrUnsupportedSchema
+	if err := vault.FavoriteEntry(testItemUUID); !errors.As(err, &schemaErr) || strings.Join(schemaErr.Missing, ",") != "item.favorite" {
+		t.Errorf("expected ErrUnsupportedSchema marking a favorite, got %v", err)
+	}
+	if err := vault.RecordUsage(testItemUUID); !errors.As(err, &schemaErr) || strings.Join(schemaErr.Missing, ",") != "item.last_used,item.usage_count" {
+		t.Errorf("expected ErrUnsupportedSchema recording usage, got %v", err)
+	}
+}
+
+func TestVault_SchemaUnsupported(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	dropColumns(t, tmpDir, "itemfield", "value")
+
+	vault, err := NewVault(tmpDir, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("NewVault failed: %v", err)
+	}
+	defer vault.Close()
+
+	err = vault.OpenReadOnly(&VaultCredentials{Password: testPassword})
+	var schemaErr *ErrUnsupportedSchema
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("expected ErrUnsupportedSchema, got %v", err)
+	}
+	if schemaErr.VaultVersion != KnownVaultVersion || len(schemaErr.Missing) != 1 || schemaErr.Missing[0] != "itemfield.value" {
+		t.Errorf("unexpected error details %+v", schemaErr)
+	}
+}
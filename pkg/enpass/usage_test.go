@@ -0,0 +1,105 @@
+package enpass
+
+import (
+	"os"
+	"testing"
+)
+
+func TestVault_RecordUsage(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+
+	uuid, err := vault.CreateEntry(&EntryData{Title: "Used", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	before, err := vault.GetEntryByUUID(uuid)
+	if err != nil {
+		t.Fatalf("GetEntryByUUID failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := vault.RecordUsage(uuid); err != nil {
+			t.Fatalf("RecordUsage failed: %v", err)
+		}
+	}
+
+	after, err := vault.GetEntryByUUID(uuid)
+	if err != nil {
+		t.Fatalf("GetEntryByUUID failed: %v", err)
+	}
+	if after.UsageCount != before.UsageCount+2 {
+		t.Errorf("expected usage count %d, got %d", before.UsageCount+2, after.UsageCount)
+	}
+	if after.LastUsed == 0 {
+		t.Error("expected last_used to be set")
+	}
+	if after.itemUpdatedAt != before.itemUpdatedAt {
+		t.Error("recording usage must not count as an edit")
+	}
+
+	// recording usage must not make later edits conflict
+	if err := vault.TrashEntry(uuid); err != nil {
+		t.Errorf("TrashEntry after RecordUsage failed: %v", err)
+	}
+
+	if err := vault.RecordUsage("nonexistent-uuid"); err == nil {
+		t.Error("expected error recording usage of nonexistent entry")
+	}
+
+	readOnly := openTestVault(t, tmpDir, true)
+	defer readOnly.Close()
+	if err := readOnly.RecordUsage(uuid); err == nil {
+		t.Error("expected error recording usage in a read-only vault")
+	}
+}
+
+func TestVault_ArchiveAndFavorite(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+
+	vault := openTestVault(t, tmpDir, false)
+	defer vault.Close()
+
+	uuid, err := vault.CreateEntry(&EntryData{Title: "Flagged", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+
+	if err := vault.ArchiveEntry(uuid); err != nil {
+		t.Fatalf("ArchiveEntry failed: %v", err)
+	}
+	if err := vault.FavoriteEntry(uuid); err != nil {
+		t.Fatalf("FavoriteEntry failed: %v", err)
+	}
+
+	cards, err := vault.GetEntries("password", []string{"Flagged"})
+	if err != nil || len(cards) != 1 {
+		t.Fatalf("GetEntries failed: %v", err)
+	}
+	if !cards[0].IsArchived() || !cards[0].IsFavorite() {
+		t.Errorf("expected archived favorite, got archived=%d favorite=%d", cards[0].Archived, cards[0].Favorite)
+	}
+
+	if err := vault.UnarchiveEntry(uuid); err != nil {
+		t.Fatalf("UnarchiveEntry failed: %v", err)
+	}
+	if err := vault.UnfavoriteEntry(uuid); err != nil {
+		t.Fatalf("UnfavoriteEntry failed: %v", err)
+	}
+
+	card, err := vault.GetEntryByUUID(uuid)
+	if err != nil {
+		t.Fatalf("GetEntryByUUID failed: %v", err)
+	}
+	if card.IsArchived() || card.IsFavorite() {
+		t.Errorf("expected neither archived nor favorite, got archived=%d favorite=%d", card.Archived, card.Favorite)
+	}
+
+	if err := vault.ArchiveEntry("nonexistent-uuid"); err == nil {
+		t.Error("expected error archiving nonexistent entry")
+	}
+}
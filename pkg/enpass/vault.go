@@ -4,12 +4,15 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	// sqlcipher is necessary for sqlite crypto support
-	_ "github.com/mutecomm/go-sqlcipher"
+	sqlite3 "github.com/mutecomm/go-sqlcipher"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -29,6 +32,10 @@ type Vault struct {
 	// settings for filtering entries
 	FilterFields []string
 	FilterAnd    bool
+	// only return items filed in one of these folders or their subfolders
+	FilterFolders []string
+	// URLRules : domains MatchURL treats as one site
+	URLRules []URLRule
 
 	// vault.enpassdb : SQLCipher database
 	databaseFilename string
@@ -42,6 +49,29 @@ type Vault struct {
 	// pointer to our opened database
 	db *sql.DB
 
+	// set when the database was opened through OpenReadOnly
+	readOnly bool
+
+	// raw hex key of the opened database, needed to write encrypted backups
+	hexKey string
+	// SQLCipher compatibility version the database was opened with
+	cipherVersion int
+
+	// columns of the opened database, read by readSchema
+	schema *SchemaInfo
+
+	// BusyTimeout : how long to wait for locks held by other connections, e.g. the desktop app
+	BusyTimeout time.Duration
+
+	// timestamps of the items as they were last read, for optimistic concurrency on writes
+	stampsMu sync.Mutex
+	stamps   map[string]itemStamp
+
+	// Immutable : tell SQLite the database file cannot change while it is
+	// open when opening read-only. Only set this for snapshots nobody else
+	// writes to, never for a vault the desktop app is syncing live.
+	Immutable bool
+
 	// vault.json : contains info about your vault for synchronizing
 	vaultInfo VaultInfo
 }
@@ -61,6 +91,7 @@ func NewVault(vaultPath string, logLevel logrus.Level) (*Vault, error) {
 	v := Vault{
 		logger:       *logrus.New(),
 		FilterFields: []string{"title", "subtitle"},
+		BusyTimeout:  defaultBusyTimeout,
 	}
 	v.logger.SetLevel(logLevel)
 
@@ -98,14 +129,7 @@ func (v *Vault) openEncryptedDatabase(path string, dbKey []byte) (err error) {
 
 	// Try SQLCipher v4 first (Enpass 6.8+), then fall back to v3 for older databases
 	for _, cipherVersion := range []int{4, 3} {
-		dbName := fmt.Sprintf(
-			"%s?_pragma_key=x'%s'&_pragma_cipher_compatibility=%d",
-			path,
-			hexKey,
-			cipherVersion,
-		)
-
-		v.db, err = sql.Open("sqlite3", dbName)
+		v.db, err = sql.Open("sqlite3", v.databaseDSN(path, hexKey, cipherVersion))
 		if err != nil {
 			v.logger.WithError(err).WithField("cipher_version", cipherVersion).Debug("could not open database")
 			continue
@@ -121,19 +145,58 @@ func (v *Vault) openEncryptedDatabase(path string, dbKey []byte) (err error) {
 		}
 
 		v.logger.WithField("cipher_version", cipherVersion).Debug("successfully opened database")
+		v.hexKey = hexKey
+		v.cipherVersion = cipherVersion
 		return nil
 	}
 
-	return errors.New("could not open database: invalid password or unsupported database version")
+	// with the wrong key SQLCipher can't tell the file from random bytes, any other
+	// error (busy, unreadable file, ...) is kept as it is
+	if isNotADatabase(err) {
+		return errors.Wrap(ErrWrongPassword, "could not open database")
+	}
+	return wrapBusy(err, "could not open database")
+}
+
+func isNotADatabase(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrNotADB
+}
+
+// databaseDSN : build the SQLite URI for the vault database. Using a file: URI
+// lets SQLite itself enforce read-only access through the mode parameter.
+func (v *Vault) databaseDSN(path string, hexKey string, cipherVersion int) string {
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+
+	params := []string{
+		fmt.Sprintf("_pragma_key=x'%s'", hexKey),
+		fmt.Sprintf("_pragma_cipher_compatibility=%d", cipherVersion),
+		fmt.Sprintf("_busy_timeout=%d", v.BusyTimeout.Milliseconds()),
+	}
+	if v.readOnly {
+		params = append(params, "mode=ro")
+		if v.Immutable {
+			params = append(params, "immutable=1")
+		}
+	} else {
+		// take the write lock when a transaction starts instead of upgrading a read
+		// lock halfway through, which SQLite can only resolve by failing with SQLITE_BUSY
+		params = append(params, "mode=rw", "_txlock=immediate")
+	}
+
+	uri := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return uri.String() + "?" + strings.Join(params, "&")
 }
 
 func (v *Vault) checkPaths() error {
 	if _, err := os.Stat(v.databaseFilename); os.IsNotExist(err) {
-		return errors.New("vault does not exist: " + v.databaseFilename)
+		return errors.Wrap(ErrNoVault, "missing "+v.databaseFilename)
 	}
 
 	if _, err := os.Stat(v.vaultInfoFilename); os.IsNotExist(err) {
-		return errors.New("vault info file does not exist: " + v.vaultInfoFilename)
+		return errors.Wrap(ErrNoVault, "missing "+v.vaultInfoFilename)
 	}
 
 	return nil
@@ -150,9 +213,9 @@ func (v *Vault) generateAndSetDBKey(credentials *VaultCredentials) error {
 	}
 
 	if credentials.KeyfilePath == "" && v.vaultInfo.HasKeyfile == 1 {
-		return errors.New("you should specify a keyfile")
+		return ErrKeyfileRequired
 	} else if credentials.KeyfilePath != "" && v.vaultInfo.HasKeyfile == 0 {
-		return errors.New("you are specifying an unnecessary keyfile")
+		return ErrKeyfileNotNeeded
 	}
 
 	v.logger.Debug("generating master password")
@@ -176,14 +239,31 @@ func (v *Vault) generateAndSetDBKey(credentials *VaultCredentials) error {
 	return nil
 }
 
-// Open : setup a connection to the Enpass database. Call this before doing anything.
+// Open : setup a read-write connection to the Enpass database. Call this before doing anything.
 func (v *Vault) Open(credentials *VaultCredentials) error {
+	v.readOnly = false
+	return v.open(credentials)
+}
+
+// OpenReadOnly : setup a connection to the Enpass database that can never write to it.
+// Use this for commands that only read, so a vault synced by the desktop app is left untouched.
+func (v *Vault) OpenReadOnly(credentials *VaultCredentials) error {
+	v.readOnly = true
+	return v.open(credentials)
+}
+
+// IsReadOnly : whether the vault was opened through OpenReadOnly
+func (v *Vault) IsReadOnly() bool {
+	return v.readOnly
+}
+
+func (v *Vault) open(credentials *VaultCredentials) error {
 	v.logger.Debug("generating database key")
 	if err := v.generateAndSetDBKey(credentials); err != nil {
 		return errors.Wrap(err, "could not generate database key")
 	}
 
-	v.logger.Debug("opening encrypted database")
+	v.logger.WithField("read_only", v.readOnly).Debug("opening encrypted database")
 	if err := v.openEncryptedDatabase(v.databaseFilename, credentials.DBKey); err != nil {
 		return errors.Wrap(err, "could not open encrypted database")
 	}
@@ -195,18 +275,21 @@ func (v *Vault) Open(credentials *VaultCredentials) error {
 		WHERE type='table' AND name='item'
 	`).Scan(&tableName)
 	if err != nil {
-		return errors.Wrap(err, "could not connect to database")
+		return wrapBusy(err, "could not connect to database")
 	} else if tableName != "item" {
 		return errors.New("could not connect to database")
 	}
 
-	return nil
+	return v.readSchema()
 }
 
 // Close : close the connection to the underlying database. Always call this in the end.
 func (v *Vault) Close() {
 	if v.db != nil {
 		err := v.db.Close()
+		v.db = nil
+		v.hexKey = ""
+		v.schema = nil
 		v.logger.WithError(err).Debug("closed vault")
 	}
 }
@@ -217,12 +300,12 @@ func (v *Vault) Close() {
 // by UUID, preferring the sensitive field (typically the password).
 func (v *Vault) GetEntries(cardType string, filters []string) ([]Card, error) {
 	if v.db == nil || v.vaultInfo.VaultName == "" {
-		return nil, errors.New("vault is not initialized")
+		return nil, ErrNotInitialized
 	}
 
 	rows, err := v.executeEntryQuery(cardType, filters)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not retrieve cards from database")
+		return nil, wrapBusy(err, "could not retrieve cards from database")
 	}
 	defer rows.Close()
 
@@ -230,19 +313,12 @@ func (v *Vault) GetEntries(cardType string, filters []string) ([]Card, error) {
 	cardMap := make(map[string]Card)
 
 	for rows.Next() {
-		var card Card
-
 		// read the database columns into Card object
-		if err := rows.Scan(
-			&card.UUID, &card.Type, &card.CreatedAt, &card.UpdatedAt, &card.Title,
-			&card.Subtitle, &card.Note, &card.Trashed, &card.Deleted, &card.Category,
-			&card.Label, &card.value, &card.itemKey, &card.LastUsed, &card.Sensitive, &card.Icon,
-		); err != nil {
+		card, err := v.scanCard(rows)
+		if err != nil {
 			return nil, errors.Wrap(err, "could not read card from database")
 		}
 
-		card.RawValue = card.value
-
 		// Deduplicate by UUID: prefer sensitive fields (passwords) over non-sensitive ones
 		if existing, found := cardMap[card.UUID]; found {
 			// Keep the new card if it's sensitive and the existing one isn't
@@ -265,6 +341,10 @@ func (v *Vault) GetEntries(cardType string, filters []string) ([]Card, error) {
 		cards = append(cards, card)
 	}
 
+	if err := v.attachFolders(cards); err != nil {
+		return nil, err
+	}
+
 	return cards, nil
 }
 
@@ -275,26 +355,21 @@ func (v *Vault) GetEntries(cardType string, filters []string) ([]Card, error) {
 // GetEntries when the caller wants one Card per entry.
 func (v *Vault) GetAllFields(cardType string, filters []string) ([]Card, error) {
 	if v.db == nil || v.vaultInfo.VaultName == "" {
-		return nil, errors.New("vault is not initialized")
+		return nil, ErrNotInitialized
 	}
 
 	rows, err := v.executeEntryQuery(cardType, filters)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not retrieve cards from database")
+		return nil, wrapBusy(err, "could not retrieve cards from database")
 	}
 	defer rows.Close()
 
 	cards := make([]Card, 0)
 	for rows.Next() {
-		var card Card
-		if err := rows.Scan(
-			&card.UUID, &card.Type, &card.CreatedAt, &card.UpdatedAt, &card.Title,
-			&card.Subtitle, &card.Note, &card.Trashed, &card.Deleted, &card.Category,
-			&card.Label, &card.value, &card.itemKey, &card.LastUsed, &card.Sensitive, &card.Icon,
-		); err != nil {
+		card, err := v.scanCard(rows)
+		if err != nil {
 			return nil, errors.Wrap(err, "could not read card from database")
 		}
-		card.RawValue = card.value
 		cards = append(cards, card)
 	}
 
@@ -302,6 +377,45 @@ func (v *Vault) GetAllFields(cardType string, filters []string) ([]Card, error)
 		return nil, errors.Wrap(err, "error iterating database rows")
 	}
 
+	if err := v.attachFolders(cards); err != nil {
+		return nil, err
+	}
+
+	return cards, nil
+}
+
+// GetEntryFields : return every field of the entry with the given UUID, like GetAllFields does
+func (v *Vault) GetEntryFields(entryUUID string) ([]Card, error) {
+	if v.db == nil || v.vaultInfo.VaultName == "" {
+		return nil, ErrNotInitialized
+	}
+
+	rows, err := v.executeEntryQuery("", nil, entryUUID)
+	if err != nil {
+		return nil, wrapBusy(err, "could not retrieve entry from database")
+	}
+	defer rows.Close()
+
+	cards := make([]Card, 0)
+	for rows.Next() {
+		card, err := v.scanCard(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read card from database")
+		}
+		cards = append(cards, card)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating database rows")
+	}
+	if len(cards) == 0 {
+		return nil, ErrNotFound
+	}
+
+	if err := v.attachFolders(cards); err != nil {
+		return nil, err
+	}
+
 	return cards, nil
 }
 
@@ -311,31 +425,65 @@ func (v *Vault) GetEntry(cardType string, filters []string, unique bool) (*Card,
 		return nil, errors.Wrap(err, "could not retrieve cards")
 	}
 
-	var ret *Card
+	var matches []Card
 	for _, card := range cards {
 		if card.IsTrashed() || card.IsDeleted() {
 			continue
-		} else if ret == nil {
-			ret = &card
-		} else if unique {
-			return nil, errors.New("multiple cards match that title")
-		} else {
+		}
+		matches = append(matches, card)
+		if !unique {
 			break
 		}
 	}
 
-	if ret == nil {
-		return nil, errors.New("card not found")
+	if len(matches) == 0 {
+		return nil, ErrNotFound
+	} else if len(matches) > 1 {
+		return nil, &ErrAmbiguous{Matches: matches}
 	}
 
-	return ret, nil
+	return &matches[0], nil
+}
+
+// cardScanner : the subset of *sql.Row and *sql.Rows needed to read a card
+type cardScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (v *Vault) executeEntryQuery(cardType string, filters []string) (*sql.Rows, error) {
+// scanCard : read one row of an entry query into a Card and remember its timestamps
+func (v *Vault) scanCard(row cardScanner) (Card, error) {
+	var card Card
+	if err := row.Scan(
+		&card.UUID, &card.Type, &card.CreatedAt, &card.UpdatedAt, &card.Title,
+		&card.Subtitle, &card.Note, &card.Trashed, &card.Deleted, &card.Category,
+		&card.Label, &card.value, &card.itemKey, &card.LastUsed, &card.Sensitive, &card.Icon,
+		&card.metaUpdatedAt, &card.itemUpdatedAt,
+		&card.Favorite, &card.Archived, &card.UsageCount, &card.Template, &card.FieldUID,
+	); err != nil {
+		return Card{}, err
+	}
+	card.RawValue = card.value
+	card.VaultName = v.vaultInfo.VaultName
+	v.rememberStamp(&card)
+	return card, nil
+}
+
+// cardColumns : the columns of item and itemfield scanCard reads, optional ones read as
+// defaults when the schema of the vault lacks them
+func (v *Vault) cardColumns() string {
+	return `item.uuid, itemfield.type, item.created_at, item.field_updated_at, item.title,
+		item.subtitle, item.note, item.trashed, item.deleted, item.category,
+		itemfield.label, itemfield.value, item.key, COALESCE(` + v.column("item.last_used") + `, 0),
+		itemfield.sensitive, ` + v.column("item.icon") + `,
+		COALESCE(` + v.column("item.meta_updated_at") + `, 0), COALESCE(` + v.column("item.updated_at") + `, 0),
+		COALESCE(` + v.column("item.favorite") + `, 0), COALESCE(` + v.column("item.archived") + `, 0),
+		COALESCE(` + v.column("item.usage_count") + `, 0), COALESCE(` + v.column("item.template") + `, ''),
+		COALESCE(` + v.column("itemfield.item_field_uid") + `, -1)`
+}
+
+func (v *Vault) executeEntryQuery(cardType string, filters []string, itemUUIDs ...string) (*sql.Rows, error) {
 	query := `
-		SELECT uuid, type, created_at, field_updated_at, title,
-		       subtitle, note, trashed, item.deleted, category,
-		       label, value, key, last_used, sensitive, item.icon
+		SELECT ` + v.cardColumns() + `
 		FROM item
 		INNER JOIN itemfield ON uuid = item_uuid
 	`
@@ -348,6 +496,21 @@ func (v *Vault) executeEntryQuery(cardType string, filters []string) (*sql.Rows,
 		values = append(values, cardType)
 	}
 
+	if len(itemUUIDs) > 0 {
+		placeholders := make([]string, 0, len(itemUUIDs))
+		for _, itemUUID := range itemUUIDs {
+			placeholders = append(placeholders, "?")
+			values = append(values, itemUUID)
+		}
+		where = append(where, "item.uuid IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	if len(v.FilterFolders) > 0 {
+		folderWhere, folderValues := folderFilterQuery(v.FilterFolders)
+		where = append(where, folderWhere)
+		values = append(values, folderValues...)
+	}
+
 	filterWhere := []string{}
 	for _, filter := range filters {
 		fq := "(0"
@@ -373,7 +536,7 @@ func (v *Vault) executeEntryQuery(cardType string, filters []string) (*sql.Rows,
 	// order and sections (added later by edits) often drift to the end.
 	// We group by item_uuid first so each entry's fields stay contiguous when
 	// the grouping pass in the CLI builds entry views.
-	query += " ORDER BY item.uuid, itemfield.orde"
+	query += " ORDER BY item.uuid, " + v.column("itemfield.orde")
 	v.logger.Trace("query: ", query)
 	return v.db.Query(query, values...)
 }
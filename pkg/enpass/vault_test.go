@@ -1,6 +1,9 @@
 package enpass
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/sirupsen/logrus"
@@ -116,3 +119,73 @@ func Assert_GetEntries(t *testing.T, vault *Vault, filters []string, expectedCou
 		t.Error("wrong number of entries returned")
 	}
 }
+
+func TestVault_OpenReadOnly(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "vault.enpassdb")
+	before, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("could not read vault: %v", err)
+	}
+
+	vault, err := NewVault(tmpDir, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("vault initialization failed: %+v", err)
+	}
+	credentials := &VaultCredentials{Password: testPassword}
+	if err := vault.OpenReadOnly(credentials); err != nil {
+		t.Fatalf("opening vault read-only failed: %+v", err)
+	}
+
+	if !vault.IsReadOnly() {
+		t.Error("vault should report being read-only")
+	}
+	Assert_GetEntries(t, vault, []string{"Whatever"}, 1)
+
+	if _, err := vault.CreateEntry(&EntryData{Title: "Nope", Password: "nope"}); err == nil {
+		t.Error("CreateEntry should fail on a read-only vault")
+	}
+	if _, err := vault.db.Exec("UPDATE item SET title = 'changed'"); err == nil {
+		t.Error("raw writes should be rejected by SQLite on a read-only vault")
+	}
+	vault.Close()
+
+	after, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("could not read vault: %v", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("vault database changed while opened read-only")
+	}
+}
+
+func TestVault_GetEntryFields(t *testing.T) {
+	vault, err := NewVault(vaultPath, logrus.ErrorLevel)
+	if err != nil {
+		t.Fatalf("vault initialization failed: %+v", err)
+	}
+	defer vault.Close()
+	credentials := &VaultCredentials{Password: testPassword}
+	if err := vault.OpenReadOnly(credentials); err != nil {
+		t.Fatalf("opening vault failed: %+v", err)
+	}
+
+	all, err := vault.GetAllFields("", []string{"Whatever"})
+	if err != nil || len(all) == 0 {
+		t.Fatalf("GetAllFields failed: %v", err)
+	}
+
+	fields, err := vault.GetEntryFields(all[0].UUID)
+	if err != nil {
+		t.Fatalf("GetEntryFields failed: %v", err)
+	}
+	if len(fields) != len(all) {
+		t.Errorf("expected %d fields, got %d", len(all), len(fields))
+	}
+
+	if _, err := vault.GetEntryFields("nonexistent-uuid"); err == nil {
+		t.Error("expected error for nonexistent entry")
+	}
+}
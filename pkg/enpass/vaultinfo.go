@@ -14,9 +14,15 @@ type VaultInfo struct {
 	KDFIterations  int    `json:"kdf_iter"`
 	VaultNumItems  int    `json:"vault_items_count"`
 	VaultName      string `json:"vault_name"`
+	VaultUUID      string `json:"vault_uuid"`
 	VaultVersion   int    `json:"version"`
 }
 
+// Info : the vault info loaded from vault.json
+func (v *Vault) Info() VaultInfo {
+	return v.vaultInfo
+}
+
 // loadVaultInfo : the vault info file dictates how we should decrypt the vault database
 func (v *Vault) loadVaultInfo() (VaultInfo, error) {
 	vaultInfoBytes, err := os.ReadFile(v.vaultInfoFilename)
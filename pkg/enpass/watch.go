@@ -0,0 +1,216 @@
+package enpass
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+const (
+	// how long Watch waits for writes to settle before reading the vault again
+	watchSettleDelay = 250 * time.Millisecond
+)
+
+// EventType : what happened to an entry between two reads of the vault
+type EventType string
+
+const (
+	// EventCreated : the entry was added to the vault
+	EventCreated EventType = "created"
+	// EventUpdated : the entry was modified, or restored from the trash
+	EventUpdated EventType = "updated"
+	// EventTrashed : the entry was moved to the trash
+	EventTrashed EventType = "trashed"
+	// EventDeleted : the entry was removed from the vault
+	EventDeleted EventType = "deleted"
+)
+
+// ChangeEvent : a change of an entry noticed by Watch
+type ChangeEvent struct {
+	Event    EventType `json:"event"`
+	UUID     string    `json:"uuid"`
+	Title    string    `json:"title"`
+	Subtitle string    `json:"subtitle"`
+	Category string    `json:"category"`
+	// UpdatedAt : updated_at of the entry as Unix time, 0 for deleted entries
+	UpdatedAt int64     `json:"updated_at"`
+	Time      time.Time `json:"time"`
+}
+
+// watchedItem : the state of an item Watch compares between two reads
+type watchedItem struct {
+	title    string
+	subtitle string
+	category string
+	trashed  bool
+	stamp    itemStamp
+}
+
+// Watch : send an event on the returned channel for every entry created, updated,
+// trashed or deleted after Watch was called, by this or any other application.
+// The database file is watched with inotify (or the platform's equivalent) and
+// read again through a new connection once writes settle, so a vault replaced by
+// a sync client is picked up as well. Errors reading it are logged and retried
+// on the next change. The channel is closed when ctx is done.
+func (v *Vault) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	if v.db == nil || v.hexKey == "" {
+		return nil, ErrNotInitialized
+	}
+
+	items, err := v.readWatchedItems()
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create file watcher")
+	}
+	// watch the directory, sync clients replace the database file instead of writing to it
+	if err := watcher.Add(filepath.Dir(v.databaseFilename)); err != nil {
+		watcher.Close()
+		return nil, errors.Wrap(err, "could not watch vault directory")
+	}
+
+	events := make(chan ChangeEvent)
+	go func() {
+		defer close(events)
+		defer watcher.Close()
+
+		settle := time.NewTimer(watchSettleDelay)
+		settle.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if v.isDatabaseWrite(event) {
+					settle.Reset(watchSettleDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				v.logger.WithError(err).Warn("file watcher failed")
+			case <-settle.C:
+				current, err := v.readWatchedItems()
+				if err != nil {
+					v.logger.WithError(err).Warn("could not read vault after change, waiting for the next one")
+					continue
+				}
+				for _, event := range compareWatchedItems(items, current, time.Now()) {
+					select {
+					case events <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+				items = current
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// isDatabaseWrite : whether a file event changed the database or its journal
+func (v *Vault) isDatabaseWrite(event fsnotify.Event) bool {
+	if !strings.HasPrefix(filepath.Base(event.Name), vaultFileName) || strings.HasSuffix(event.Name, "-shm") {
+		return false
+	}
+	return event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove)
+}
+
+// readWatchedItems : read the state of every item through a new read-only connection,
+// which sees the database file even when it was replaced since the vault was opened
+func (v *Vault) readWatchedItems() (map[string]watchedItem, error) {
+	reader := Vault{readOnly: true, BusyTimeout: v.BusyTimeout}
+	db, err := sql.Open("sqlite3", reader.databaseDSN(v.databaseFilename, v.hexKey, v.cipherVersion))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open database")
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT uuid, COALESCE(title, ''), COALESCE(subtitle, ''), COALESCE(category, ''), COALESCE(trashed, 0),
+		       COALESCE(` + v.column("item.meta_updated_at") + `, 0), COALESCE(field_updated_at, 0),
+		       COALESCE(` + v.column("item.updated_at") + `, 0)
+		FROM item
+		WHERE COALESCE(deleted, 0) = 0
+	`)
+	if err != nil {
+		return nil, wrapBusy(err, "could not read entries")
+	}
+	defer rows.Close()
+
+	items := make(map[string]watchedItem)
+	for rows.Next() {
+		var itemUUID string
+		var item watchedItem
+		var trashed int64
+		if err := rows.Scan(&itemUUID, &item.title, &item.subtitle, &item.category, &trashed,
+			&item.stamp.metaUpdatedAt, &item.stamp.fieldUpdatedAt, &item.stamp.updatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not read entry")
+		}
+		item.trashed = trashed != 0
+		items[itemUUID] = item
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapBusy(err, "error iterating database rows")
+	}
+	return items, nil
+}
+
+// compareWatchedItems : the events that turn the previous state of the items into
+// the current one, ordered by UUID
+func compareWatchedItems(previous, current map[string]watchedItem, now time.Time) []ChangeEvent {
+	var events []ChangeEvent
+	for itemUUID, item := range current {
+		event := ChangeEvent{
+			UUID:      itemUUID,
+			Title:     item.title,
+			Subtitle:  item.subtitle,
+			Category:  item.category,
+			UpdatedAt: item.stamp.updatedAt,
+			Time:      now,
+		}
+		old, existed := previous[itemUUID]
+		switch {
+		case !existed:
+			event.Event = EventCreated
+		case item.trashed && !old.trashed:
+			event.Event = EventTrashed
+		case item != old:
+			event.Event = EventUpdated
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+	for itemUUID, item := range previous {
+		if _, exists := current[itemUUID]; !exists {
+			events = append(events, ChangeEvent{
+				Event:    EventDeleted,
+				UUID:     itemUUID,
+				Title:    item.title,
+				Subtitle: item.subtitle,
+				Category: item.category,
+				Time:     now,
+			})
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].UUID < events[j].UUID
+	})
+	return events
+}
@@ -0,0 +1,101 @@
+package enpass
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// nextEvent : the next event of a watch, failing the test when none arrives in time
+func nextEvent(t *testing.T, events <-chan ChangeEvent) ChangeEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("watch stopped")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event within 5 seconds")
+	}
+	return ChangeEvent{}
+}
+
+func TestVault_Watch(t *testing.T) {
+	tmpDir := copyTestVault(t)
+	defer os.RemoveAll(tmpDir)
+	watched := openTestVault(t, tmpDir, true)
+	defer watched.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := watched.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	// another application changes the vault
+	writer := openTestVault(t, tmpDir, false)
+	defer writer.Close()
+
+	entryUUID, err := writer.CreateEntry(&EntryData{Title: "Rotated", Password: "first"})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	if event := nextEvent(t, events); event.Event != EventCreated || event.UUID != entryUUID || event.Title != "Rotated" {
+		t.Errorf("expected a created event, got %+v", event)
+	}
+
+	if err := writer.UpdateEntry(entryUUID, &EntryData{Password: "second"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+	if event := nextEvent(t, events); event.Event != EventUpdated || event.UUID != entryUUID || event.UpdatedAt == 0 {
+		t.Errorf("expected an updated event, got %+v", event)
+	}
+
+	if err := writer.TrashEntry(entryUUID); err != nil {
+		t.Fatalf("TrashEntry failed: %v", err)
+	}
+	if event := nextEvent(t, events); event.Event != EventTrashed || event.UUID != entryUUID {
+		t.Errorf("expected a trashed event, got %+v", event)
+	}
+
+	if err := writer.DeleteEntry(entryUUID); err != nil {
+		t.Fatalf("DeleteEntry failed: %v", err)
+	}
+	if event := nextEvent(t, events); event.Event != EventDeleted || event.UUID != entryUUID || event.Title != "Rotated" {
+		t.Errorf("expected a deleted event, got %+v", event)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestCompareWatchedItems(t *testing.T) {
+	now := time.Now()
+	previous := map[string]watchedItem{
+		"a": {title: "A", stamp: itemStamp{updatedAt: 1}},
+		"b": {title: "B", stamp: itemStamp{updatedAt: 1}},
+		"c": {title: "C", stamp: itemStamp{updatedAt: 1}},
+		"d": {title: "D", trashed: true, stamp: itemStamp{updatedAt: 1}},
+	}
+	current := map[string]watchedItem{
+		"a": {title: "A", stamp: itemStamp{updatedAt: 1}},
+		"b": {title: "B", trashed: true, stamp: itemStamp{updatedAt: 2}},
+		"d": {title: "D", stamp: itemStamp{updatedAt: 2}},
+		"e": {title: "E", stamp: itemStamp{updatedAt: 2}},
+	}
+
+	events := compareWatchedItems(previous, current, now)
+	expected := []EventType{EventTrashed, EventDeleted, EventUpdated, EventCreated}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), events)
+	}
+	for i, event := range events {
+		if event.Event != expected[i] || !event.Time.Equal(now) {
+			t.Errorf("expected event %d to be %s, got %+v", i, expected[i], event)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package enpass
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,12 +16,26 @@ type EntryData struct {
 	URL      string
 	Notes    string
 	Category string
+	// Folders : titles of the folders to file the entry in, created when missing.
+	// Nil leaves the folders of an existing entry unchanged.
+	Folders []string
+}
+
+// checkWritable returns an error when the vault can't be modified
+func (v *Vault) checkWritable() error {
+	if v.db == nil {
+		return ErrNotInitialized
+	}
+	if v.readOnly {
+		return ErrReadOnly
+	}
+	return nil
 }
 
 // CreateEntry creates a new password entry in the vault
 func (v *Vault) CreateEntry(entry *EntryData) (string, error) {
-	if v.db == nil {
-		return "", errors.New("vault is not initialized")
+	if err := v.checkWritable(); err != nil {
+		return "", err
 	}
 
 	if entry.Title == "" {
@@ -40,7 +55,7 @@ func (v *Vault) CreateEntry(entry *EntryData) (string, error) {
 	// Start transaction
 	tx, err := v.db.Begin()
 	if err != nil {
-		return "", errors.Wrap(err, "could not begin transaction")
+		return "", wrapBusy(err, "could not begin transaction")
 	}
 	defer tx.Rollback()
 
@@ -56,13 +71,16 @@ func (v *Vault) CreateEntry(entry *EntryData) (string, error) {
 	}
 
 	// Insert into item table (key is stored here, not in itemfield)
-	_, err = tx.Exec(`
-		INSERT INTO item (
-			uuid, created_at, field_updated_at, title, subtitle,
-			note, trashed, deleted, category, icon, last_used, key
-		) VALUES (?, ?, ?, ?, ?, ?, 0, 0, ?, ?, ?, ?)
-	`, entryUUID, now, now, entry.Title, entry.Username,
-		entry.Notes, category, "card_password", now, itemKey)
+	columns := []string{"uuid", "created_at", "field_updated_at", "title", "subtitle", "note", "trashed", "deleted", "category", "key"}
+	values := []interface{}{entryUUID, now, now, entry.Title, entry.Username, entry.Notes, 0, 0, category, itemKey}
+	// optional columns are only set when the schema of the vault has them
+	for column, value := range map[string]interface{}{"updated_at": now, "icon": "card_password", "last_used": now} {
+		if v.hasColumn("item." + column) {
+			columns = append(columns, column)
+			values = append(values, value)
+		}
+	}
+	_, err = tx.Exec("INSERT INTO item ("+strings.Join(columns, ", ")+") VALUES (?"+strings.Repeat(", ?", len(columns)-1)+")", values...)
 	if err != nil {
 		return "", errors.Wrap(err, "could not insert item")
 	}
@@ -103,18 +121,25 @@ func (v *Vault) CreateEntry(entry *EntryData) (string, error) {
 		}
 	}
 
+	if entry.Folders != nil {
+		if err := setItemFolders(tx, entryUUID, entry.Folders, now); err != nil {
+			return "", err
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
-		return "", errors.Wrap(err, "could not commit transaction")
+		return "", wrapBusy(err, "could not commit transaction")
 	}
 
+	v.refreshStamp(entryUUID)
 	v.logger.WithField("uuid", entryUUID).Debug("created entry")
 	return entryUUID, nil
 }
 
 // UpdateEntry updates an existing entry in the vault
 func (v *Vault) UpdateEntry(entryUUID string, updates *EntryData) error {
-	if v.db == nil {
-		return errors.New("vault is not initialized")
+	if err := v.checkWritable(); err != nil {
+		return err
 	}
 
 	now := time.Now().Unix()
@@ -122,10 +147,18 @@ func (v *Vault) UpdateEntry(entryUUID string, updates *EntryData) error {
 	// Start transaction
 	tx, err := v.db.Begin()
 	if err != nil {
-		return errors.Wrap(err, "could not begin transaction")
+		return wrapBusy(err, "could not begin transaction")
 	}
 	defer tx.Rollback()
 
+	if err := v.checkStamp(tx, entryUUID); err != nil {
+		return err
+	}
+
+	if err := v.touchItem(tx, entryUUID, now); err != nil {
+		return err
+	}
+
 	// Update item table if title, notes, or category changed
 	if updates.Title != "" || updates.Notes != "" || updates.Category != "" {
 		query := "UPDATE item SET field_updated_at = ?"
@@ -230,75 +263,221 @@ func (v *Vault) UpdateEntry(entryUUID string, updates *EntryData) error {
 		}
 	}
 
+	if updates.Folders != nil {
+		if err := setItemFolders(tx, entryUUID, updates.Folders, now); err != nil {
+			return err
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
-		return errors.Wrap(err, "could not commit transaction")
+		return wrapBusy(err, "could not commit transaction")
 	}
 
+	v.refreshStamp(entryUUID)
 	v.logger.WithField("uuid", entryUUID).Debug("updated entry")
 	return nil
 }
 
 // TrashEntry moves an entry to the trash
 func (v *Vault) TrashEntry(entryUUID string) error {
-	if v.db == nil {
-		return errors.New("vault is not initialized")
+	if err := v.checkWritable(); err != nil {
+		return err
+	}
+
+	tx, err := v.db.Begin()
+	if err != nil {
+		return wrapBusy(err, "could not begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := v.checkStamp(tx, entryUUID); err != nil {
+		return err
 	}
 
 	now := time.Now().Unix()
-	result, err := v.db.Exec("UPDATE item SET trashed = 1, field_updated_at = ? WHERE uuid = ?", now, entryUUID)
+	result, err := tx.Exec("UPDATE item SET trashed = 1, field_updated_at = ? WHERE uuid = ?", now, entryUUID)
 	if err != nil {
-		return errors.Wrap(err, "could not trash entry")
+		return wrapBusy(err, "could not trash entry")
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		return errors.New("entry not found")
+		return ErrNotFound
+	}
+	if err := v.touchItem(tx, entryUUID, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return wrapBusy(err, "could not commit transaction")
 	}
 
+	v.refreshStamp(entryUUID)
 	v.logger.WithField("uuid", entryUUID).Debug("trashed entry")
 	return nil
 }
 
 // RestoreEntry restores an entry from the trash
 func (v *Vault) RestoreEntry(entryUUID string) error {
-	if v.db == nil {
-		return errors.New("vault is not initialized")
+	if err := v.checkWritable(); err != nil {
+		return err
+	}
+
+	tx, err := v.db.Begin()
+	if err != nil {
+		return wrapBusy(err, "could not begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := v.checkStamp(tx, entryUUID); err != nil {
+		return err
 	}
 
 	now := time.Now().Unix()
-	result, err := v.db.Exec("UPDATE item SET trashed = 0, field_updated_at = ? WHERE uuid = ?", now, entryUUID)
+	result, err := tx.Exec("UPDATE item SET trashed = 0, field_updated_at = ? WHERE uuid = ?", now, entryUUID)
 	if err != nil {
-		return errors.Wrap(err, "could not restore entry")
+		return wrapBusy(err, "could not restore entry")
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		return errors.New("entry not found")
+		return ErrNotFound
+	}
+	if err := v.touchItem(tx, entryUUID, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return wrapBusy(err, "could not commit transaction")
 	}
 
+	v.refreshStamp(entryUUID)
 	v.logger.WithField("uuid", entryUUID).Debug("restored entry")
 	return nil
 }
 
+// ArchiveEntry archives an entry, hiding it from the default listing
+func (v *Vault) ArchiveEntry(entryUUID string) error {
+	return v.setItemFlag(entryUUID, "archived", 1, "archived entry")
+}
+
+// UnarchiveEntry moves an entry out of the archive
+func (v *Vault) UnarchiveEntry(entryUUID string) error {
+	return v.setItemFlag(entryUUID, "archived", 0, "unarchived entry")
+}
+
+// FavoriteEntry marks an entry as favorite
+func (v *Vault) FavoriteEntry(entryUUID string) error {
+	return v.setItemFlag(entryUUID, "favorite", 1, "marked entry as favorite")
+}
+
+// UnfavoriteEntry removes the favorite mark of an entry
+func (v *Vault) UnfavoriteEntry(entryUUID string) error {
+	return v.setItemFlag(entryUUID, "favorite", 0, "removed favorite mark of entry")
+}
+
+// setItemFlag sets one of the item flag columns. column is never user input.
+func (v *Vault) setItemFlag(entryUUID string, column string, value int, action string) error {
+	if err := v.checkWritable(); err != nil {
+		return err
+	}
+	if err := v.requireColumns("item." + column); err != nil {
+		return err
+	}
+
+	tx, err := v.db.Begin()
+	if err != nil {
+		return wrapBusy(err, "could not begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := v.checkStamp(tx, entryUUID); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	query := "UPDATE item SET " + column + " = ?"
+	args := []interface{}{value}
+	if v.hasColumn("item.meta_updated_at") {
+		query += ", meta_updated_at = ?"
+		args = append(args, now)
+	}
+	result, err := tx.Exec(query+" WHERE uuid = ?", append(args, entryUUID)...)
+	if err != nil {
+		return wrapBusy(err, "could not update "+column+" of entry")
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	if err := v.touchItem(tx, entryUUID, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return wrapBusy(err, "could not commit transaction")
+	}
+
+	v.refreshStamp(entryUUID)
+	v.logger.WithField("uuid", entryUUID).Debug(action)
+	return nil
+}
+
+// RecordUsage records that an entry was used, e.g. its password was copied, by
+// updating last_used and usage_count like the Enpass apps do. It is no edit of
+// the entry, so it doesn't bump updated_at or cause conflicts.
+func (v *Vault) RecordUsage(entryUUID string) error {
+	if err := v.checkWritable(); err != nil {
+		return err
+	}
+	if err := v.requireColumns("item.last_used", "item.usage_count"); err != nil {
+		return err
+	}
+
+	result, err := v.db.Exec("UPDATE item SET last_used = ?, usage_count = COALESCE(usage_count, 0) + 1 WHERE uuid = ?",
+		time.Now().Unix(), entryUUID)
+	if err != nil {
+		return wrapBusy(err, "could not record usage of entry")
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	v.logger.WithField("uuid", entryUUID).Debug("recorded usage of entry")
+	return nil
+}
+
 // DeleteEntry permanently deletes an entry from the vault
 func (v *Vault) DeleteEntry(entryUUID string) error {
-	if v.db == nil {
-		return errors.New("vault is not initialized")
+	if err := v.checkWritable(); err != nil {
+		return err
 	}
 
 	// Start transaction
 	tx, err := v.db.Begin()
 	if err != nil {
-		return errors.Wrap(err, "could not begin transaction")
+		return wrapBusy(err, "could not begin transaction")
 	}
 	defer tx.Rollback()
 
+	if err := v.checkStamp(tx, entryUUID); err != nil {
+		return err
+	}
+
 	// Delete from itemfield first (foreign key constraint)
 	_, err = tx.Exec("DELETE FROM itemfield WHERE item_uuid = ?", entryUUID)
 	if err != nil {
 		return errors.Wrap(err, "could not delete item fields")
 	}
 
+	_, err = tx.Exec("DELETE FROM folder_items WHERE item_uuid = ?", entryUUID)
+	if err != nil {
+		return errors.Wrap(err, "could not delete folder membership")
+	}
+
 	// Delete from item
 	result, err := tx.Exec("DELETE FROM item WHERE uuid = ?", entryUUID)
 	if err != nil {
@@ -307,13 +486,14 @@ func (v *Vault) DeleteEntry(entryUUID string) error {
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		return errors.New("entry not found")
+		return ErrNotFound
 	}
 
 	if err := tx.Commit(); err != nil {
-		return errors.Wrap(err, "could not commit transaction")
+		return wrapBusy(err, "could not commit transaction")
 	}
 
+	v.refreshStamp(entryUUID)
 	v.logger.WithField("uuid", entryUUID).Debug("deleted entry")
 	return nil
 }
@@ -321,29 +501,21 @@ func (v *Vault) DeleteEntry(entryUUID string) error {
 // GetEntryByUUID retrieves a single entry by its UUID (including trashed)
 func (v *Vault) GetEntryByUUID(entryUUID string) (*Card, error) {
 	if v.db == nil {
-		return nil, errors.New("vault is not initialized")
+		return nil, ErrNotInitialized
 	}
 
 	row := v.db.QueryRow(`
-		SELECT item.uuid, itemfield.type, item.created_at, item.field_updated_at, item.title,
-		       item.subtitle, item.note, item.trashed, item.deleted, item.category,
-		       itemfield.label, itemfield.value, item.key, item.last_used, itemfield.sensitive, item.icon
+		SELECT `+v.cardColumns()+`
 		FROM item
 		INNER JOIN itemfield ON item.uuid = itemfield.item_uuid
 		WHERE item.uuid = ? AND itemfield.sensitive = 1
 		LIMIT 1
 	`, entryUUID)
 
-	var card Card
-	err := row.Scan(
-		&card.UUID, &card.Type, &card.CreatedAt, &card.UpdatedAt, &card.Title,
-		&card.Subtitle, &card.Note, &card.Trashed, &card.Deleted, &card.Category,
-		&card.Label, &card.value, &card.itemKey, &card.LastUsed, &card.Sensitive, &card.Icon,
-	)
+	card, err := v.scanCard(row)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not retrieve entry")
+		return nil, wrapBusy(err, "could not retrieve entry")
 	}
 
-	card.RawValue = card.value
 	return &card, nil
 }
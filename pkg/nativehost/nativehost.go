@@ -0,0 +1,304 @@
+// Package nativehost implements the native messaging protocol browser extensions use to talk
+// to local programs: every message is a JSON object preceded by its length as a 32-bit
+// unsigned integer in native byte order, see
+// https://developer.mozilla.org/en-US/docs/Mozilla/Add-ons/WebExtensions/Native_messaging
+package nativehost
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MaxMessageSize : browsers refuse messages from hosts larger than 1 MiB. Requests are
+	// small, so larger ones are refused as well.
+	MaxMessageSize = 1024 * 1024
+
+	// actions of requests
+	ActionStatus = "status"
+	ActionMatch  = "match"
+	ActionFill   = "fill"
+	ActionTOTP   = "totp"
+)
+
+var (
+	// ErrLocked : the vault isn't unlocked yet, send a request with a PIN first
+	ErrLocked = errors.New("vault is locked, send a request with the PIN")
+)
+
+// Request : a message from the extension. Fill and TOTP requests must name the site the
+// entry is used on, the entry must match it, and carry the PIN to confirm the request.
+type Request struct {
+	ID     int64  `json:"id"`
+	Action string `json:"action"`
+	URL    string `json:"url,omitempty"`
+	UUID   string `json:"uuid,omitempty"`
+	PIN    string `json:"pin,omitempty"`
+}
+
+// Response : the answer to the request with the same ID
+type Response struct {
+	ID     int64       `json:"id"`
+	OK     bool        `json:"ok"`
+	Error  string      `json:"error,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// Status : the result of status requests
+type Status struct {
+	Locked bool `json:"locked"`
+}
+
+// Match : an entry in the result of match requests, without secrets
+type Match struct {
+	UUID  string `json:"uuid"`
+	Title string `json:"title"`
+	Login string `json:"login"`
+	URL   string `json:"url"`
+	Match string `json:"match"`
+}
+
+// Fill : the result of fill requests
+type Fill struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// TOTP : the result of totp requests
+type TOTP struct {
+	Code string `json:"code"`
+}
+
+// Host : answers the requests of one extension on stdin and stdout
+type Host struct {
+	logger *logrus.Logger
+	vault  *enpass.Vault
+	// unlock : checks the PIN of a request and returns the opened vault
+	unlock func(pin string) (*enpass.Vault, error)
+	// now : the time TOTP codes are computed for
+	now func() time.Time
+}
+
+// NewHost : create a host. vault may be nil when it is only opened by unlock, which is called
+// with the PIN of every fill and totp request and must fail for a wrong PIN.
+func NewHost(logger *logrus.Logger, vault *enpass.Vault, unlock func(pin string) (*enpass.Vault, error)) *Host {
+	return &Host{logger: logger, vault: vault, unlock: unlock, now: time.Now}
+}
+
+// ReadMessage : read one length-prefixed message, io.EOF when the browser closed the pipe
+func ReadMessage(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.NativeEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > MaxMessageSize {
+		return nil, errors.Errorf("message of %d bytes is too large", length)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, errors.Wrap(err, "could not read message")
+	}
+	return message, nil
+}
+
+// WriteMessage : write v as a length-prefixed JSON message
+func WriteMessage(w io.Writer, v interface{}) error {
+	message, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal message")
+	}
+	if len(message) > MaxMessageSize {
+		return errors.Errorf("message of %d bytes is too large", len(message))
+	}
+	if err := binary.Write(w, binary.NativeEndian, uint32(len(message))); err != nil {
+		return errors.Wrap(err, "could not write message")
+	}
+	_, err = w.Write(message)
+	return errors.Wrap(err, "could not write message")
+}
+
+// Serve : answer requests until the browser closes r
+func (h *Host) Serve(r io.Reader, w io.Writer) error {
+	for {
+		message, err := ReadMessage(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var request Request
+		var response Response
+		if err := json.Unmarshal(message, &request); err != nil {
+			response = Response{Error: "invalid request: " + err.Error()}
+		} else {
+			response = h.Handle(&request)
+		}
+		if err := WriteMessage(w, response); err != nil {
+			return err
+		}
+	}
+}
+
+// Handle : answer a single request
+func (h *Host) Handle(request *Request) Response {
+	log := h.logger.WithField("action", request.Action).WithField("id", request.ID)
+	result, err := h.handle(request)
+	if err != nil {
+		log.WithError(err).Debug("request failed")
+		return Response{ID: request.ID, Error: err.Error()}
+	}
+	log.Debug("request answered")
+	return Response{ID: request.ID, OK: true, Result: result}
+}
+
+func (h *Host) handle(request *Request) (interface{}, error) {
+	switch request.Action {
+	case ActionStatus:
+		return Status{Locked: h.vault == nil}, nil
+	case ActionMatch:
+		return h.match(request)
+	case ActionFill:
+		return h.fill(request)
+	case ActionTOTP:
+		return h.totp(request)
+	default:
+		return nil, errors.New("unknown action " + request.Action)
+	}
+}
+
+// match : the entries for the site, best match first
+func (h *Host) match(request *Request) ([]Match, error) {
+	if request.PIN != "" {
+		if err := h.confirm(request.PIN); err != nil {
+			return nil, err
+		}
+	}
+	if h.vault == nil {
+		return nil, ErrLocked
+	}
+
+	urlMatches, err := h.vault.MatchURL(request.URL)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]Match, 0, len(urlMatches))
+	for _, m := range urlMatches {
+		if m.IsArchived() {
+			continue
+		}
+		matches = append(matches, Match{UUID: m.UUID, Title: m.Title, Login: m.Subtitle, URL: m.RawValue, Match: m.Kind.String()})
+	}
+	return matches, nil
+}
+
+// confirm : check the PIN of a request, opening the vault with the first correct one
+func (h *Host) confirm(pin string) error {
+	if pin == "" {
+		return errors.New("request needs the PIN")
+	}
+	vault, err := h.unlock(pin)
+	if err != nil {
+		return errors.Wrap(err, "PIN not accepted")
+	}
+	h.vault = vault
+	return nil
+}
+
+// siteEntry : the fields of the entry of a fill or totp request, after confirming the
+// PIN and that the entry belongs to the site
+func (h *Host) siteEntry(request *Request) ([]enpass.Card, error) {
+	if err := h.confirm(request.PIN); err != nil {
+		return nil, err
+	}
+	if request.UUID == "" || request.URL == "" {
+		return nil, errors.New("request needs the url and uuid of the entry")
+	}
+
+	matches, err := h.vault.MatchURL(request.URL)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		if m.UUID == request.UUID && !m.IsArchived() {
+			return h.vault.GetEntryFields(request.UUID)
+		}
+	}
+	return nil, errors.New("entry " + request.UUID + " has no URL of " + request.URL)
+}
+
+func (h *Host) fill(request *Request) (*Fill, error) {
+	fields, err := h.siteEntry(request)
+	if err != nil {
+		return nil, err
+	}
+
+	fill := &Fill{Username: fieldValue(fields, "username")}
+	if fill.Username == "" {
+		fill.Username = fieldValue(fields, "email")
+	}
+	for _, field := range fields {
+		if field.Type == "password" && field.RawValue != "" {
+			if fill.Password, err = field.Decrypt(); err != nil {
+				return nil, errors.Wrap(err, "could not decrypt password")
+			}
+			break
+		}
+	}
+	return fill, nil
+}
+
+func (h *Host) totp(request *Request) (*TOTP, error) {
+	fields, err := h.siteEntry(request)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range fields {
+		if strings.ToLower(field.Type) != "totp" || field.RawValue == "" {
+			continue
+		}
+		secret, err := field.Decrypt()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not decrypt TOTP secret")
+		}
+		code, err := enpass.ComputeTOTP(secret, h.now())
+		if err != nil {
+			return nil, err
+		}
+		return &TOTP{Code: code}, nil
+	}
+	return nil, errors.New("entry has no TOTP field")
+}
+
+// fieldValue : the first value of a field of the given type
+func fieldValue(fields []enpass.Card, fieldType string) string {
+	for _, field := range fields {
+		if field.Type == fieldType && field.RawValue != "" {
+			return field.RawValue
+		}
+	}
+	return ""
+}
+
+// ExtensionID : the ID of the extension that started the host, from the arguments the browser
+// passes: Firefox passes the path of the manifest and the extension ID, Chromium the origin
+// chrome-extension://<id>/ and, on Windows, the parent window
+func ExtensionID(args []string) string {
+	for _, arg := range args {
+		if origin := strings.TrimPrefix(arg, "chrome-extension://"); origin != arg {
+			return strings.TrimSuffix(origin, "/")
+		}
+	}
+	if len(args) >= 2 && strings.HasSuffix(args[0], ".json") {
+		return args[1]
+	}
+	return ""
+}
@@ -0,0 +1,178 @@
+package nativehost
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/hazcod/enpass-cli/internal/testvault"
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const testPIN = "12345678"
+
+// frame encodes requests the way the browser writes them to stdin
+func frame(t *testing.T, requests ...interface{}) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, request := range requests {
+		// strings are sent as they are, to send invalid JSON
+		if raw, ok := request.(string); ok {
+			_ = binary.Write(&buf, binary.NativeEndian, uint32(len(raw)))
+			buf.WriteString(raw)
+			continue
+		}
+		if err := WriteMessage(&buf, request); err != nil {
+			t.Fatalf("could not frame request: %v", err)
+		}
+	}
+	return &buf
+}
+
+// responses decodes everything the host wrote to stdout
+func responses(t *testing.T, r io.Reader) []Response {
+	t.Helper()
+	var all []Response
+	for {
+		message, err := ReadMessage(r)
+		if err == io.EOF {
+			return all
+		} else if err != nil {
+			t.Fatalf("could not read response: %v", err)
+		}
+		var response Response
+		if err := json.Unmarshal(message, &response); err != nil {
+			t.Fatalf("invalid response %s: %v", message, err)
+		}
+		all = append(all, response)
+	}
+}
+
+func result(t *testing.T, response Response, v interface{}) {
+	t.Helper()
+	if !response.OK {
+		t.Fatalf("request %d failed: %s", response.ID, response.Error)
+	}
+	data, _ := json.Marshal(response.Result)
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("invalid result of request %d: %v", response.ID, err)
+	}
+}
+
+func TestHost_Serve(t *testing.T) {
+	vault, whateverUUID := testvault.Open(t)
+	entryUUID, err := vault.CreateEntry(&enpass.EntryData{
+		Title: "Example", Username: "jdoe", Password: "s3cret", URL: "https://example.com/login",
+	})
+	if err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+	if err := vault.UpdateEntry(whateverUUID, &enpass.EntryData{URL: "https://whatever.com"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+
+	unlocks := 0
+	host := NewHost(logrus.New(), nil, func(pin string) (*enpass.Vault, error) {
+		unlocks++
+		if pin != testPIN {
+			return nil, errors.New("wrong PIN")
+		}
+		return vault, nil
+	})
+
+	stdin := frame(t,
+		Request{ID: 1, Action: ActionStatus},
+		Request{ID: 2, Action: ActionMatch, URL: "https://www.example.com"},
+		Request{ID: 3, Action: ActionFill, URL: "https://www.example.com", UUID: entryUUID, PIN: "00000000"},
+		Request{ID: 4, Action: ActionMatch, URL: "https://www.example.com", PIN: testPIN},
+		Request{ID: 5, Action: ActionFill, URL: "https://www.example.com", UUID: entryUUID, PIN: testPIN},
+		Request{ID: 6, Action: ActionFill, URL: "https://www.example.com", UUID: entryUUID},
+		Request{ID: 7, Action: ActionFill, URL: "https://evil.example.org", UUID: entryUUID, PIN: testPIN},
+		Request{ID: 8, Action: ActionTOTP, URL: "https://whatever.com/", UUID: whateverUUID, PIN: testPIN},
+		`{"id": 9, "action": "frobnicate"}`,
+		`not json`,
+	)
+	var stdout bytes.Buffer
+	if err := host.Serve(stdin, &stdout); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+
+	all := responses(t, &stdout)
+	if len(all) != 10 {
+		t.Fatalf("expected 10 responses, got %d", len(all))
+	}
+
+	var status Status
+	result(t, all[0], &status)
+	if !status.Locked {
+		t.Error("expected the vault to be locked before the first PIN")
+	}
+	if all[1].OK || all[1].Error != ErrLocked.Error() {
+		t.Errorf("expected a locked error, got %+v", all[1])
+	}
+	if all[2].OK {
+		t.Error("expected a wrong PIN to be refused")
+	}
+
+	var matches []Match
+	result(t, all[3], &matches)
+	if len(matches) != 1 || matches[0].UUID != entryUUID || matches[0].Match != "domain" || matches[0].Login != "jdoe" {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+
+	var fill Fill
+	result(t, all[4], &fill)
+	if fill.Username != "jdoe" || fill.Password != "s3cret" {
+		t.Errorf("unexpected fill %+v", fill)
+	}
+	// every request revealing a secret is confirmed, even once unlocked
+	if all[5].OK {
+		t.Error("expected a fill without PIN to be refused")
+	}
+	if all[6].OK {
+		t.Error("expected a fill for another site to be refused")
+	}
+
+	// the TOTP field of the test entry is empty
+	if all[7].OK || all[7].Error != "entry has no TOTP field" {
+		t.Errorf("expected the empty TOTP field to fail, got %+v", all[7])
+	}
+
+	if all[8].OK || all[8].ID != 9 {
+		t.Errorf("expected unknown actions to fail, got %+v", all[8])
+	}
+	if all[9].OK {
+		t.Error("expected invalid JSON to fail")
+	}
+	if unlocks != 5 {
+		t.Errorf("expected every request with a PIN to be confirmed, got %d confirmations", unlocks)
+	}
+}
+
+func TestReadMessage_TooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 0xff, 0xff, 0x7f})
+	if _, err := ReadMessage(&buf); err == nil {
+		t.Error("expected oversized messages to be refused")
+	}
+	if _, err := ReadMessage(bytes.NewReader([]byte{5, 0, 0, 0, '{'})); err == nil {
+		t.Error("expected truncated messages to fail")
+	}
+}
+
+func TestExtensionID(t *testing.T) {
+	tests := map[string][]string{
+		"enpass-cli@example.org": {"/usr/lib/mozilla/native-messaging-hosts/enpass_cli.json", "enpass-cli@example.org"},
+		"abcdefghijklmnop":       {"chrome-extension://abcdefghijklmnop/", "--parent-window=0"},
+		"":                       {},
+	}
+	for expected, args := range tests {
+		if id := ExtensionID(args); id != expected {
+			t.Errorf("ExtensionID(%v) = %q, expected %q", args, id, expected)
+		}
+	}
+}
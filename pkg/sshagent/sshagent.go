@@ -0,0 +1,247 @@
+// Package sshagent serves SSH private keys stored in an Enpass vault over the SSH agent
+// protocol, so ssh and git use them without the keys ever being written to disk.
+package sshagent
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// DefaultKeyLabel : label of the fields holding private keys in any entry
+	DefaultKeyLabel = "Private Key"
+	// PassphraseLabel : label of the field holding the passphrase of an encrypted key
+	PassphraseLabel = "Passphrase"
+
+	privateKeyMarker = "PRIVATE KEY-----"
+)
+
+// ErrDenied : the signature was not confirmed
+var ErrDenied = errors.New("signature was denied")
+
+// Options : where keys are looked for in the vault
+type Options struct {
+	// Category : entries of this category hold a private key in their notes, in any field or
+	// in any attachment
+	Category string
+	// Labels : labels of fields, and names of attachments, holding a private key in entries of
+	// every category
+	Labels []string
+}
+
+// Agent : an SSH agent holding the keys of a vault. Clients may add and remove keys for the
+// lifetime of the agent; the vault is never modified.
+type Agent struct {
+	agent.ExtendedAgent
+	logger  *logrus.Logger
+	confirm func(comment string) bool
+}
+
+// New : create an agent without keys. When confirm is set, it is asked before every
+// signature with the comment of the key, and the signature is denied when it returns false.
+func New(logger *logrus.Logger, confirm func(comment string) bool) *Agent {
+	return &Agent{
+		ExtendedAgent: agent.NewKeyring().(agent.ExtendedAgent),
+		logger:        logger,
+		confirm:       confirm,
+	}
+}
+
+// LoadVault : add the private keys of the vault, commented with the title of their entry.
+// Keys that can't be parsed or decrypted are skipped with a warning. It returns the number
+// of keys added.
+func (a *Agent) LoadVault(vault *enpass.Vault, options *Options) (int, error) {
+	labels := options.Labels
+	if len(labels) == 0 {
+		labels = []string{DefaultKeyLabel}
+	}
+
+	fields, err := vault.GetAllFields("", nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not retrieve entries")
+	}
+	entries := map[string][]enpass.Card{}
+	order := make([]string, 0)
+	for _, field := range fields {
+		if field.IsTrashed() || field.IsDeleted() {
+			continue
+		}
+		if _, seen := entries[field.UUID]; !seen {
+			order = append(order, field.UUID)
+		}
+		entries[field.UUID] = append(entries[field.UUID], field)
+	}
+
+	attachments, err := vault.GetAttachments("")
+	if err != nil {
+		return 0, errors.Wrap(err, "could not retrieve attachments")
+	}
+	files := map[string][]keyFile{}
+	for i := range attachments {
+		attachment := &attachments[i]
+		files[attachment.ItemUUID] = append(files[attachment.ItemUUID], keyFile{name: attachment.Name, read: attachment.Decrypt})
+	}
+
+	added := 0
+	for _, entryUUID := range order {
+		entry := entries[entryUUID]
+		log := a.logger.WithField("title", entry[0].Title)
+		inCategory := options.Category != "" && strings.EqualFold(entry[0].Category, options.Category)
+		keys := entryKeys(entry, options.Category, labels)
+		for _, pemKey := range append(keys, attachmentKeys(log, files[entryUUID], inCategory, labels)...) {
+			key, err := parseKey(pemKey, entry)
+			if err != nil {
+				log.WithError(err).Warn("skipping private key")
+				continue
+			}
+			if err := a.Add(agent.AddedKey{PrivateKey: key, Comment: entry[0].Title}); err != nil {
+				log.WithError(err).Warn("skipping private key")
+				continue
+			}
+			log.Debug("added private key")
+			added++
+		}
+	}
+	return added, nil
+}
+
+// entryKeys : the private keys of an entry, in its notes or fields when it has the category,
+// or in fields with one of the labels
+func entryKeys(entry []enpass.Card, category string, labels []string) []string {
+	inCategory := category != "" && strings.EqualFold(entry[0].Category, category)
+	keys := make([]string, 0)
+	if inCategory && strings.Contains(entry[0].Note, privateKeyMarker) {
+		keys = append(keys, entry[0].Note)
+	}
+	for _, field := range entry {
+		labelled := false
+		for _, label := range labels {
+			labelled = labelled || strings.EqualFold(field.Label, label)
+		}
+		if !labelled && !inCategory {
+			continue
+		}
+		value, err := field.Decrypt()
+		if err != nil || !strings.Contains(value, privateKeyMarker) {
+			continue
+		}
+		keys = append(keys, value)
+	}
+	return keys
+}
+
+// keyFile : an attachment that may hold a private key
+type keyFile struct {
+	name string
+	read func() ([]byte, error)
+}
+
+// attachmentKeys : the private keys in the attachments of an entry: any attachment when the
+// entry has the category, else those named after one of the labels or like the key files of
+// ssh-keygen, e.g. id_ed25519. Attachments that can't be read are skipped with a warning.
+func attachmentKeys(log *logrus.Entry, files []keyFile, inCategory bool, labels []string) []string {
+	keys := make([]string, 0)
+	for _, file := range files {
+		name := strings.ToLower(file.name)
+		wanted := inCategory || (strings.HasPrefix(name, "id_") && !strings.HasSuffix(name, ".pub"))
+		for _, label := range labels {
+			wanted = wanted || name == strings.ToLower(label) || strings.TrimSuffix(name, path.Ext(name)) == strings.ToLower(label)
+		}
+		if !wanted {
+			continue
+		}
+		content, err := file.read()
+		if err != nil {
+			log.WithError(err).WithField("attachment", file.name).Warn("skipping attachment")
+			continue
+		}
+		if strings.Contains(string(content), privateKeyMarker) {
+			keys = append(keys, string(content))
+		}
+	}
+	return keys
+}
+
+// parseKey : parse a PEM private key, decrypting it with the passphrase field of the entry,
+// or else with one of its passwords
+func parseKey(pemKey string, entry []enpass.Card) (interface{}, error) {
+	key, err := ssh.ParseRawPrivateKey([]byte(pemKey))
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return key, err
+	}
+
+	candidates := make([]enpass.Card, 0)
+	for _, field := range entry {
+		if strings.EqualFold(field.Label, PassphraseLabel) {
+			candidates = append([]enpass.Card{field}, candidates...)
+		} else if field.Type == "password" {
+			candidates = append(candidates, field)
+		}
+	}
+	for _, field := range candidates {
+		passphrase, err := field.Decrypt()
+		if err != nil || passphrase == "" {
+			continue
+		}
+		if key, err := ssh.ParseRawPrivateKeyWithPassphrase([]byte(pemKey), []byte(passphrase)); err == nil {
+			return key, nil
+		}
+	}
+	return nil, errors.New("key is encrypted and no " + PassphraseLabel + " or password field of the entry decrypts it")
+}
+
+// Sign : sign data with the key, after confirming it
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags : sign data with the key and signature flags, after confirming it
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if a.confirm != nil && !a.confirm(a.comment(key)) {
+		a.logger.WithField("key", ssh.FingerprintSHA256(key)).Info("signature denied")
+		return nil, ErrDenied
+	}
+	return a.ExtendedAgent.SignWithFlags(key, data, flags)
+}
+
+// comment : the comment of the key, its fingerprint when the agent doesn't hold it
+func (a *Agent) comment(key ssh.PublicKey) string {
+	keys, err := a.List()
+	if err == nil {
+		for _, k := range keys {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return k.Comment + " (" + ssh.FingerprintSHA256(key) + ")"
+			}
+		}
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+// Serve : answer the clients connecting to the listener until it is closed
+func (a *Agent) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "could not accept connection")
+		}
+		go func() {
+			defer conn.Close()
+			// clients hang up once they are done
+			if err := agent.ServeAgent(a, conn); err != nil && err != io.EOF {
+				a.logger.WithError(err).Debug("agent connection failed")
+			}
+		}()
+	}
+}
@@ -0,0 +1,222 @@
+package sshagent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/hazcod/enpass-cli/internal/testvault"
+	"github.com/hazcod/enpass-cli/pkg/enpass"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newKey generates an ed25519 key in OpenSSH PEM form, encrypted when passphrase is set
+func newKey(t *testing.T, passphrase string) (ssh.PublicKey, string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(private, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(private, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("could not marshal key: %v", err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("could not convert public key: %v", err)
+	}
+	return sshPublic, string(pem.EncodeToMemory(block))
+}
+
+// serveAgent serves the agent on a Unix socket and returns a client of it
+func serveAgent(t *testing.T, a *Agent) agent.ExtendedAgent {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go a.Serve(listener)
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("could not connect to agent: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return agent.NewClient(conn)
+}
+
+// sshLogin authenticates an in-process ssh client with the agent's keys against an
+// in-process server accepting only the given key
+func sshLogin(t *testing.T, client agent.ExtendedAgent, accepted ssh.PublicKey) error {
+	t.Helper()
+	_, hostPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatalf("could not create host key: %v", err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(accepted.Marshal()) {
+				return &ssh.Permissions{}, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	// both ends write their version first, so they need a buffered connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		serverSide, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer serverSide.Close()
+		if conn, _, _, err := ssh.NewServerConn(serverSide, serverConfig); err == nil {
+			conn.Close()
+		}
+	}()
+	clientSide, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	defer clientSide.Close()
+
+	clientConfig := &ssh.ClientConfig{
+		User:            "git",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(client.Signers)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	conn, _, _, err := ssh.NewClientConn(clientSide, listener.Addr().String(), clientConfig)
+	if err == nil {
+		conn.Close()
+	}
+	return err
+}
+
+func TestAgent_LoadVault(t *testing.T) {
+	vault, _ := testvault.Open(t)
+
+	plainKey, plainPEM := newKey(t, "")
+	encryptedKey, encryptedPEM := newKey(t, "correct horse")
+	_, undecryptablePEM := newKey(t, "forgotten")
+	for _, entry := range []*enpass.EntryData{
+		{Title: "Deploy key", Category: "ssh", Username: "git", Notes: plainPEM},
+		{Title: "Encrypted key", Category: "ssh", Notes: encryptedPEM, Password: "correct horse"},
+		{Title: "Undecryptable key", Category: "ssh", Notes: undecryptablePEM, Password: "wrong"},
+		{Title: "Note elsewhere", Category: "note", Username: "git", Notes: plainPEM},
+	} {
+		if _, err := vault.CreateEntry(entry); err != nil {
+			t.Fatalf("CreateEntry failed: %v", err)
+		}
+	}
+
+	a := New(logrus.New(), nil)
+	added, err := a.LoadVault(vault, &Options{Category: "SSH"})
+	if err != nil {
+		t.Fatalf("LoadVault failed: %v", err)
+	}
+	if added != 2 {
+		t.Fatalf("expected 2 keys, got %d", added)
+	}
+
+	client := serveAgent(t, a)
+	keys, err := client.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	comments := map[string]string{}
+	for _, key := range keys {
+		comments[string(key.Marshal())] = key.Comment
+	}
+	if comments[string(plainKey.Marshal())] != "Deploy key" || comments[string(encryptedKey.Marshal())] != "Encrypted key" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	if err := sshLogin(t, client, encryptedKey); err != nil {
+		t.Errorf("ssh login with the decrypted key failed: %v", err)
+	}
+
+	// without a category, only fields labelled Private Key are searched
+	added, err = New(logrus.New(), nil).LoadVault(vault, &Options{})
+	if err != nil || added != 0 {
+		t.Errorf("expected no keys outside the category, got %d, %v", added, err)
+	}
+}
+
+func TestAttachmentKeys(t *testing.T) {
+	_, pemKey := newKey(t, "")
+	file := func(name string, content string) keyFile {
+		return keyFile{name: name, read: func() ([]byte, error) { return []byte(content), nil }}
+	}
+	files := []keyFile{
+		file("id_ed25519", pemKey),
+		file("id_ed25519.pub", pemKey),
+		file("private key.pem", pemKey),
+		file("notes.txt", pemKey),
+		file("id_rsa", "not a key"),
+		{name: "id_ecdsa", read: func() ([]byte, error) { return nil, errors.New("kept in a file") }},
+	}
+	log := logrus.NewEntry(logrus.New())
+
+	tests := []struct {
+		name       string
+		inCategory bool
+		expected   int
+	}{
+		{"other category", false, 2},
+		{"in category", true, 4},
+	}
+	for _, test := range tests {
+		if keys := attachmentKeys(log, files, test.inCategory, []string{DefaultKeyLabel}); len(keys) != test.expected {
+			t.Errorf("%s: expected %d keys, got %d", test.name, test.expected, len(keys))
+		}
+	}
+}
+
+func TestAgent_Confirm(t *testing.T) {
+	vault, _ := testvault.Open(t)
+	key, keyPEM := newKey(t, "")
+	if _, err := vault.CreateEntry(&enpass.EntryData{Title: "Deploy key", Category: "ssh", Username: "git", Notes: keyPEM}); err != nil {
+		t.Fatalf("CreateEntry failed: %v", err)
+	}
+
+	var asked []string
+	allow := false
+	a := New(logrus.New(), func(comment string) bool {
+		asked = append(asked, comment)
+		return allow
+	})
+	if _, err := a.LoadVault(vault, &Options{Category: "ssh"}); err != nil {
+		t.Fatalf("LoadVault failed: %v", err)
+	}
+	client := serveAgent(t, a)
+
+	if err := sshLogin(t, client, key); err == nil {
+		t.Error("expected the login to fail when the signature is denied")
+	}
+	allow = true
+	if err := sshLogin(t, client, key); err != nil {
+		t.Errorf("expected the confirmed login to succeed: %v", err)
+	}
+	if len(asked) != 2 || asked[0] != "Deploy key ("+ssh.FingerprintSHA256(key)+")" {
+		t.Errorf("unexpected confirmations %v", asked)
+	}
+}